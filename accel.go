@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	accelTCG = "tcg"
+	// TCG counting instructions instead of following the host clock, see --deterministic
+	accelDeterministic = "deterministic"
+)
+
+/*
+ * Picks the fastest accelerator qemu can use here: KVM when /dev/kvm is accessible on Linux, HVF when
+ * qemu supports it on macOS, and multi-threaded TCG otherwise or when noAccel is set.
+ */
+func probeAccel(noAccel bool) string {
+	if noAccel || !nativeArch() {
+		return accelTCG
+	}
+	switch runtime.GOOS {
+	case "linux":
+		if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+			f.Close()
+			return "kvm"
+		}
+	case "darwin":
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, QemuPath, "-accel", "help").Output()
+		if err == nil && strings.Contains(string(out), "hvf") {
+			return "hvf"
+		}
+	}
+	return accelTCG
+}
+
+/*
+ * qemu tries the -accel options in order, so it falls back to TCG by itself if it refuses the accelerator.
+ * A tbSizeMB above 0 caps TCG's translation cache, see hostMemLimit.
+ */
+func accelArgs(accel string, smp string, tbSizeMB int) []string {
+	tcg := "tcg"
+	if tbSizeMB > 0 && qemuVersion.tbSize() {
+		tcg += fmt.Sprintf(",tb-size=%d", tbSizeMB)
+	}
+	if accel == accelDeterministic {
+		// guest time advances with executed instructions, which multi-threaded TCG can't do
+		return []string{"-accel", tcg, "-icount", "shift=auto,sleep=off"}
+	}
+	if qemuVersion.multiThreadTCG(smp) {
+		tcg += ",thread=multi"
+	}
+	if accel == accelTCG {
+		return []string{"-accel", tcg}
+	}
+	return []string{"-accel", accel, "-accel", tcg}
+}
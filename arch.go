@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// what differs between the architectures a project can target
+type archProfile struct {
+	// qemu system binary, looked up on the PATH
+	binary string
+	// machine arguments, including the device the kernel exits qemu through
+	args []string
+	// extra -drive options for the boot image, e.g. virtio on machines without IDE
+	driveOptions string
+	// qemu exit codes of a kernel that shut down normally; failures are judged by the output
+	cleanExits []int
+	// runtime.GOARCH of hosts that can run it with KVM or HVF
+	hostArch string
+}
+
+const defaultArch = "x86_64"
+
+var archProfiles = map[string]archProfile{
+	"x86_64": {
+		binary: "qemu-system-x86_64",
+		// isa-debug-exit turns the kernel's exit code 0 into qemu's 1
+		args:       []string{"-cpu", "max", "-device", "isa-debug-exit,iobase=0xf4,iosize=0x04"},
+		cleanExits: []int{0, 1},
+		hostArch:   "amd64",
+	},
+	"riscv64": {
+		binary: "qemu-system-riscv64",
+		// the virt machine's test finisher exits qemu with the code the kernel writes to it
+		args:         []string{"-machine", "virt", "-bios", "default"},
+		driveOptions: ",if=virtio",
+		cleanExits:   []int{0},
+		hostArch:     "riscv64",
+	},
+	"aarch64": {
+		binary: "qemu-system-aarch64",
+		// the kernel exits through semihosting or PSCI
+		args:         []string{"-machine", "virt", "-cpu", "max", "-semihosting"},
+		driveOptions: ",if=virtio",
+		cleanExits:   []int{0},
+		hostArch:     "arm64",
+	},
+}
+
+// the profile of the architecture under test, set once at startup by selectArch
+var qemuArch = archProfiles[defaultArch]
+
+func archNames() []string {
+	var names []string
+	for name := range archProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+ * Switches to the given architecture and its qemu binary. x86_64 keeps the qemu path embedded at
+ * build time if there is one. A binary missing from the PATH only fails once a test runs, so modes
+ * like --list still work.
+ */
+func selectArch(name string) error {
+	profile, ok := archProfiles[name]
+	if !ok {
+		return fmt.Errorf("unknown architecture %q, expected one of %s", name, strings.Join(archNames(), ", "))
+	}
+	qemuArch = profile
+	if name == defaultArch && QemuPath != "" {
+		return nil
+	}
+
+	QemuPath = profile.binary
+	if path, err := exec.LookPath(profile.binary); err == nil {
+		QemuPath = path
+	}
+	return nil
+}
+
+// whether qemu exited the way a kernel that ran to completion makes it
+func cleanExit(code int) bool {
+	return slices.Contains(qemuArch.cleanExits, code)
+}
+
+// hardware acceleration only works when the guest has the host's architecture
+func nativeArch() bool {
+	return qemuArch.hostArch == runtime.GOARCH
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+ * Generated files for a test. They land next to the test's Makefile, or with --out-dir in a folder
+ * per test under the output directory (per project too in batch mode).
+ */
+
+func artifactDir(outDir string, project string, makefileDir string, testName string) string {
+	if outDir == "" {
+		return makefileDir
+	}
+	return absPath(filepath.Join(outDir, project, testName))
+}
+
+func artifactPath(t testInfo, ext string) string {
+	return filepath.Join(t.artifactDir, t.baseName+ext)
+}
+
+func rawPath(t testInfo) string {
+	return artifactPath(t, ".raw")
+}
+
+func outPath(t testInfo) string {
+	return artifactPath(t, ".out")
+}
+
+func panicPath(t testInfo) string {
+	return artifactPath(t, ".panic")
+}
+
+func diffPath(t testInfo) string {
+	return artifactPath(t, ".diff")
+}
+
+// the second serial port, written with --capture-debug
+func debugLogPath(t testInfo) string {
+	return artifactPath(t, ".debug.log")
+}
+
+// qemu's stderr of an iteration, 1-based, written with --capture-stderr
+func stderrPath(t testInfo, iteration int) string {
+	return artifactPath(t, fmt.Sprintf(".iter%d.stderr", iteration))
+}
+
+var artifactExts = []string{".raw", ".out", ".diff", ".panic", ".debug.log", ".expected"}
+
+// removes the test's generated files, and its folder under --out-dir once empty; returns how many were removed
+func removeArtifacts(t testInfo) int {
+	var removed int
+	for _, ext := range artifactExts {
+		if err := os.Remove(artifactPath(t, ext)); err == nil {
+			removed++
+		}
+	}
+	stderrFiles, _ := filepath.Glob(artifactPath(t, ".iter*.stderr"))
+	for _, path := range stderrFiles {
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+	}
+	if t.artifactDir != t.makefileDir {
+		_ = os.Remove(t.artifactDir)
+	}
+	return removed
+}
+
+/*
+ * `grunner clean`: removes the generated files of the discovered tests without running anything.
+ * With --projects every project is cleaned like a batch run would lay it out, so the per-project
+ * folders under --out-dir are found too.
+ */
+func runClean(flags *argumentConfig, args []string) error {
+	projects := []string{""}
+	if flags.Projects != "" {
+		var err error
+		if projects, err = listProjects(flags.Projects); err != nil {
+			return err
+		}
+	}
+
+	var removed, cleaned int
+	for _, project := range projects {
+		projectTests := args
+		var projectName string
+		if project != "" {
+			projectTests = projectArgs(project, args)
+			projectName = filepath.Base(project)
+		}
+
+		testFiles, _, err := discoverTests(projectTests, flags)
+		if missingTestsOnly(err) && (flags.IgnoreMissing || project != "") {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("WARNING: %s.", err)))
+		} else if err != nil {
+			return err
+		}
+
+		for _, testFile := range testFiles {
+			makefile, err := findMakefile(filepath.Dir(testFile.filePath))
+			if err != nil {
+				return err
+			}
+			makefileDir := filepath.Dir(makefile)
+			removed += removeArtifacts(testInfo{
+				name:        testFile.testName,
+				baseName:    testFile.baseName,
+				makefileDir: makefileDir,
+				artifactDir: artifactDir(flags.OutDir, projectName, makefileDir, testFile.testName),
+			})
+		}
+		cleaned += len(testFiles)
+		if flags.OutDir != "" && projectName != "" {
+			// only goes once the project's test folders are all gone
+			_ = os.Remove(filepath.Join(flags.OutDir, projectName))
+		}
+	}
+	if cleaned == 0 {
+		return fmt.Errorf("no test files found")
+	}
+	fmt.Println(grayStyle.Render(fmt.Sprintf("Removed %d generated file(s) of %d test(s).", removed, cleaned)))
+	return nil
+}
+
+/*
+ * Scratch files of a run, like QMP sockets and normalized .ok files, go in a temp dir of their own,
+ * under --out-dir when given and the system temp dir otherwise. It is removed once the run is over
+ * unless --keep-tmp is given.
+ */
+func createRunTmpDir(outDir string) (string, error) {
+	if outDir == "" {
+		return os.MkdirTemp("", "grunner-run-")
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(outDir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	return absPath(dir), nil
+}
+
+func removeRunTmpDir(dir string, keep bool) {
+	if keep {
+		fmt.Println(grayStyle.Render("kept temporary files in " + dir))
+		return
+	}
+	_ = os.RemoveAll(dir)
+}
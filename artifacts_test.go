@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArtifactPathsIndependentOfCwd(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "repo")
+	writeTree(t, base, map[string]string{
+		"repo/Makefile":    "t1:\n\ttrue\n",
+		"repo/tests/t1.cc": "",
+		"repo/tests/t1.ok": "",
+		"elsewhere/":       "",
+	})
+	// resolved like discovery does, symlinks included, as the temp dir can be behind one
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(base, "out")
+
+	tests := []struct {
+		name string
+		cwd  string
+		arg  string
+	}{
+		{"from the repo root", "repo", "tests/t1.cc"},
+		{"from the tests dir", "repo/tests", "t1.cc"},
+		{"from an unrelated dir", "elsewhere", "../repo/tests/t1"},
+	}
+	for _, tt := range tests {
+		for _, out := range []string{"", outDir} {
+			t.Run(tt.name+" out-dir="+out, func(t *testing.T) {
+				chdir(t, filepath.Join(base, tt.cwd))
+				testFiles, err := findTestFiles([]string{tt.arg}, defaultMaxDepth)
+				if err != nil || len(testFiles) != 1 {
+					t.Fatalf("findTestFiles(%q) = %v, %v", tt.arg, testFiles, err)
+				}
+				makefileDirs, err := findMakefileDirs(testFiles)
+				if err != nil {
+					t.Fatal(err)
+				}
+				testFile := testFiles[0]
+				test := testInfo{
+					baseName:    testFile.baseName,
+					filePath:    testFile.filePath,
+					makefileDir: makefileDirs[0],
+					artifactDir: artifactDir(out, "", makefileDirs[0], testFile.testName),
+					okFile:      expectedOutputFile(testFile.filePath, testFile.baseName, false, projectConfig{}),
+				}
+
+				wantDir := root
+				if out != "" {
+					wantDir = filepath.Join(outDir, "t1")
+				}
+				resolved, _ := filepath.EvalSymlinks(test.makefileDir)
+				if resolved != root {
+					t.Errorf("makefileDir = %s, want %s", test.makefileDir, root)
+				}
+				for _, path := range []string{test.artifactDir, outPath(test), diffPath(test), okPath(test)} {
+					if !filepath.IsAbs(path) {
+						t.Errorf("%s is relative", path)
+					}
+				}
+				if got, _ := filepath.EvalSymlinks(filepath.Dir(okPath(test))); got != filepath.Join(root, "tests") {
+					t.Errorf("okPath = %s, want it in %s", okPath(test), filepath.Join(root, "tests"))
+				}
+				if got := filepath.Dir(diffPath(test)); got != wantDir && !sameDir(got, wantDir) {
+					t.Errorf("diffPath = %s, want it in %s", diffPath(test), wantDir)
+				}
+				if filepath.Base(outPath(test)) != "t1.out" {
+					t.Errorf("outPath = %s, want t1.out", outPath(test))
+				}
+			})
+		}
+	}
+}
+
+// whether two paths name the same directory once symlinks are resolved
+func sameDir(a string, b string) bool {
+	ra, errA := filepath.EvalSymlinks(a)
+	rb, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && ra == rb
+}
+
+func TestCleanProjectsOutDir(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, map[string]string{
+		"projects/p1/Makefile":    "t1:\n\ttrue\n",
+		"projects/p1/tests/t1.cc": "",
+		"projects/p1/tests/t1.ok": "",
+		"projects/p2/Makefile":    "t2:\n\ttrue\n",
+		"projects/p2/tests/t2.cc": "",
+		"projects/p2/tests/t2.ok": "",
+		"out/p1/t1/t1.out":        "",
+		"out/p1/t1/t1.diff":       "",
+		"out/p2/t2/t2.out":        "",
+		"out/p2/t2/notes.txt":     "",
+	})
+	out := filepath.Join(base, "out")
+
+	flags := validFlags()
+	flags.Projects, flags.OutDir = filepath.Join(base, "projects"), out
+	if err := runClean(&flags, []string{"tests"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"out/p1", "out/p2/t2/t2.out"} {
+		if _, err := os.Stat(filepath.Join(base, path)); !os.IsNotExist(err) {
+			t.Errorf("%s is still there", path)
+		}
+	}
+	// files grunner didn't generate are left alone, and so are the folders holding them
+	for _, path := range []string{"out/p2/t2/notes.txt", "projects/p1/tests/t1.ok", "projects/p2/tests/t2.ok"} {
+		if _, err := os.Stat(filepath.Join(base, path)); err != nil {
+			t.Errorf("%s was removed", path)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// subdirectories of dir, each an independent project to grade
+func listProjects(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading projects directory %s: %v", dir, err)
+	}
+
+	var projects []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			projects = append(projects, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(projects) == 0 {
+		return nil, fmt.Errorf("no projects found in %s", dir)
+	}
+	return projects, nil
+}
+
+// resolves the test arguments inside a project
+func projectArgs(project string, args []string) []string {
+	resolved := make([]string, 0, len(args))
+	for _, arg := range args {
+		if filepath.IsAbs(arg) {
+			resolved = append(resolved, arg)
+		} else {
+			resolved = append(resolved, filepath.Join(project, arg))
+		}
+	}
+	return resolved
+}
+
+// unique Makefile directories of the tests, in order
+func (m model) makefileDirs() []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, testCase := range m.testCases {
+		if !seen[testCase.makefileDir] {
+			seen[testCase.makefileDir] = true
+			dirs = append(dirs, testCase.makefileDir)
+		}
+	}
+	return dirs
+}
+
+// short name for a Makefile directory, shown before its tests when several projects run together
+func groupLabel(makefileDir string) string {
+	if rel, err := filepath.Rel(".", makefileDir); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	if abs, err := filepath.Abs(makefileDir); err == nil {
+		return filepath.Base(abs)
+	}
+	return makefileDir
+}
+
+// names of the projects in batch mode, in order
+func (m model) projectNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, testCase := range m.testCases {
+		if !seen[testCase.project] {
+			seen[testCase.project] = true
+			names = append(names, testCase.project)
+		}
+	}
+	return names
+}
+
+// one row per project with its aggregate pass count, used instead of per-test rows in batch mode
+func (m model) projectRows() []string {
+	var rows []string
+	for _, project := range m.projectNames() {
+		var total, passed, running, resolved int
+		var failed []string
+		for _, testCase := range m.testCases {
+			if testCase.project != project {
+				continue
+			}
+			total++
+			if testCase.resolved {
+				resolved++
+			} else if testCase.running {
+				running++
+			}
+			if testCase.state == TestStateSuccess {
+				passed++
+			} else if testCase.resolved {
+				failed = append(failed, testCase.name)
+			}
+		}
+
+		var icon string
+		switch {
+		case resolved < total:
+			icon = m.spinner.View()
+		case passed == total:
+			icon = lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("✔")
+		default:
+			icon = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render("✘")
+		}
+
+		row := fmt.Sprintf("%s %s %d/%d passed", icon, testStyle.Render(project), passed, total)
+		if running > 0 {
+			row += darkGrayStyle.Render(fmt.Sprintf(" (%d running)", running))
+		}
+		if m.verbose && len(failed) > 0 {
+			row += " " + errorStyle.Render(strings.Join(failed, ", "))
+		}
+		rows = append(rows, row+"\n")
+	}
+	return rows
+}
+
+// writes the project × test results as CSV or, for a .json path, as {project: {test: state}}
+func writeMatrix(path string, m model) error {
+	results := make(map[string]map[string]string)
+	testNames := make(map[string]bool)
+	for _, testCase := range m.testCases {
+		if results[testCase.project] == nil {
+			results[testCase.project] = make(map[string]string)
+		}
+		results[testCase.project][testCase.name] = stateLabel(testCase)
+		testNames[testCase.name] = true
+	}
+
+	if filepath.Ext(path) == ".json" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, append(data, '\n'), 0644)
+	}
+
+	var tests []string
+	for name := range testNames {
+		tests = append(tests, name)
+	}
+	sort.Strings(tests)
+
+	var str strings.Builder
+	w := csv.NewWriter(&str)
+	_ = w.Write(append([]string{"project"}, tests...))
+	for _, project := range m.projectNames() {
+		row := []string{project}
+		for _, test := range tests {
+			state, ok := results[project][test]
+			if !ok {
+				state = "missing"
+			}
+			row = append(row, state)
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(str.String()), 0644)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// how qemu boots a test: from a disk image, or straight from the kernel ELF for projects without images
+type bootMethod struct {
+	args []string
+	// what was booted, relative to the Makefile directory
+	desc string
+	// the usual per-test image, not worth mentioning
+	usual bool
+}
+
+// escapes a path for use inside a qemu option list, where a comma would start the next option
+func qemuOptionValue(value string) string {
+	return strings.ReplaceAll(value, ",", ",,")
+}
+
+func lockingOption() string {
+	if qemuVersion.driveLocking() {
+		return ",file.locking=off"
+	}
+	return ""
+}
+
+// the -drive value attaching a raw disk image, the boot image at index 0 and the .data disk at 1
+func driveArg(path string, index int) string {
+	return fmt.Sprintf("file=%s,index=%d,media=disk,format=raw%s%s", qemuOptionValue(path), index, lockingOption(), qemuArch.driveOptions)
+}
+
+/*
+ * Starts qemu paused with an empty .data disk attached as the tests would and quits it over the
+ * monitor, so drive arguments the installed qemu rejects show up once at startup rather than as every
+ * .data test failing. Returns qemu's complaint; a qemu that can't be started at all is left to the run.
+ */
+func probeDataDrive(tmpDir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	disk, err := os.CreateTemp(tmpDir, "probe-*.data")
+	if err != nil {
+		return nil
+	}
+	disk.Close()
+	defer os.Remove(disk.Name())
+
+	args := slices.Concat(qemuArch.args, []string{"-S", "-display", "none", "-serial", "none", "-monitor", "stdio", "-drive", driveArg(disk.Name(), 1)})
+	cmd := exec.CommandContext(ctx, QemuPath, args...)
+	cmd.Stdin = strings.NewReader("quit\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || ctx.Err() != nil {
+		return nil
+	}
+	message := strings.TrimSpace(stderr.String())
+	if message == "" {
+		message = exitErr.Error()
+	}
+	return fmt.Errorf("qemu rejects the .data drive arguments, .data tests will fail: %s", strings.SplitN(message, "\n", 2)[0])
+}
+
+/*
+ * Picks what to boot once a test is built: its own image, the shared kernel.img, or the kernel ELF with
+ * -kernel (and kernel_append as its command line). Fails when the build produced none of them.
+ */
+func resolveBoot(dir string, testName string, config projectConfig) (bootMethod, error) {
+	images := []string{filepath.Join("kernel/build", testName+".img"), "kernel/build/kernel.img"}
+	for i, image := range images {
+		path := filepath.Join(dir, image)
+		if _, err := os.Stat(path); err == nil {
+			return bootMethod{
+				args:  []string{"-drive", driveArg(path, 0)},
+				desc:  image,
+				usual: i == 0,
+			}, nil
+		}
+	}
+
+	if elf, err := findKernelElf(dir, config); err == nil {
+		boot := bootMethod{args: []string{"-kernel", elf}}
+		if config.KernelAppend != "" {
+			boot.args = append(boot.args, "-append", config.KernelAppend)
+		}
+		boot.desc = "-kernel " + relativeToMakefile(dir, elf)
+		return boot, nil
+	}
+
+	return bootMethod{}, fmt.Errorf("no kernel image found: make succeeded but didn't produce %s, %s or a kernel ELF", images[0], images[1])
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDriveArg(t *testing.T) {
+	known := func(major int, minor int) qemuVersionInfo {
+		return qemuVersionInfo{major: major, minor: minor, raw: "QEMU emulator version"}
+	}
+	tests := []struct {
+		name    string
+		version qemuVersionInfo
+		arch    string
+		path    string
+		index   int
+		want    string
+	}{
+		{"unknown version", qemuVersionInfo{}, "x86_64", "/p/t1.img", 0, "file=/p/t1.img,index=0,media=disk,format=raw,file.locking=off"},
+		{"before 7.0", known(6, 2), "x86_64", "/p/t1.img", 0, "file=/p/t1.img,index=0,media=disk,format=raw"},
+		{"at 7.0", known(7, 0), "x86_64", "/p/t1.img", 0, "file=/p/t1.img,index=0,media=disk,format=raw,file.locking=off"},
+		{"after 7.0", known(9, 1), "x86_64", "/p/t1.data", 1, "file=/p/t1.data,index=1,media=disk,format=raw,file.locking=off"},
+		{"riscv64 virtio", known(8, 2), "riscv64", "/p/t1.data", 1, "file=/p/t1.data,index=1,media=disk,format=raw,file.locking=off,if=virtio"},
+		{"aarch64 before 7.0", known(6, 0), "aarch64", "/p/t1.img", 0, "file=/p/t1.img,index=0,media=disk,format=raw,if=virtio"},
+		{"comma in path", known(8, 0), "x86_64", "/p/a,b/t1.data", 1, "file=/p/a,,b/t1.data,index=1,media=disk,format=raw,file.locking=off"},
+		{"trailing comma", known(6, 0), "riscv64", "/p/t1,", 0, "file=/p/t1,,,index=0,media=disk,format=raw,if=virtio"},
+	}
+	savedVersion, savedArch := qemuVersion, qemuArch
+	t.Cleanup(func() { qemuVersion, qemuArch = savedVersion, savedArch })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qemuVersion, qemuArch = tt.version, archProfiles[tt.arch]
+			if got := driveArg(tt.path, tt.index); got != tt.want {
+				t.Errorf("driveArg(%q, %d) = %q, want %q", tt.path, tt.index, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQemuArgsPaths(t *testing.T) {
+	savedVersion, savedArch := qemuVersion, qemuArch
+	t.Cleanup(func() { qemuVersion, qemuArch = savedVersion, savedArch })
+	qemuVersion = qemuVersionInfo{major: 8, minor: 2, raw: "QEMU emulator version 8.2.0"}
+	qemuArch = archProfiles["x86_64"]
+
+	tests := []struct {
+		name string
+		// directory of the Makefile under the test's temporary directory
+		dir string
+	}{
+		{"plain", "p"},
+		{"space", "a b"},
+		{"non-ASCII", "tést"},
+		{"space and non-ASCII", "a b/tést"},
+		{"comma", "a,b"},
+		{"quotes", `it's "quoted"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), filepath.FromSlash(tt.dir))
+			writeTree(t, dir, map[string]string{"t1.data": ""})
+			dataFile := filepath.Join(dir, "t1.data")
+			qmpSocket := filepath.Join(dir, "t1.qmp")
+			debugLog := filepath.Join(dir, "t1.debug.log")
+			escape := func(path string) string { return strings.ReplaceAll(path, ",", ",,") }
+
+			args := qemuArgs(dir, "t1", bootMethod{}, accelTCG, 0, false, nil, qmpSocket, debugLog)
+			want := map[string]string{
+				"-drive": "file=" + escape(dataFile) + ",index=1,media=disk,format=raw,file.locking=off",
+				"-qmp":   "unix:" + escape(qmpSocket) + ",server=on,wait=off",
+				// qemu takes the rest of a file: chardev as the path, commas included
+				"-serial": "file:" + debugLog,
+			}
+			for flag, value := range want {
+				if !slices.ContainsFunc(argPairs(args, flag), func(got string) bool { return got == value }) {
+					t.Errorf("qemuArgs() %s = %q, want the element %q", flag, argPairs(args, flag), value)
+				}
+			}
+		})
+	}
+}
+
+// the values following each occurrence of flag in args
+func argPairs(args []string, flag string) []string {
+	var values []string
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// exit code when the --max-duration budget ran out, distinct from errors
+const exitBudgetExceeded = 3
+
+type budgetExceededMsg struct{}
+
+/*
+ * Stops the run: in-flight builds and qemu processes are killed and unfinished tests marked as not run.
+ * Like a signal, the executors are then waited on so their artifacts are whole before the final render.
+ */
+func (m *model) exceedBudget() tea.Cmd {
+	m.overBudget = true
+	m.cancelCtx()
+	if m.runEnd.IsZero() {
+		m.runEnd = time.Now()
+	}
+	for i := range m.testCases {
+		if test := &m.testCases[i]; !test.resolved {
+			test.stopwatch = test.stopwatch.StopNow()
+			test.state = TestStateOverBudget
+			test.resolved = true
+			test.running = false
+			test.err = nil
+		}
+	}
+	m.cleaningUp = true
+	return waitForExecutors(m.executors)
+}
+
+// whether there's enough budget left to start another test, one iteration timeout before the deadline
+func (m model) canDispatch() bool {
+	return m.deadline.IsZero() || time.Until(m.deadline) > m.iterationTimeout
+}
+
+// whether --timecap ends the test, recording how many iterations it was meant to run when that cuts it short
+func (m model) timeCapReached(test *testInfo) bool {
+	if m.timeCap <= 0 || test.TimeElapsed() <= m.timeCap {
+		return false
+	}
+	if test.currIter < len(test.iterations)-1 {
+		test.cappedFrom = len(test.iterations)
+	}
+	return true
+}
+
+/*
+ * Warns when the times of the last run say --timecap will stop tests well short of their iterations,
+ * with roughly how many fit: a test keeps going until its iterations add up to more than the cap.
+ */
+func (m model) timeCapWarning() string {
+	if m.timeCap <= 0 || m.err != nil {
+		return ""
+	}
+	previous, ok := m.previousRun()
+	if !ok {
+		return ""
+	}
+	averages := make(map[string]time.Duration)
+	for _, test := range previous.Tests {
+		averages[test.Name] = time.Duration(test.AverageMs) * time.Millisecond
+	}
+
+	var capped []string
+	for _, test := range m.testCases {
+		average := averages[test.name]
+		if average <= 0 || len(test.iterations) <= 1 {
+			continue
+		}
+		if fit := int(m.timeCap/average) + 1; fit < len(test.iterations) {
+			capped = append(capped, fmt.Sprintf("%s ~%d/%d", test.name, fit, len(test.iterations)))
+		}
+	}
+	if len(capped) == 0 {
+		return ""
+	}
+	if len(capped) > 5 {
+		capped = append(capped[:5], fmt.Sprintf("%d more", len(capped)-5))
+	}
+	return fmt.Sprintf("WARNING: --timecap %s will likely cut tests short of their iterations, going by the last run: %s.", m.timeCap, strings.Join(capped, ", "))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * Pulls out --changed and its optional window, which the parser can't do as the value is optional:
+ * the next argument is the window only if it is a duration, so `--changed tests/` still runs tests/.
+ */
+func extractChangedFlag(args []string) (rest []string, window string, given bool) {
+	for i := 0; i < len(args); i++ {
+		if args[i] != "--changed" {
+			rest = append(rest, args[i])
+			continue
+		}
+		given = true
+		if i+1 < len(args) {
+			if _, err := parseSeconds(args[i+1]); err == nil {
+				window = args[i+1]
+				i++
+			}
+		}
+	}
+	return rest, window, given
+}
+
+// the time --changed selects changes since: now minus the window, or the last run recorded for the tests
+func changedSince(testFiles []testFile, window string) (time.Time, string, error) {
+	if window != "" {
+		d, err := parseSeconds(window)
+		if err != nil || d <= 0 {
+			return time.Time{}, "", fmt.Errorf("invalid --changed %q, expected a duration such as 2h", window)
+		}
+		return time.Now().Add(-d), "in the last " + d.String(), nil
+	}
+
+	makefile, err := findMakefile(filepath.Dir(testFiles[0].filePath))
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	dir := filepath.Dir(makefile)
+	config, _ := loadProjectConfig(dir)
+	path, err := historyPath(dir, config)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	records, err := readHistory(path, absDir)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if len(records) == 0 {
+		return time.Time{}, "", errors.New("--changed found no recorded run to compare with, pass a window such as --changed 2h")
+	}
+	last := records[len(records)-1].Time
+	return last, fmt.Sprintf("since the last run (%s ago)", time.Since(last).Round(time.Second)), nil
+}
+
+// the files a test is made of: its source and .ok file, or every file of a .dir test
+func testSources(testFile testFile) []string {
+	if !testFile.isDir {
+		return []string{testFile.filePath, testExtRe.ReplaceAllString(testFile.filePath, ".ok")}
+	}
+	var files []string
+	_ = filepath.WalkDir(testFile.filePath, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+// absolute paths git reports as modified or untracked in the repository containing dir, nil outside one
+func gitModified(dir string) map[string]bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	root, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil
+	}
+	status, err := exec.CommandContext(ctx, "git", "-C", dir, "status", "--porcelain", "-z", "--untracked-files=all").Output()
+	if err != nil {
+		return nil
+	}
+
+	modified := make(map[string]bool)
+	entries := strings.Split(string(status), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		modified[resolvedPath(filepath.Join(strings.TrimSpace(string(root)), entry[3:]))] = true
+		// a rename is followed by its source
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return modified
+}
+
+// an absolute path with symlinks resolved, as git reports them
+func resolvedPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+/*
+ * Keeps the tests changed within the window, or since the last recorded run without one: a test
+ * changed when any of its files was modified since then and, in a git repository, shows as modified
+ * in `git status`, so a checkout touching every file doesn't select them all. The returned note says
+ * what was selected.
+ */
+func filterChanged(testFiles []testFile, window string) ([]testFile, string, error) {
+	if len(testFiles) == 0 {
+		return testFiles, "", nil
+	}
+	since, desc, err := changedSince(testFiles, window)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// by directory, as tests can be in several repositories
+	repos := make(map[string]map[string]bool)
+	var filtered []testFile
+	var names []string
+	for _, testFile := range testFiles {
+		dir := filepath.Dir(testFile.filePath)
+		if _, ok := repos[dir]; !ok {
+			repos[dir] = gitModified(dir)
+		}
+		modified := repos[dir]
+
+		for _, source := range testSources(testFile) {
+			info, err := os.Stat(source)
+			if err != nil || info.ModTime().Before(since) {
+				continue
+			}
+			if modified != nil && !modified[resolvedPath(source)] {
+				continue
+			}
+			filtered = append(filtered, testFile)
+			names = append(names, testFile.testName)
+			break
+		}
+	}
+
+	if len(filtered) == 0 {
+		return nil, fmt.Sprintf("--changed: none of the %d tests changed %s", len(testFiles), desc), nil
+	}
+	return filtered, fmt.Sprintf("--changed: %d of %d tests changed %s: %s", len(filtered), len(testFiles), desc, strings.Join(names, ", ")), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// timing changes smaller than this fraction of the previous average are noise
+const timingChangeThreshold = 0.2
+
+var passStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+
+// the run to compare against: the last record of the given file, which can be a history file or a single record
+func loadBaseline(path string) (historyRecord, error) {
+	var record historyRecord
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return record, fmt.Errorf("error reading baseline: %w", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if err := json.Unmarshal(lines[len(lines)-1], &record); err != nil {
+		return record, fmt.Errorf("error parsing baseline %s: %w", path, err)
+	}
+	return record, nil
+}
+
+// the most recent recorded run of this project, if any
+func (m model) previousRun() (historyRecord, bool) {
+	path, err := historyPath(m.makefileDir, m.config)
+	if err != nil {
+		return historyRecord{}, false
+	}
+	current := m.historyRecord(nil)
+	records, err := readHistory(path, current.Dir)
+	if err != nil || len(records) == 0 {
+		return historyRecord{}, false
+	}
+	return records[len(records)-1], true
+}
+
+func isPassLabel(label string) bool {
+	return label == "passed" || label == "unexpectedly passed"
+}
+
+/*
+ * Lists the tests whose result flipped between the two runs, newly failing in red and newly passing
+ * in green, and annotates average times that changed by more than 20%.
+ */
+func runChanges(previous historyRecord, current historyRecord) []string {
+	before := make(map[string]historyTest)
+	for _, test := range previous.Tests {
+		before[test.Name] = test
+	}
+
+	var changes []string
+	for _, test := range current.Tests {
+		prev, ok := before[test.Name]
+		if !ok || test.Result == "not run" || prev.Result == "not run" {
+			continue
+		}
+
+		if isPassLabel(prev.Result) && !isPassLabel(test.Result) {
+			changes = append(changes, errorStyle.Render(fmt.Sprintf("%s went from pass to %s", test.Name, test.Result)))
+		} else if !isPassLabel(prev.Result) && isPassLabel(test.Result) {
+			changes = append(changes, passStyle.Render(fmt.Sprintf("%s went from %s to pass", test.Name, prev.Result)))
+		}
+
+		if prev.AverageMs > 0 && test.AverageMs > 0 {
+			ratio := float64(test.AverageMs) / float64(prev.AverageMs)
+			prevTime := time.Duration(prev.AverageMs) * time.Millisecond
+			currTime := time.Duration(test.AverageMs) * time.Millisecond
+			if ratio > 1+timingChangeThreshold {
+				changes = append(changes, grayStyle.Render(fmt.Sprintf("%s got %.1f× slower (%s → %s)", test.Name, ratio, prevTime, currTime)))
+			} else if ratio < 1-timingChangeThreshold {
+				changes = append(changes, grayStyle.Render(fmt.Sprintf("%s got %.1f× faster (%s → %s)", test.Name, 1/ratio, prevTime, currTime)))
+			}
+		}
+	}
+	return changes
+}
+
+func changesView(previous historyRecord, current historyRecord, label string) string {
+	changes := runChanges(previous, current)
+	when := previous.Time.Local().Format(time.DateTime)
+	if previous.Git != nil {
+		when += ", " + previous.Git.String()
+	}
+	title := fmt.Sprintf("Changes since %s (%s):", label, when)
+	if len(changes) == 0 {
+		return grayStyle.Render(title+" none") + "\n"
+	}
+
+	var str strings.Builder
+	str.WriteString(title + "\n")
+	for _, change := range changes {
+		str.WriteString("  " + change + "\n")
+	}
+	return str.String()
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var errOutputMismatch = errors.New("output mismatch")
+
+type comparison struct {
+	// .ok files the output was compared against
+	candidates []string
+	// the candidate that matched, or the closest one on a mismatch
+	matched string
+	diff    []byte
+}
+
+// a failed comparison, carrying the diff so it can be previewed inline
+type diffError struct {
+	msg      string
+	diff     []byte
+	diffPath string
+	// fraction of the expected lines found in order in the output, only computed with --partial
+	score float64
+}
+
+func (e diffError) Error() string { return e.msg }
+
+func (c comparison) describeCandidates() string {
+	var names []string
+	for _, candidate := range c.candidates {
+		names = append(names, filepath.Base(candidate))
+	}
+	return strings.Join(names, ", ")
+}
+
+/*
+ * Returns the expected output files for a test: the .ok file plus any alternatives
+ * sharing its name as a prefix (t9.ok.alt), sorted. Editor backups are skipped.
+ */
+func okCandidates(dir string, okPath string) []string {
+	if !filepath.IsAbs(okPath) {
+		okPath = filepath.Join(dir, okPath)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(okPath))
+	if err != nil {
+		return []string{okPath}
+	}
+
+	var candidates []string
+	base := filepath.Base(okPath)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) || strings.HasSuffix(name, "~") || strings.HasSuffix(name, ".swp") {
+			continue
+		}
+		candidates = append(candidates, filepath.Join(filepath.Dir(okPath), name))
+	}
+
+	if len(candidates) == 0 {
+		return []string{okPath}
+	}
+	return candidates
+}
+
+// the test has no .ok file to compare against, which isn't the same as a mismatch
+type missingOkError struct{ okPath string }
+
+func (e missingOkError) Error() string {
+	return fmt.Sprintf("no expected output (%s is missing), run with --update-ok to create it", filepath.Base(e.okPath))
+}
+
+// marker the kernel prints when it reaches code the student hasn't written yet
+const missingCodeMarker = "*** Missing code at"
+
+// the test reached a stub, which says more about progress than about the code being wrong
+type missingCodeError struct{ location string }
+
+func (e missingCodeError) Error() string {
+	if e.location == "" {
+		return "unimplemented: missing code"
+	}
+	return "unimplemented: missing code at " + e.location
+}
+
+// the first missing code marker in the output, with the location it names
+func findMissingCode(output string) (missingCodeError, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(ansiRe.ReplaceAllString(line, ""), " \t\r")
+		if rest, ok := strings.CutPrefix(line, missingCodeMarker); ok {
+			return missingCodeError{strings.TrimSpace(rest)}, true
+		}
+	}
+	return missingCodeError{}, false
+}
+
+// the .ok path of a test, relative paths resolved against its Makefile directory
+func okPath(t testInfo) string {
+	return okCandidates(t.makefileDir, t.okFile)[0]
+}
+
+// whether any expected output file exists for the test
+func hasExpectedOutput(t testInfo) bool {
+	for _, candidate := range okCandidates(t.makefileDir, t.okFile) {
+		if _, err := os.Stat(candidate); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// a block of expected output in a test source starts with this and ends at the next */
+const expectBlockStart = "/* grunner-expect"
+
+// or each line of it is a comment starting with this
+const expectLinePrefix = "//!"
+
+// The expected output embedded in a test source, for tests too small to be worth an .ok file: the
+// lines between `/* grunner-expect` and `*/`, and the lines starting with //!, in order. It is only
+// used when the test has no .ok file, see embedExpectedOutput.
+func embeddedExpected(filePath string) (string, bool) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", false
+	}
+
+	var lines []string
+	var found, inBlock bool
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if inBlock {
+			if before, ok := strings.CutSuffix(trimmed, "*/"); ok {
+				if before = strings.TrimSpace(before); before != "" {
+					lines = append(lines, before)
+				}
+				inBlock = false
+				continue
+			}
+			lines = append(lines, line)
+		} else if rest, ok := strings.CutPrefix(trimmed, expectBlockStart); ok {
+			found = true
+			// `/* grunner-expect *** done */` on a single line
+			before, closed := strings.CutSuffix(rest, "*/")
+			if before = strings.TrimSpace(before); closed && before != "" {
+				lines = append(lines, before)
+			}
+			inBlock = !closed
+		} else if rest, ok := strings.CutPrefix(trimmed, expectLinePrefix); ok {
+			found = true
+			lines = append(lines, strings.TrimPrefix(rest, " "))
+		}
+	}
+	if !found {
+		return "", false
+	}
+	if len(lines) == 0 {
+		return "", true
+	}
+	return strings.Join(lines, "\n") + "\n", true
+}
+
+/*
+ * Gives a test without an .ok file the expected output embedded in its source, written to
+ * <test>.expected among its generated files so it is compared like any .ok file. An .ok file always
+ * wins, and a test with neither is left without expected output.
+ */
+func embedExpectedOutput(test *testInfo) error {
+	if test.isDir || hasExpectedOutput(*test) {
+		return nil
+	}
+	expected, ok := embeddedExpected(test.filePath)
+	if !ok {
+		return nil
+	}
+	path := absPath(artifactPath(*test, ".expected"))
+	if err := writeFileAtomic(path, []byte(expected), 0644); err != nil {
+		return fmt.Errorf("failed to write the expected output embedded in %s: %w", test.filePath, err)
+	}
+	test.okFile = path
+	return nil
+}
+
+// number of differing lines in a diff, in either diff or --ok-wildcards format
+func countDiffLines(diff []byte) int {
+	var count int
+	for _, line := range strings.Split(ansiRe.ReplaceAllString(string(diff), ""), "\n") {
+		if strings.HasPrefix(line, "<") || strings.HasPrefix(line, ">") {
+			count++
+		}
+	}
+	return count
+}
+
+// compares the output against every .ok candidate, passing if any of them match
+func compareOutput(ctx context.Context, m *model, testCase testInfo, output string) (comparison, error) {
+	result := comparison{candidates: okCandidates(testCase.makefileDir, testCase.okFile)}
+	project := m.settings(testCase.makefileDir)
+
+	var closestErr error
+	closest := -1
+	for _, candidate := range result.candidates {
+		var diffOut bytes.Buffer
+		var err error
+		if project.compareCmd != "" {
+			err = runCompareCmd(ctx, testCase.makefileDir, project.compareCmd, candidate, outPath(testCase), &diffOut)
+			var cmdErr compareCmdError
+			if errors.As(err, &cmdErr) {
+				// the other candidates would fail the same way
+				return result, err
+			}
+		} else if project.okWildcards {
+			err = compareWildcards(testCase.makefileDir, candidate, output, &diffOut)
+		} else {
+			err = runDiff(ctx, testCase.makefileDir, m.tmpDir, outPath(testCase), candidate, output, &diffOut)
+		}
+
+		if err == nil {
+			result.matched = candidate
+			result.diff = diffOut.Bytes()
+			return result, nil
+		}
+
+		if lines := countDiffLines(diffOut.Bytes()); closest < 0 || lines < closest {
+			closest = lines
+			closestErr = err
+			result.matched = candidate
+			result.diff = diffOut.Bytes()
+		}
+	}
+
+	return result, closestErr
+}
+
+/*
+ * The fraction of the expected file's lines found in the output in order, i.e. the longest common
+ * subsequence of their lines over the number of expected lines, compared the way diff -wBb does.
+ */
+func partialScore(okPath string, output string) float64 {
+	data, err := os.ReadFile(okPath)
+	if err != nil {
+		return 0
+	}
+	expected, actual := comparableLines(string(data)), comparableLines(output)
+	if len(expected) == 0 {
+		return 0
+	}
+
+	// a single row of the LCS table, previous holding the diagonal
+	row := make([]int, len(actual)+1)
+	for _, want := range expected {
+		previous := 0
+		for j, got := range actual {
+			current := row[j+1]
+			if want == got {
+				row[j+1] = previous + 1
+			} else {
+				row[j+1] = max(row[j+1], row[j])
+			}
+			previous = current
+		}
+	}
+	return float64(row[len(actual)]) / float64(len(expected))
+}
+
+// strips a UTF-8 BOM, carriage returns and trailing whitespace so editor quirks don't show up as diffs
+func normalizeOutput(text string) string {
+	text = strings.TrimPrefix(text, "\uFEFF")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+/*
+ * Returns a path to diff against holding the normalized contents of the .ok file.
+ * If the .ok file is already normalized (or can't be read) its own path is returned, otherwise a
+ * normalized temporary copy is made, which the returned cleanup function removes.
+ */
+func normalizedOkFile(dir string, tmpDir string, okPath string) (string, func(), error) {
+	noop := func() {}
+	if !filepath.IsAbs(okPath) {
+		okPath = filepath.Join(dir, okPath)
+	}
+
+	data, err := os.ReadFile(okPath)
+	if err != nil {
+		return okPath, noop, nil
+	}
+	normalized := normalizeOutput(string(data))
+	if normalized == string(data) {
+		return okPath, noop, nil
+	}
+
+	tmp, err := os.CreateTemp(tmpDir, "*-"+filepath.Base(okPath))
+	if err != nil {
+		return okPath, noop, err
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(normalized); err != nil {
+		_ = os.Remove(tmp.Name())
+		return okPath, noop, err
+	}
+
+	return tmp.Name(), func() { _ = os.Remove(tmp.Name()) }, nil
+}
+
+// diffs the output against the .ok file with the external diff, writing the colored diff to diffOut;
+// both sides are normalized the same way, so a BOM or CRLFs from the guest don't count as a mismatch
+func runDiff(ctx context.Context, dir string, tmpDir string, outLabel string, okPath string, output string, diffOut io.Writer) error {
+	okFile, cleanupOk, err := normalizedOkFile(dir, tmpDir, okPath)
+	if err != nil {
+		return fmt.Errorf("failed to normalize .ok: %w", err)
+	}
+	defer cleanupOk()
+
+	d := exec.CommandContext(ctx, "diff", "-wBb", "--color=always", "--label", relativeToMakefile(dir, outLabel), "--label", relativeToMakefile(dir, okPath), "-", okFile)
+	d.Dir = dir
+	d.Stdin = strings.NewReader(normalizeOutput(output))
+	d.Stdout = diffOut
+	return d.Run()
+}
+
+// the compare command couldn't run at all, as opposed to reporting a mismatch
+type compareCmdError struct {
+	command string
+	err     error
+	stderr  string
+}
+
+func (e compareCmdError) Error() string {
+	msg := fmt.Sprintf("compare command %q failed to run: %s", e.command, e.err)
+	if e.stderr != "" {
+		msg += "\n" + e.stderr
+	}
+	return msg
+}
+func (e compareCmdError) Unwrap() error { return e.err }
+
+// the environment variables holding the paths --compare-cmd compares
+const (
+	compareExpectedEnv = "GRUNNER_EXPECTED"
+	compareActualEnv   = "GRUNNER_ACTUAL"
+)
+
+/*
+ * Runs the --compare-cmd template through sh in the Makefile directory, with the paths in $GRUNNER_EXPECTED
+ * and $GRUNNER_ACTUAL so no path ever becomes shell code. The {expected} and {actual} shorthands expand to
+ * the quoted variables and must be left unquoted; templates quoting them themselves, such as inside a
+ * single-quoted script, use the variables instead. Exit code 0 passes and anything else is a mismatch with
+ * the command's stdout as the diff, except for sh's 126 and 127 (not executable, not found), which mean
+ * the comparator never ran.
+ */
+func runCompareCmd(ctx context.Context, dir string, template string, expected string, actual string, diffOut io.Writer) error {
+	command := strings.NewReplacer("{expected}", `"$`+compareExpectedEnv+`"`, "{actual}", `"$`+compareActualEnv+`"`).Replace(template)
+	var stderr bytes.Buffer
+	e := exec.CommandContext(ctx, "sh", "-c", command)
+	e.Env = append(os.Environ(), compareExpectedEnv+"="+expected, compareActualEnv+"="+actual)
+	e.Dir = dir
+	e.Stdout = diffOut
+	e.Stderr = &stderr
+
+	err := e.Run()
+	var exitErr *exec.ExitError
+	if err == nil || (errors.As(err, &exitErr) && exitErr.ExitCode() != 126 && exitErr.ExitCode() != 127) {
+		return err
+	}
+	return compareCmdError{command: template, err: err, stderr: strings.TrimSpace(stderr.String())}
+}
+
+var (
+	wildcardRe    = regexp.MustCompile(`\{\{(.*?)\}\}|\?`)
+	diffDelStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	diffAddStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	diffInfoStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+)
+
+/*
+ * Compiles an expected line with placeholders into a regex matching the whole line.
+ * `?` matches any single character and `{{re}}` embeds a regular expression; everything else,
+ * including the `***` prefix, is literal.
+ */
+func wildcardLineRegexp(line string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range wildcardRe.FindAllStringSubmatchIndex(line, -1) {
+		pattern.WriteString(regexp.QuoteMeta(line[last:loc[0]]))
+		if loc[2] >= 0 {
+			pattern.WriteString("(?:" + line[loc[2]:loc[3]] + ")")
+		} else {
+			pattern.WriteString(".")
+		}
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(line[last:]))
+	pattern.WriteString("$")
+	return regexp.Compile(pattern.String())
+}
+
+// splits output into lines the way diff -wBb sees them: blank lines dropped and whitespace collapsed
+func comparableLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(normalizeOutput(text), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return lines
+}
+
+// compares the output line-by-line against an .ok file containing placeholders, writing a diff-like report of mismatches
+func compareWildcards(dir string, okPath string, output string, diffOut io.Writer) error {
+	if !filepath.IsAbs(okPath) {
+		okPath = filepath.Join(dir, okPath)
+	}
+	data, err := os.ReadFile(okPath)
+	if err != nil {
+		return fmt.Errorf("failed to read .ok: %w", err)
+	}
+
+	actual := comparableLines(output)
+	expected := comparableLines(string(data))
+
+	mismatched := false
+	for i := 0; i < max(len(actual), len(expected)); i++ {
+		switch {
+		case i >= len(expected):
+			fmt.Fprintln(diffOut, diffInfoStyle.Render(fmt.Sprintf("%dd%d", i+1, len(expected))))
+			fmt.Fprintln(diffOut, diffDelStyle.Render("< "+actual[i]))
+		case i >= len(actual):
+			fmt.Fprintln(diffOut, diffInfoStyle.Render(fmt.Sprintf("%da%d", len(actual), i+1)))
+			fmt.Fprintln(diffOut, diffAddStyle.Render("> "+expected[i]))
+		default:
+			re, err := wildcardLineRegexp(expected[i])
+			if err != nil {
+				return fmt.Errorf("invalid placeholder on line %d of %s: %w", i+1, filepath.Base(okPath), err)
+			}
+			if re.MatchString(actual[i]) {
+				continue
+			}
+			fmt.Fprintln(diffOut, diffInfoStyle.Render(fmt.Sprintf("%dc%d", i+1, i+1)))
+			fmt.Fprintln(diffOut, diffDelStyle.Render("< "+actual[i]))
+			fmt.Fprintln(diffOut, "---")
+			fmt.Fprintln(diffOut, diffAddStyle.Render("> "+expected[i]))
+		}
+		mismatched = true
+	}
+
+	if mismatched {
+		return errOutputMismatch
+	}
+	return nil
+}
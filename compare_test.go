@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddedExpected(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+		found  bool
+	}{
+		{"none", "int main() {}\n// a comment\n", "", false},
+		{"block", "/* grunner-expect\n*** one\n*** two\n*/\nint main() {}\n", "*** one\n*** two\n", true},
+		{"lines", "int main() {}\n//! *** one\n//!*** two\n", "*** one\n*** two\n", true},
+		{"indented lines", "\t//! *** one\n", "*** one\n", true},
+		{"block and lines mixed", "//! *** one\n/* grunner-expect\n*** two\n*/\n//! *** three\n", "*** one\n*** two\n*** three\n", true},
+		{"closing on the last line", "/* grunner-expect\n*** one\n*** two */\n", "*** one\n*** two\n", true},
+		{"single-line block", "/* grunner-expect *** done */\n", "*** done\n", true},
+		{"empty single-line block", "/* grunner-expect */\n", "", true},
+		{"empty block", "/* grunner-expect\n*/\n", "", true},
+		{"crlf", "/* grunner-expect\r\n*** one\r\n*/\r\n//! *** two\r\n", "*** one\n*** two\n", true},
+		{"//! inside the block is output", "/* grunner-expect\n//! *** one\n*/\n", "//! *** one\n", true},
+		{"unterminated block", "/* grunner-expect\n*** one", "*** one\n", true},
+		{"other block comment", "/* not grunner-expect\n*** one\n*/\n", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "t1.cc")
+			if err := os.WriteFile(path, []byte(tt.source), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, found := embeddedExpected(path)
+			if got != tt.want || found != tt.found {
+				t.Errorf("embeddedExpected() = %q, %v, want %q, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestEmbedExpectedOutputPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		ok       bool
+		embedded bool
+		// the expected output compared against, empty for none
+		want string
+	}{
+		{".ok file only", true, false, "*** from ok\n"},
+		{".ok file wins over the embedded block", true, true, "*** from ok\n"},
+		{"embedded block only", false, true, "*** embedded\n"},
+		{"neither", false, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := "int main() {}\n"
+			if tt.embedded {
+				source += "//! *** embedded\n"
+			}
+			files := map[string]string{"t1.cc": source}
+			if tt.ok {
+				files["t1.ok"] = "*** from ok\n"
+			}
+			writeTree(t, dir, files)
+
+			test := testInfo{
+				baseName:    "t1",
+				filePath:    filepath.Join(dir, "t1.cc"),
+				makefileDir: dir,
+				artifactDir: filepath.Join(dir, "out"),
+				okFile:      filepath.Join(dir, "t1.ok"),
+			}
+			if err := os.MkdirAll(test.artifactDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := embedExpectedOutput(&test); err != nil {
+				t.Fatal(err)
+			}
+
+			if !hasExpectedOutput(test) {
+				if tt.want != "" {
+					t.Fatalf("no expected output, want %q", tt.want)
+				}
+				return
+			}
+			data, err := os.ReadFile(okPath(test))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("expected output = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunCompareCmdPaths(t *testing.T) {
+	dir := t.TempDir()
+	expected := filepath.Join(dir, "it's expected $HOME.ok")
+	actual := filepath.Join(dir, "out `put`.out")
+	different := filepath.Join(dir, "different.out")
+	writeTree(t, dir, map[string]string{
+		filepath.Base(expected):  "*** one\n",
+		filepath.Base(actual):    "*** one\n",
+		filepath.Base(different): "*** two\n",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"shorthands", "cmp -s {expected} {actual}"},
+		{"variables", `cmp -s "$GRUNNER_EXPECTED" "$GRUNNER_ACTUAL"`},
+		{"variables in a single-quoted script", `sh -c 'cmp -s "$GRUNNER_EXPECTED" "$GRUNNER_ACTUAL"'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runCompareCmd(context.Background(), dir, tt.template, expected, actual, io.Discard); err != nil {
+				t.Errorf("same output: runCompareCmd(%q) = %v, want a pass", tt.template, err)
+			}
+			err := runCompareCmd(context.Background(), dir, tt.template, expected, different, io.Discard)
+			if err == nil || errors.As(err, new(compareCmdError)) {
+				t.Errorf("different output: runCompareCmd(%q) = %v, want a mismatch", tt.template, err)
+			}
+		})
+	}
+}
+
+func TestRunDiffNormalizesBothSides(t *testing.T) {
+	tests := []struct {
+		name   string
+		ok     string
+		output string
+	}{
+		{"BOM in .ok", "\uFEFF*** hello\n", "*** hello\n"},
+		{"BOM in output", "*** hello\n", "\uFEFF*** hello\n"},
+		{"BOM on both", "\uFEFF*** hello\n", "\uFEFF*** hello\n"},
+		{"CRLF in output", "*** hello\n*** bye\n", "*** hello\r\n*** bye\r\n"},
+		{"CRLF in .ok", "*** hello\r\n*** bye\r\n", "*** hello\n*** bye\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			okPath := filepath.Join(dir, "t0.ok")
+			if err := os.WriteFile(okPath, []byte(tt.ok), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runDiff(context.Background(), dir, t.TempDir(), "t0.out", okPath, tt.output, io.Discard); err != nil {
+				t.Errorf("runDiff(%q, %q) = %v, want a match", tt.ok, tt.output, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grunner/stopwatch"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// a --compare-config configuration: a label for its rows and the make variables its builds get
+type buildConfig struct {
+	label string
+	// e.g. DEBUG=1 OPT=2, possibly empty
+	vars string
+}
+
+// parses the --compare-config values, LABEL:VARS such as "A:DEBUG=1" or "B:"
+func parseBuildConfigs(values []string) ([]buildConfig, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) == 1 {
+		return nil, errors.New("--compare-config needs at least two configurations to compare")
+	}
+	var configs []buildConfig
+	for _, value := range values {
+		label, vars, ok := strings.Cut(value, ":")
+		if !ok || label == "" || strings.ContainsAny(label, " /[]") {
+			return nil, fmt.Errorf("invalid --compare-config %q, expected LABEL:VARS such as A:DEBUG=1", value)
+		}
+		if slices.ContainsFunc(configs, func(c buildConfig) bool { return c.label == label }) {
+			return nil, fmt.Errorf("--compare-config %s is given twice", label)
+		}
+		for _, assignment := range strings.Fields(vars) {
+			if name, _, ok := strings.Cut(assignment, "="); !ok || name == "" {
+				return nil, fmt.Errorf("invalid --compare-config %q, %q isn't a NAME=value assignment", value, assignment)
+			}
+		}
+		configs = append(configs, buildConfig{label: label, vars: strings.TrimSpace(vars)})
+	}
+	return configs, nil
+}
+
+func (c buildConfig) String() string {
+	if c.vars == "" {
+		return c.label + " (no variables)"
+	}
+	return fmt.Sprintf("%s (%s)", c.label, c.vars)
+}
+
+/*
+ * Duplicates the tests once per configuration, labelled like `t4 [A]`. Each copy keeps its generated
+ * files under its configuration, in --out-dir or .grunner-configs next to the Makefile, as the
+ * configurations would otherwise overwrite each other's.
+ */
+func expandConfigs(testCases []testInfo, configs []buildConfig, outDir string) []testInfo {
+	var expanded []testInfo
+	for config, buildConfig := range configs {
+		for _, testCase := range testCases {
+			testCase.id = len(expanded)
+			testCase.config = config
+			testCase.name = fmt.Sprintf("%s [%s]", testCase.name, buildConfig.label)
+			root := outDir
+			if root == "" {
+				root = filepath.Join(testCase.makefileDir, ".grunner-configs")
+			}
+			testCase.artifactDir = artifactDir(root, buildConfig.label, testCase.makefileDir, testCase.baseName)
+			testCase.iterations = slices.Clone(testCase.iterations)
+			testCase.stopwatch = stopwatch.NewWithInterval(time.Millisecond * 31)
+			expanded = append(expanded, testCase)
+		}
+	}
+	return expanded
+}
+
+/*
+ * The environment of make under a configuration. Its variables go in MAKEFLAGS, which make reads as
+ * command-line assignments: they beat plain assignments in the Makefile and reach sub-makes.
+ */
+func (m model) buildEnv(config int) []string {
+	if len(m.configs) == 0 {
+		return m.env
+	}
+	env := m.env
+	if env == nil {
+		env = os.Environ()
+	}
+	makeflags := m.configs[config].vars
+	if existing := envMap(env)["MAKEFLAGS"]; existing != "" {
+		makeflags = strings.TrimSpace(existing + " " + makeflags)
+	}
+	return append(slices.Clone(env), "MAKEFLAGS="+makeflags)
+}
+
+/*
+ * Cleans the project in dir before building its kernel under the current configuration, as make
+ * can't tell files built under another one are stale. A Makefile without a clean rule is warned
+ * about at startup and built as is.
+ */
+func (m model) cleanAndMakeDependencies(dir string) tea.Cmd {
+	ctx, env, timeout := m.context, m.buildEnv(m.phase), m.scaleTimeout(buildTimeout)
+	return func() tea.Msg {
+		cleanCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		clean := exec.CommandContext(cleanCtx, "make", "clean")
+		clean.Dir = dir
+		clean.Env = env
+		output, err := clean.CombinedOutput()
+		if match := noRuleRe.FindSubmatch(output); err != nil && (match == nil || string(match[1]) != "clean") {
+			return dependencyErr{dir, fmt.Errorf("make clean failed: %w\n%s", err, output)}
+		}
+		return makeDependencies(ctx, dir, env, timeout)()
+	}
+}
+
+// warnings for the Makefiles --compare-config can't clean between configurations
+func cleanRuleWarnings(dirs []string) []string {
+	var warnings []string
+	for _, dir := range dirs {
+		if rules := makeRules(dir); rules != nil && !hasMakeRule(rules, "clean") {
+			warnings = append(warnings, fmt.Sprintf("WARNING: the Makefile in %s has no clean rule, --compare-config builds may mix configurations.", dir))
+		}
+	}
+	return warnings
+}
+
+/*
+ * Moves on to the next configuration once every test of the current one is resolved: the projects
+ * are cleaned and rebuilt under it, and the prerequisites built again.
+ */
+func (m *model) nextConfig() []tea.Cmd {
+	if m.phase+1 >= len(m.configs) || m.quitting || m.overBudget || m.err != nil {
+		return nil
+	}
+	for _, testCase := range m.testCases {
+		if testCase.config == m.phase && !testCase.resolved {
+			return nil
+		}
+	}
+
+	m.phase++
+	for i := range m.prereqs {
+		m.prereqs[i].state = TestStateWaiting
+		m.prereqs[i].err = nil
+	}
+	var cmds []tea.Cmd
+	for _, dir := range m.makefileDirs() {
+		cmds = append(cmds, m.cleanAndMakeDependencies(dir))
+	}
+	return cmds
+}
+
+// a test's outcome under each configuration
+type configComparison struct {
+	Test string
+	// in the order of --compare-config
+	States  []string
+	Differs bool
+}
+
+// the outcomes of the tests side by side, in the order they were given
+func (m model) configComparisons() []configComparison {
+	var comparisons []configComparison
+	index := make(map[string]int)
+	for _, testCase := range m.testCases {
+		name := strings.TrimSuffix(testCase.name, " ["+m.configs[testCase.config].label+"]")
+		i, ok := index[name]
+		if !ok {
+			i = len(comparisons)
+			index[name] = i
+			comparisons = append(comparisons, configComparison{Test: name, States: make([]string, len(m.configs))})
+		}
+		comparisons[i].States[testCase.config] = stateLabel(testCase)
+	}
+	for i := range comparisons {
+		states := comparisons[i].States
+		comparisons[i].Differs = slices.ContainsFunc(states, func(state string) bool { return state != states[0] })
+	}
+	return comparisons
+}
+
+// the comparison printed at the end of a --compare-config run, tests whose outcome differs in red
+func (m model) configComparisonView() string {
+	comparisons := m.configComparisons()
+	width := 0
+	for _, comparison := range comparisons {
+		width = max(width, len(comparison.Test))
+	}
+
+	var str strings.Builder
+	var labels []string
+	for _, config := range m.configs {
+		labels = append(labels, config.String())
+	}
+	str.WriteString(fmt.Sprintf("Configurations: %s\n", strings.Join(labels, " · ")))
+	var differing int
+	for _, comparison := range comparisons {
+		var cells []string
+		for config, state := range comparison.States {
+			cells = append(cells, fmt.Sprintf("%s %-14s", m.configs[config].label, state))
+		}
+		line := fmt.Sprintf("  %-*s  %s", width, comparison.Test, strings.Join(cells, "  "))
+		if comparison.Differs {
+			differing++
+			str.WriteString(errorStyle.Render(line) + "\n")
+		} else {
+			str.WriteString(grayStyle.Render(line) + "\n")
+		}
+	}
+	if differing == 0 {
+		str.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("Every test ended the same way under each configuration.") + "\n")
+	} else {
+		str.WriteString(errorStyle.Render(fmt.Sprintf("%d test(s) ended differently between configurations.", differing)) + "\n")
+	}
+	return str.String()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const projectConfigName = ".grunner.json"
+
+// per-project settings, read from .grunner.json next to the Makefile
+type projectConfig struct {
+	// extra regexes of qemu stderr lines that should not fail a test
+	StderrAllow []string `json:"stderr_allow"`
+	// kernel ELF used for debugging and symbolizing, relative to the Makefile directory
+	KernelElf string `json:"kernel_elf"`
+	// regex of output lines whose addresses get symbolized on failure
+	PanicPattern string `json:"panic_pattern"`
+	// addr2line binary, e.g. a cross toolchain's i686-elf-addr2line
+	Addr2line string `json:"addr2line"`
+	// allow ? and {{regex}} placeholders in .ok files, same as --ok-wildcards
+	OkWildcards bool `json:"ok_wildcards"`
+	// comparator used instead of diff, same as --compare-cmd
+	CompareCmd string `json:"compare_cmd"`
+	// project-local run history, relative to the Makefile directory, instead of the one in the user's data dir
+	History string `json:"history"`
+	// names of tests that are expected to fail, same as a `// grunner: xfail` comment
+	Xfail []string `json:"xfail"`
+	// make targets each test needs built first, same as a `// grunner: requires=fs.img` comment
+	Requires map[string][]string `json:"requires"`
+	// make targets built once before any test, same as --fixture
+	Fixtures []string `json:"fixtures"`
+	// shell commands run in the Makefile directory before the tests, after them, and before each test's build
+	PreHook     string `json:"pre_hook"`
+	PostHook    string `json:"post_hook"`
+	PreTestHook string `json:"pre_test_hook"`
+	// make target and expected output file of .dir tests, see dirtests.go
+	DirTarget   string `json:"dir_target"`
+	DirExpected string `json:"dir_expected"`
+	// test file extensions, same as --ext
+	Extensions []string `json:"extensions"`
+	// kernel command line when a project without disk images boots the ELF directly, see boot.go
+	KernelAppend string `json:"kernel_append"`
+	// architecture the kernel targets, same as --arch
+	Arch string `json:"arch"`
+	// always use TCG, same as --no-accel
+	NoAccel bool `json:"no_accel"`
+	// regex of *** output lines that fail a test despite a clean diff, same as --fail-pattern
+	FailPattern string `json:"fail_pattern"`
+	// fail the build of tests with compiler warnings, same as --warnings-as-errors
+	WarningsAsErrors bool `json:"warnings_as_errors"`
+	// where anonymous run metrics are posted and/or appended, same as --metrics-endpoint and --metrics-file
+	MetricsEndpoint string `json:"metrics_endpoint"`
+	MetricsFile     string `json:"metrics_file"`
+	// send test names as is instead of hashed, same as --metrics-plain-names
+	MetricsPlainNames bool `json:"metrics_plain_names"`
+}
+
+func loadProjectConfig(makefileDir string) (projectConfig, error) {
+	var config projectConfig
+
+	path := filepath.Join(makefileDir, projectConfigName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return config, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return config, nil
+}
+
+/*
+ * A project's .grunner.json and the settings resolved from it, kept by Makefile directory so the tests
+ * of every project in a run follow their own config rather than the first project's.
+ */
+type projectSettings struct {
+	config projectConfig
+	// qemu accelerator, TCG when the project sets no_accel
+	accel            string
+	okWildcards      bool
+	warningsAsErrors bool
+	// external comparator replacing diff, see runCompareCmd
+	compareCmd string
+	// shell commands run before the project's tests, after the run and before each test's build
+	preHook     string
+	postHook    string
+	preTestHook string
+	stderrAllow []*regexp.Regexp
+	// *** lines that fail a test despite a clean diff
+	failPattern  *regexp.Regexp
+	panicPattern *regexp.Regexp
+}
+
+// loads the config of every Makefile directory, the flags winning over it, and the accelerator they picked
+func loadProjectSettings(dirs []string, flags *argumentConfig, accel string) (map[string]projectSettings, error) {
+	projects := make(map[string]projectSettings, len(dirs))
+	for _, dir := range dirs {
+		config, err := loadProjectConfig(dir)
+		if err != nil {
+			return nil, err
+		}
+		settings := projectSettings{
+			config:           config,
+			accel:            accel,
+			okWildcards:      flags.OkWildcards || config.OkWildcards,
+			warningsAsErrors: flags.WarningsAsErrors || config.WarningsAsErrors,
+			compareCmd:       cmp.Or(flags.CompareCmd, config.CompareCmd),
+			preHook:          cmp.Or(flags.PreHook, config.PreHook),
+			postHook:         cmp.Or(flags.PostHook, config.PostHook),
+			preTestHook:      cmp.Or(flags.PreTestHook, config.PreTestHook),
+		}
+		if config.NoAccel && accel != accelDeterministic {
+			settings.accel = accelTCG
+		}
+		if settings.stderrAllow, err = compileStderrAllowlist(config.StderrAllow); err != nil {
+			return nil, err
+		}
+		if settings.failPattern, err = compileFailPattern(cmp.Or(flags.FailPattern, config.FailPattern)); err != nil {
+			return nil, err
+		}
+		if settings.panicPattern, err = compilePanicPattern(config.PanicPattern); err != nil {
+			return nil, err
+		}
+		projects[dir] = settings
+	}
+	return projects, nil
+}
+
+// the settings of the project whose Makefile is in dir
+func (m model) settings(dir string) projectSettings {
+	return m.projects[dir]
+}
+
+var kernelElfCandidates = []string{"kernel/build/kernel", "kernel/build/kernel.elf", "kernel/build/kernel.kernel"}
+
+// locates the kernel ELF, preferring the configured path over the usual build outputs
+func findKernelElf(makefileDir string, config projectConfig) (string, error) {
+	candidates := kernelElfCandidates
+	if config.KernelElf != "" {
+		candidates = []string{config.KernelElf}
+	}
+
+	for _, candidate := range candidates {
+		path := filepath.Join(makefileDir, candidate)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("kernel ELF not found (tried %s)", strings.Join(candidates, ", "))
+}
+
+// the Makefile for settings needed before discovery: the one of the first argument, or of the current directory
+func argsMakefile(args []string) (string, error) {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return findMakefile(dir)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/getsentry/sentry-go"
+)
+
+// exit code of a run that crashed, after the terminal was restored; 2 is taken by usage errors
+const exitCrashed = 4
+
+var errCrashed = errors.New("grunner crashed")
+
+/*
+ * Catches panics in the event loop (Update, View) and in commands, which bubbletea would otherwise
+ * leave the terminal in raw mode for. The program is killed, restoring the terminal, and the panic is
+ * reported by report() once Run has returned.
+ */
+type crashGuard struct {
+	program *tea.Program
+	once    sync.Once
+	value   any
+	stack   []byte
+	// the model as of the last Update that returned, for reports after a crash
+	last *model
+}
+
+func (g *crashGuard) crash(value any) {
+	g.once.Do(func() {
+		g.value = value
+		g.stack = debug.Stack()
+	})
+	g.program.Kill()
+}
+
+// runs the program, converting a panic in its event loop into errCrashed
+func (g *crashGuard) run() (finalModel tea.Model, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			g.crash(r)
+			finalModel, err = nil, errCrashed
+		}
+	}()
+	finalModel, err = g.program.Run()
+	if g.value != nil {
+		// a command panicked and killed the program
+		err = errCrashed
+	}
+	return finalModel, err
+}
+
+// prints the panic and its stack to stderr and sends it to Sentry, the terminal having been restored
+func (g *crashGuard) report() {
+	fmt.Fprintf(os.Stderr, "grunner crashed: %v\n\n%s\n", g.value, g.stack)
+	sentry.CurrentHub().Recover(g.value)
+	sentry.Flush(2 * time.Second)
+}
+
+// guards a command, along with the commands of any batch it returns
+func (g *crashGuard) guard(cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		defer func() {
+			if r := recover(); r != nil {
+				g.crash(r)
+			}
+		}()
+		msg := cmd()
+		if batch, ok := msg.(tea.BatchMsg); ok {
+			for i := range batch {
+				batch[i] = g.guard(batch[i])
+			}
+		}
+		return msg
+	}
+}
+
+// the model as run by bubbletea, with every command it returns guarded
+type guardedModel struct {
+	model
+	guard *crashGuard
+}
+
+func (m guardedModel) Init() tea.Cmd {
+	return m.guard.guard(m.model.Init())
+}
+
+func (m guardedModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	updated, cmd := m.model.Update(msg)
+	m.model = updated.(model)
+	last := m.model
+	m.guard.last = &last
+	return m, m.guard.guard(cmd)
+}
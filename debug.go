@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+)
+
+// builds a single test and boots it under qemu's gdb stub, streaming serial output until interrupted
+func runDebugSession(flags *argumentConfig) error {
+	testFiles, err := findTestFiles([]string{flags.Debug}, flags.MaxDepth)
+	if err != nil {
+		return err
+	}
+	if len(testFiles) == 0 {
+		return fmt.Errorf("no test found matching %s", flags.Debug)
+	} else if len(testFiles) > 1 {
+		var names []string
+		for _, testFile := range testFiles {
+			names = append(names, testFile.testName)
+		}
+		return fmt.Errorf("%s matches multiple tests (%v), only one can be debugged at a time", flags.Debug, names)
+	}
+	testFile := testFiles[0]
+
+	makefile, err := findMakefile(filepath.Dir(testFile.filePath))
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(makefile)
+
+	config, err := loadProjectConfig(dir)
+	if err != nil {
+		return err
+	}
+
+	env, err := subprocessEnv(flags.CleanEnv, flags.Env)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Println(grayStyle.Render(fmt.Sprintf("Building %s...", testFile.testName)))
+	// the test's build assumes the fixtures exist, as in a normal run
+	steps := [][]string{{"-C", "kernel"}}
+	for _, fixture := range append(slices.Clone(flags.Fixtures), config.Fixtures...) {
+		steps = append(steps, []string{fixture})
+	}
+	steps = append(steps, makeTargets(dir, testFile.baseName, buildTarget(testFile.baseName, testFile.isDir, config)))
+	for _, args := range steps {
+		e := exec.CommandContext(ctx, "make", args...)
+		e.Dir = dir
+		e.Env = env
+		e.Stdout = os.Stdout
+		e.Stderr = os.Stderr
+		if err := e.Run(); err != nil {
+			return fmt.Errorf("make error: %w", err)
+		}
+	}
+
+	accel := probeAccel(flags.NoAccel || config.NoAccel)
+	if flags.Deterministic {
+		accel = accelDeterministic
+	}
+
+	boot, err := resolveBoot(dir, testFile.baseName, config)
+	if err != nil {
+		return err
+	}
+	if flags.Verbose || !boot.usual {
+		fmt.Println(grayStyle.Render("Booting " + boot.desc))
+	}
+
+	elf, err := findKernelElf(dir, config)
+	if err != nil {
+		elf = "<kernel ELF>"
+		fmt.Println(errorStyle.Render(fmt.Sprintf("WARNING: %s, set kernel_elf in %s", err, projectConfigName)))
+	}
+
+	fmt.Println()
+	fmt.Println("qemu is paused waiting for a debugger. In another terminal, run:")
+	fmt.Printf("  gdb %s\n", elf)
+	fmt.Println("  (gdb) target remote :1234")
+	fmt.Println(grayStyle.Render("Serial output follows, press Ctrl+C to quit."))
+	fmt.Println()
+
+	// no iteration timeout, the session lasts until the user quits
+	qemuCmd := exec.CommandContext(ctx, QemuPath, append(qemuArgs(dir, testFile.baseName, boot, accel, 0, flags.Verbose, env, "", ""), "-s", "-S")...)
+	qemuCmd.Dir = dir
+	qemuCmd.Env = env
+	qemuCmd.Stdin = os.Stdin
+	qemuCmd.Stdout = os.Stdout
+	qemuCmd.Stderr = os.Stderr
+
+	err = qemuCmd.Run()
+	var exitErr *exec.ExitError
+	if ctx.Err() != nil || (errors.As(err, &exitErr) && cleanExit(exitErr.ExitCode())) {
+		// interrupted by the user, or the kernel exited through the architecture's exit device
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("qemu exited: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// an error carrying the captured output of the failing process, shown in the detail view
+type outputError struct {
+	err    error
+	output []byte
+}
+
+func (e outputError) Error() string {
+	if e.err == nil {
+		return "unknown error"
+	}
+	return e.err.Error()
+}
+func (e outputError) Unwrap() error { return e.err }
+
+const detailTailLines = 200
+
+var (
+	selectedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Bold(true)
+	helpStyle     = darkGrayStyle
+)
+
+// last n lines of the given output
+func tail(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > n {
+		lines = append([]string{fmt.Sprintf("… (%d earlier lines)", len(lines)-n)}, lines[len(lines)-n:]...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// what the detail view shows for a test: how its iterations ended and where they diverged, its diff,
+// compiler output or the tail of qemu's output, followed by any compiler warnings
+func detailContent(t testInfo) string {
+	content := failureContent(t)
+	if divergences := t.divergenceSummary(); len(divergences) > 0 {
+		content = strings.Join(divergences, "\n") + "\n\n" + content
+	}
+	if reasons := t.reasonSummary(); reasons != "" {
+		content = reasons + "\n\n" + content
+	}
+	if len(t.buildWarnings) > 0 && t.state != TestStateCompileFailure && t.state != TestStateSetupError {
+		content += fmt.Sprintf("\n\nCompiler warnings (%d):\n%s", len(t.buildWarnings), strings.Join(t.buildWarnings, "\n"))
+	}
+	return content
+}
+
+func failureContent(t testInfo) string {
+	if t.err == nil {
+		switch t.state {
+		case TestStateSuccess:
+			return "Test passed."
+		case TestStateWaiting, TestStateBuilding, TestStateRunning:
+			return "Test has not finished yet."
+		}
+		return "No details recorded."
+	}
+
+	var diffErr diffError
+	if errors.As(t.err, &diffErr) {
+		return string(diffErr.diff)
+	}
+
+	var outErr outputError
+	if errors.As(t.err, &outErr) {
+		if len(outErr.output) == 0 {
+			return t.err.Error() + "\n\n(no output captured)"
+		}
+		return t.err.Error() + "\n\n" + tail(string(outErr.output), detailTailLines)
+	}
+
+	return t.err.Error()
+}
+
+func (m *model) openDetail() {
+	if m.selected < 0 || m.selected >= len(m.testCases) {
+		return
+	}
+	m.showDetail = true
+	m.detail = viewport.New(m.window.width, max(m.window.height-4, 1))
+	m.detail.SetContent(detailContent(m.testCases[m.selected]))
+}
+
+func (m model) detailView() string {
+	test := m.testCases[m.selected]
+	title := titleStyle.Render(test.name)
+	scroll := darkGrayStyle.Render(fmt.Sprintf("  %3.f%%", m.detail.ScrollPercent()*100))
+
+	str := lipgloss.JoinHorizontal(lipgloss.Center, title, scroll) + "\n"
+	str += m.detail.View() + "\n"
+	str += helpStyle.Render("↑/↓ scroll · esc back")
+	return str
+}
+
+type pagerClosedMsg struct{ err error }
+
+/*
+ * Suspends the TUI and opens the selected test's .diff (or .raw if there is no diff) in $PAGER.
+ * Returns a notice instead when there is nothing to show.
+ */
+func openInPager(m model) (tea.Cmd, string) {
+	test := m.testCases[m.selected]
+	if test.state == TestStateCompileFailure {
+		return nil, fmt.Sprintf("%s did not compile, no .diff or .raw was written (press enter for the compiler output)", test.name)
+	} else if test.state == TestStateSetupError {
+		return nil, fmt.Sprintf("%s wasn't built, no .diff or .raw was written (press enter for make's output)", test.name)
+	} else if test.state != TestStateFailure && test.state != TestStateUnimplemented {
+		return nil, fmt.Sprintf("%s has no failure output to open", test.name)
+	}
+
+	path := diffPath(test)
+	if _, err := os.Stat(path); err != nil {
+		path = rawPath(test)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Sprintf("no .diff or .raw file was written for %s", test.name)
+		}
+	}
+
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less", "-R"}
+	}
+
+	c := exec.Command(pager[0], append(pager[1:], path)...)
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		return pagerClosedMsg{err}
+	}), ""
+}
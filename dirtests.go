@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ * .dir tests are directories holding several sources and their own expected output. The make target
+ * building them and the expected file inside them come from dir_target and dir_expected in the
+ * project config, where {name} stands for the test name.
+ */
+const (
+	defaultDirTarget   = "{name}"
+	defaultDirExpected = "expected/{name}.ok"
+)
+
+func expandTestName(template string, name string) string {
+	return strings.ReplaceAll(template, "{name}", name)
+}
+
+// make target that builds the test
+func buildTarget(name string, isDir bool, config projectConfig) string {
+	if !isDir {
+		return name
+	}
+	if config.DirTarget != "" {
+		return expandTestName(config.DirTarget, name)
+	}
+	return expandTestName(defaultDirTarget, name)
+}
+
+// expected output of the test, inside the directory for .dir tests that have one and next to the test otherwise
+func expectedOutputFile(filePath string, name string, isDir bool, config projectConfig) string {
+	sibling := testExtRe.ReplaceAllString(filePath, ".ok")
+	if !isDir {
+		return sibling
+	}
+
+	expected := config.DirExpected
+	if expected == "" {
+		expected = defaultDirExpected
+	}
+	inside := filepath.Join(filePath, expandTestName(expected, name))
+	if _, err := os.Stat(inside); err != nil {
+		return sibling
+	}
+	return inside
+}
+
+// the expected output path relative to the Makefile directory, where the diff runs
+func relativeToMakefile(makefileDir string, path string) string {
+	absDir, err := filepath.Abs(makefileDir)
+	if err != nil {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(absDir, absPath); err == nil {
+		return rel
+	}
+	return absPath
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the first expected line a failing iteration's output went wrong at
+type divergence struct {
+	// 1-based line of the .ok file
+	line    int
+	content string
+	// content is output the .ok file doesn't have, after line
+	extra bool
+}
+
+func (d divergence) String() string {
+	if d.extra {
+		return fmt.Sprintf("after line %d (extra %q)", d.line, d.content)
+	}
+	return fmt.Sprintf("at line %d (%q)", d.line, d.content)
+}
+
+// a hunk header of diff's normal format, which --ok-wildcards mimics: 3c3, 5,6d4, 7a8,9
+var hunkRe = regexp.MustCompile(`^\d+(?:,\d+)?([acd])(\d+)(?:,\d+)?$`)
+
+/*
+ * Finds the first divergence in a diff of the output against the .ok file. A changed or missing line
+ * is reported with its expected content, extra output with the line it appeared after. The zero
+ * divergence means the diff couldn't be parsed, e.g. one from --compare-cmd.
+ */
+func firstDivergence(diff []byte) divergence {
+	var kind string
+	var line int
+	for _, text := range strings.Split(ansiRe.ReplaceAllString(string(diff), ""), "\n") {
+		text = strings.TrimRight(text, "\r")
+		if kind == "" {
+			if match := hunkRe.FindStringSubmatch(text); match != nil {
+				kind = match[1]
+				line, _ = strconv.Atoi(match[2])
+			}
+			continue
+		}
+		if kind == "d" && strings.HasPrefix(text, "< ") {
+			return divergence{line: line, content: strings.TrimPrefix(text, "< "), extra: true}
+		}
+		if kind != "d" && strings.HasPrefix(text, "> ") {
+			return divergence{line: line, content: strings.TrimPrefix(text, "> ")}
+		}
+	}
+	return divergence{}
+}
+
+/*
+ * Groups the failing iterations of a test by where they first diverged, most common first, e.g.
+ * `first divergence at line 14 ("*** child exited 3") in 6/7 failures`. Empty unless at least two
+ * iterations failed on a diff, a single failure being its own diff.
+ */
+func (t testInfo) divergenceSummary() []string {
+	var failures int
+	counts := make(map[divergence]int)
+	for _, iteration := range t.iterations {
+		if iteration.timeSpanned == 0 || iteration.passed {
+			continue
+		}
+		failures++
+		if iteration.divergence.content != "" {
+			counts[iteration.divergence]++
+		}
+	}
+	if failures < 2 || len(counts) == 0 {
+		return nil
+	}
+
+	var divergences []divergence
+	for d := range counts {
+		divergences = append(divergences, d)
+	}
+	sort.Slice(divergences, func(i, j int) bool {
+		if counts[divergences[i]] != counts[divergences[j]] {
+			return counts[divergences[i]] > counts[divergences[j]]
+		}
+		return divergences[i].line < divergences[j].line
+	})
+
+	var lines []string
+	for _, d := range divergences {
+		lines = append(lines, fmt.Sprintf("first divergence %s in %d/%d failures", d, counts[d], failures))
+	}
+	return lines
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// variables kept by --clean-env, on top of the --env entries
+var cleanEnvKeys = []string{"PATH", "HOME", "QEMU_SMP"}
+
+/*
+ * Pulls every `name value` pair out of the arguments, so a flag can be repeated (--env A=1 --env B=2),
+ * which the argument parser doesn't allow. Several names can be given for a flag with a short form.
+ */
+func extractRepeatedFlag(args []string, names ...string) ([]string, []string) {
+	var rest, values []string
+	for i := 0; i < len(args); i++ {
+		if slices.Contains(names, args[i]) && i+1 < len(args) {
+			values = append(values, args[i+1])
+			i++
+		} else {
+			rest = append(rest, args[i])
+		}
+	}
+	return rest, values
+}
+
+/*
+ * Environment of make and qemu: the parent's, or only the whitelisted variables with --clean-env,
+ * overridden by the --env entries. Returns nil to inherit the parent's unchanged.
+ */
+func subprocessEnv(clean bool, extra []string) ([]string, error) {
+	for _, entry := range extra {
+		if key, _, ok := strings.Cut(entry, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid --env %q, expected KEY=VALUE", entry)
+		}
+	}
+	if !clean && len(extra) == 0 {
+		return nil, nil
+	}
+
+	var env []string
+	if clean {
+		for _, key := range cleanEnvKeys {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+	} else {
+		env = os.Environ()
+	}
+	// later entries win when the same key appears twice
+	return append(env, extra...), nil
+}
+
+func envMap(env []string) map[string]string {
+	vars := make(map[string]string)
+	for _, entry := range env {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// value of a variable in the subprocess environment, which is the parent's when env is nil
+func lookupEnv(env []string, key string) (string, bool) {
+	if env == nil {
+		return os.LookupEnv(key)
+	}
+	value, ok := envMap(env)[key]
+	return value, ok
+}
+
+// added (+), changed (~) and removed (-) variables of the subprocess environment, for verbose mode
+func envDiff(parent []string, env []string) []string {
+	if env == nil {
+		return nil
+	}
+	before, after := envMap(parent), envMap(env)
+
+	var diff []string
+	for key, value := range after {
+		if old, ok := before[key]; !ok {
+			diff = append(diff, fmt.Sprintf("+%s=%s", key, value))
+		} else if old != value {
+			diff = append(diff, fmt.Sprintf("~%s=%s", key, value))
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff = append(diff, "-"+key)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i][1:] < diff[j][1:] })
+	return diff
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// a newline-delimited JSON progress event for --event-fd/--event-file consumers such as editor integrations
+type event struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Test  string    `json:"test,omitempty"`
+	// discovered tests, on run-started
+	Tests []string `json:"tests,omitempty"`
+	// 1-based, on iteration-finished
+	Iteration  int           `json:"iteration,omitempty"`
+	Passed     *bool         `json:"passed,omitempty"`
+	DurationMs int64         `json:"duration_ms,omitempty"`
+	State      string        `json:"state,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Summary    *eventSummary `json:"summary,omitempty"`
+}
+
+type eventSummary struct {
+	Passed      int  `json:"passed"`
+	Total       int  `json:"total"`
+	Interrupted bool `json:"interrupted"`
+}
+
+// writes events unbuffered so consumers see them as they happen; a nil writer drops them
+type eventWriter struct {
+	file     *os.File
+	resolved map[int]bool
+	finished bool
+}
+
+// whether fd is open for writing; os.NewFile takes any number, and writes to a closed fd fail silently
+func writableFd(fd int) bool {
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	return err == nil && flags&unix.O_ACCMODE != unix.O_RDONLY
+}
+
+func openEventWriter(fd int, path string) (*eventWriter, error) {
+	var file *os.File
+	switch {
+	case path != "":
+		var err error
+		if file, err = os.Create(path); err != nil {
+			return nil, fmt.Errorf("error creating event file: %w", err)
+		}
+	case fd > 0:
+		if !writableFd(fd) {
+			return nil, fmt.Errorf("--event-fd %d is not a file descriptor open for writing", fd)
+		}
+		file = os.NewFile(uintptr(fd), "events")
+	default:
+		return nil, nil
+	}
+	return &eventWriter{file: file, resolved: make(map[int]bool)}, nil
+}
+
+func (w *eventWriter) emit(e event) {
+	if w == nil {
+		return
+	}
+	e.Time = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	_, _ = w.file.Write(append(data, '\n'))
+}
+
+func (w *eventWriter) runStarted(m model) {
+	var tests []string
+	for _, testCase := range m.testCases {
+		tests = append(tests, testCase.name)
+	}
+	w.emit(event{Event: "run-started", Tests: tests})
+}
+
+func (w *eventWriter) iterationFinished(t testInfo, passed bool, err error) {
+	e := event{Event: "iteration-finished", Test: t.name, Iteration: t.currIter + 1, Passed: &passed, DurationMs: t.iterations[t.currIter].timeSpanned.Milliseconds()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	w.emit(e)
+}
+
+// emits test-resolved for newly resolved tests, and run-finished once when the run is over
+func (w *eventWriter) sync(m model, over bool) {
+	if w == nil {
+		return
+	}
+	for _, testCase := range m.testCases {
+		if testCase.resolved && !w.resolved[testCase.id] {
+			w.resolved[testCase.id] = true
+			e := event{Event: "test-resolved", Test: testCase.name, State: stateLabel(testCase)}
+			if testCase.err != nil && testCase.state != TestStateSuccess {
+				e.Error = testCase.err.Error()
+			}
+			w.emit(e)
+		}
+	}
+
+	if over && !w.finished {
+		w.finished = true
+		report := m.report()
+		w.emit(event{Event: "run-finished", Summary: &eventSummary{Passed: report.Passed, Total: len(report.Tests), Interrupted: report.Interrupted}})
+	}
+}
+
+func (w *eventWriter) Close() {
+	if w != nil {
+		_ = w.file.Close()
+	}
+}
@@ -14,267 +14,667 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 // QemuPath embedded in makefile
 var QemuPath string
 
-type startBuildingTests struct{}
-type buildTestMsg []int
+// the kernel of the project in dir was built, so its tests can start
+type startBuildingTests struct{ dir string }
 
-func makeDependencies(ctx context.Context, dir string) tea.Cmd {
+type dependencyErr struct {
+	dir string
+	err error
+}
+
+// asks Update to start the tests that can run, see tryStartExecutors
+type scheduleMsg struct{}
+
+// the --stagger interval since the last dispatch has passed
+type staggerDoneMsg struct{}
+
+// how long make gets for the dependencies or a test, before --timeout-multiplier
+const buildTimeout = 10 * time.Second
+
+func makeDependencies(ctx context.Context, dir string, env []string, timeout time.Duration) tea.Cmd {
 	return func() tea.Msg {
 		span := sentry.StartSpan(ctx, "function")
 		span.Description = "makeDependencies"
 		defer span.Finish()
 
-		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
 		var output bytes.Buffer
 		e := exec.CommandContext(ctx, "make", "-C", "kernel")
 		e.Dir = dir
+		e.Env = env
 		e.Stderr = &output
 		err := e.Run()
 		if err != nil {
-			return errMsg{err: fmt.Errorf("make error: %w\n%s\n\n(Using makefile at: %s)", err,
+			return dependencyErr{dir, fmt.Errorf("make error: %w\n%s\n\n(Using makefile at: %s)", err,
 				lipgloss.NewStyle().
 					MarginLeft(2).
 					BorderStyle(lipgloss.NormalBorder()).BorderLeft(true).
 					Render(output.String()), dir)}
 		} else {
-			return startBuildingTests{}
+			return startBuildingTests{dir}
 		}
 	}
 }
 
-func buildTestCase(ctx context.Context, dir string, testCase testInfo) tea.Cmd {
-	return func() tea.Msg {
-		span := sentry.StartSpan(ctx, "function")
-		span.Description = fmt.Sprintf("build.%d", testCase.id)
-		defer span.Finish()
+func buildTestCase(ctx context.Context, dir string, env []string, project projectSettings, timeout time.Duration, hookTimeout time.Duration, testCase testInfo) tea.Cmd {
+	return func() (msg tea.Msg) {
+		span := sentry.StartSpan(ctx, "build", sentry.WithDescription(fmt.Sprintf("build %s", testCase.name)))
+		span.SetTag("test", testCase.name)
+		defer func() { finishWorkSpan(span, msg) }()
+
+		// the hook doesn't eat into the build's timeout
+		if project.preTestHook != "" {
+			hookCtx, cancel := context.WithTimeout(ctx, hookTimeout)
+			err := runHook(hookCtx, dir, env, project.preTestHook, testCase.baseName)
+			cancel()
+			if err != nil {
+				return testBuildErr{testCase.id, errMsg{err: err}}
+			}
+		}
 
-		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
-		_ = os.Remove(fmt.Sprintf("%s.diff", testCase.name))
-
-		// todo: make less janky, and configurable per-project
-		// check if Makefile contains .data build steps
-		makefileData, _ := os.ReadFile(filepath.Join(dir, "Makefile"))
+		var output bytes.Buffer
+		targets := makeTargets(dir, testCase.baseName, testCase.target)
+		e := exec.CommandContext(ctx, "make", targets...)
+		e.Dir = dir
+		e.Env = env
+		e.Stdout = &output
+		e.Stderr = &output
+		err := e.Run()
+		// keep the compiler output for the detail view
+		if err != nil {
+			if problem, ok := buildSetupError(err, output.String(), testCase.target); ok {
+				return testBuildErr{testCase.id, errMsg{err: outputError{err: problem, output: output.Bytes()}}}
+			}
+			return testBuildErr{testCase.id, errMsg{err: outputError{err: fmt.Errorf("compile error: %w", err), output: output.Bytes()}}}
+		}
 
-		var e *exec.Cmd
-		if bytes.Contains(makefileData, []byte(".data")) {
-			e = exec.CommandContext(ctx, "make", testCase.name, testCase.name+".data")
-		} else {
-			e = exec.CommandContext(ctx, "make", testCase.name)
+		warnings := compilerWarnings(output.String())
+		if project.warningsAsErrors && len(warnings) > 0 {
+			return testBuildErr{testCase.id, errMsg{err: outputError{err: fmt.Errorf("compile error: %d warning(s) with --warnings-as-errors", len(warnings)), output: output.Bytes()}}}
 		}
 
-		e.Dir = dir
-		err := e.Run()
-		// pipe the output to the terminal
+		boot, err := resolveBoot(dir, testCase.baseName, project.config)
 		if err != nil {
-			return testBuildErr{testCase.id, errMsg{err: fmt.Errorf("compile error: %w", err)}}
-		} else {
-			return testBuildSuccess(testCase.id)
+			return testBuildErr{testCase.id, errMsg{err: outputError{err: err, output: output.Bytes()}}}
+		}
+		// a misnamed .data rule makes happily, and qemu would then run without the disk
+		dataFile := dataFilePath(dir, testCase.baseName)
+		if _, err := os.Stat(dataFile); err != nil && slices.Contains(targets, filepath.Base(dataFile)) {
+			err = fmt.Errorf("compile error: make succeeded but didn't produce %s", relativeToMakefile(dir, dataFile))
+			return testBuildErr{testCase.id, errMsg{err: outputError{err: err, output: output.Bytes()}}}
 		}
+		return testBuildSuccess{testCase.id, boot, warnings}
+	}
+}
+
+// the second disk of a test, attached when it exists
+func dataFilePath(dir string, testName string) string {
+	return filepath.Join(dir, testName+".data")
+}
+
+// make targets needed to build a test, target being the one that builds its image
+func makeTargets(dir string, testName string, target string) []string {
+	if makefileBuildsData(dir) {
+		return []string{target, testName + ".data"}
 	}
+	return []string{target}
+}
+
+// whether the Makefile has .data build steps, so tests get a second disk
+func makefileBuildsData(dir string) bool {
+	// todo: make less janky, and configurable per-project
+	makefileData, _ := os.ReadFile(filepath.Join(dir, "Makefile"))
+	return bytes.Contains(makefileData, []byte(".data"))
 }
 
 type testBuildErr struct {
 	int
 	errMsg
 }
-type testBuildSuccess int
+type testBuildSuccess struct {
+	int
+	boot bootMethod
+	// compiler warnings in the build output
+	warnings []string
+}
+
+// lines of build output that are compiler warnings
+func compilerWarnings(output string) []string {
+	var warnings []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(ansiRe.ReplaceAllString(line, ""), "\r")
+		if strings.Contains(line, "warning:") {
+			warnings = append(warnings, line)
+		}
+	}
+	return warnings
+}
 
 const ansi = "[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))"
 
 var ansiRe = regexp.MustCompile(ansi)
 
+// qemu stderr lines that are known to be harmless, extended by stderr_allow in the project config
+var benignStderr = []string{
+	`warning: TCG doesn't support requested feature`,
+	`warning: host doesn't support requested feature`,
+	`warning: Number of hotpluggable cpus requested`,
+}
+
+func compileStderrAllowlist(extra []string) ([]*regexp.Regexp, error) {
+	var allowlist []*regexp.Regexp
+	for _, pattern := range append(benignStderr, extra...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stderr_allow pattern %q: %w", pattern, err)
+		}
+		allowlist = append(allowlist, re)
+	}
+	return allowlist, nil
+}
+
+// *** lines matching this fail a test even when its diff is clean, overridable with --fail-pattern
+const defaultFailPattern = `fail`
+
+func compileFailPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = defaultFailPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fail pattern %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// the first of the filtered *** lines matching the fail pattern
+func failLine(filtered string, pattern *regexp.Regexp) (string, bool) {
+	for _, line := range strings.Split(filtered, "\n") {
+		if pattern.MatchString(line) {
+			return line, true
+		}
+	}
+	return "", false
+}
+
+// splits qemu's stderr into the lines that matter and the allowlisted warnings that were suppressed
+func filterStderr(stderr string, allowlist []*regexp.Regexp) (string, []string) {
+	var remaining []string
+	var suppressed []string
+
+lines:
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		for _, re := range allowlist {
+			if re.MatchString(line) {
+				suppressed = append(suppressed, line)
+				continue lines
+			}
+		}
+		remaining = append(remaining, line)
+	}
+
+	return strings.Join(remaining, "\n"), suppressed
+}
+
+// arguments to boot a test in qemu, shared by the test runner and debug sessions
+func qemuArgs(dir string, testName string, boot bootMethod, accel string, tbSizeMB int, verbose bool, env []string, qmpSocket string, debugLog string) []string {
+	qemuNumCores, qemuEnvProvided := lookupEnv(env, "QEMU_SMP")
+	if !qemuEnvProvided {
+		qemuNumCores = "4"
+	}
+
+	// each flag and value is its own argument, so paths with spaces reach qemu intact
+	qemuArgs := slices.Concat(accelArgs(accel, qemuNumCores, tbSizeMB), []string{"-smp", qemuNumCores, "-m", fmt.Sprintf("%dm", guestMemMB), "-no-reboot", "-nographic", "--monitor", "none"})
+	if verbose {
+		qemuArgs = append(qemuArgs, "-d", "guest_errors")
+	}
+	if qmpSocket != "" {
+		qemuArgs = append(qemuArgs, "-qmp", "unix:"+qemuOptionValue(qmpSocket)+",server=on,wait=off")
+	}
+	if debugLog != "" {
+		// COM1 stays on stdout for grading, the kernel's debug logging on COM2 goes to a file
+		qemuArgs = append(qemuArgs, "-serial", "stdio", "-serial", "file:"+debugLog)
+	}
+	// check to see if test.data exists
+	dataFile := dataFilePath(dir, testName)
+	if _, err := os.Stat(dataFile); err == nil {
+		qemuArgs = append(qemuArgs, "-drive", driveArg(dataFile, 1))
+	}
+	return slices.Concat(qemuArgs, qemuArch.args, boot.args)
+}
+
 func runTestCase(m *model, testCase testInfo) tea.Cmd {
-	dir := m.makefileDir
+	dir := testCase.makefileDir
 	ctx := m.context
+	if testCase.runSpan != nil {
+		ctx = testCase.runSpan.Context()
+	}
 
-	return func() tea.Msg {
-		defer sentry.RecoverWithContext(ctx)
+	return func() (msg tea.Msg) {
+		// finished on every return, and on a panic as a crash
+		span := sentry.StartSpan(ctx, "iteration", sentry.WithDescription(fmt.Sprintf("%s #%d", testCase.name, testCase.currIter+1)))
+		span.SetTag("test", testCase.name)
+		span.SetTag("iteration", fmt.Sprint(testCase.currIter))
+		defer func() { finishWorkSpan(span, msg) }()
 
-		span := sentry.StartSpan(ctx, "function")
-		span.Description = fmt.Sprintf("run.%d", testCase.id)
-		defer span.Finish()
+		var output bytes.Buffer
+		var stderr bytes.Buffer
+
+		// symbolize any kernel panic in a failed iteration's output
+		defer func() {
+			if runErr, ok := msg.(testRunError); ok && output.Len() > 0 {
+				msg = annotatePanic(ctx, m, testCase, runErr, output.String())
+			}
+		}()
 
-		ctx, cancel := context.WithTimeout(ctx, m.iterationTimeout)
+		timeout := m.testTimeout(testCase)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
-		qemuNumCores, qemuEnvProvided := os.LookupEnv("QEMU_SMP")
-		if !qemuEnvProvided {
-			qemuNumCores = "4"
+		// kept whatever the outcome, failures point to it
+		if m.captureStderr {
+			defer func() {
+				if errors.Is(ctx.Err(), context.Canceled) {
+					return
+				}
+				path := stderrPath(testCase, testCase.currIter+1)
+				if err := writeCapturedStderr(path, stderr.Bytes()); err != nil {
+					return
+				}
+				if runErr, ok := msg.(testRunError); ok {
+					runErr.err = fmt.Errorf("%w\nqemu stderr in %s", runErr.err, path)
+					msg = runErr
+				}
+			}()
 		}
 
-		imageFile := filepath.Join(dir, "kernel/build/", testCase.name+".img")
-		qemuArgs := fmt.Sprintf("-accel tcg,thread=multi -cpu max -smp %s -m 128m -no-reboot -nographic --monitor none -drive file=%s,index=0,media=disk,format=raw,file.locking=off -device isa-debug-exit,iobase=0xf4,iosize=0x04", qemuNumCores, imageFile)
-		if m.verbose {
-			qemuArgs += " -d guest_errors"
+		// without a socket qemu is still stopped, just with signals only
+		qmpSocket, removeSocket, err := qmpSocketPath(m.tmpDir)
+		if err != nil {
+			qmpSocket = ""
 		}
-		// check to see if test.data exists
-		dataFile := filepath.Join(dir, testCase.name+".data")
-		//return testRunError{testCase.id, errMsg{err: fmt.Errorf(dataFile)}}
-		if _, err := os.Stat(dataFile); err == nil {
-			qemuArgs += " -drive file=" + dataFile + ",index=1,media=disk,format=file,locking=off"
+		defer removeSocket()
+
+		var debugLog string
+		if m.captureDebug {
+			debugLog = debugLogPath(testCase)
 		}
-		qemuCmd := exec.CommandContext(ctx, QemuPath, strings.Fields(qemuArgs)...)
-		qemuCmd.Dir = dir
 
-		var output bytes.Buffer
-		var stderr bytes.Buffer
+		qemuName, qemuArgv := memLimitedCommand(QemuPath, qemuArgs(dir, testCase.baseName, testCase.boot, m.settings(testCase.makefileDir).accel, limitedTBSize(m.hostMemLimit), m.verbose, m.env, qmpSocket, debugLog), m.hostMemLimit)
+		qemuCmd := exec.CommandContext(ctx, qemuName, qemuArgv...)
+		qemuCmd.Dir = dir
+		qemuCmd.Env = m.env
 		//qemuCmd.Stdout = &output
 		qemuCmd.Stderr = &stderr
+		var guestStatus string
+		stopKill := setGracefulShutdown(qemuCmd, m.killGrace, qmpSocket, &guestStatus)
 
-		rawFile, err := os.Create(fmt.Sprintf("%s.raw", testCase.name))
+		// the .raw is swapped in once the iteration is over, even if it failed
+		rawFile, err := createAtomic(rawPath(testCase), 0644)
 		if err != nil {
 			wrappedErr := fmt.Errorf("failed to create raw file: %w", err)
 			sentry.CaptureException(wrappedErr)
-			return testRunError{testCase.id, errMsg{err: wrappedErr}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: wrappedErr}}
 		}
-		defer rawFile.Close()
+		// a cancelled iteration leaves no partial .raw behind, unlike a timed out one
+		defer func() {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				rawFile.Discard()
+			} else {
+				_ = rawFile.Commit()
+			}
+		}()
 
 		stdoutPipe, _ := qemuCmd.StdoutPipe()
 		err = qemuCmd.Start()
 
 		if err := ctx.Err(); err != nil {
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("qemu start timed out")}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("qemu start timed out")}}
 		}
 
 		if err != nil {
 			wrappedErr := fmt.Errorf("failed to start qemu: %w", err)
 			sentry.CaptureException(wrappedErr)
-			return testRunError{testCase.id, errMsg{err: wrappedErr}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: wrappedErr}}
 		}
 
-		// stream the output to the .raw file
-		rawLength, err := io.Copy(io.MultiWriter(rawFile, &output), stdoutPipe)
+		// stream the output to the .raw file, timestamped there only so .out and the diff are unaffected
+		var rawWriter io.Writer = rawFile
+		if m.timestamps {
+			rawWriter = newTimestampWriter(rawFile, time.Now())
+		}
+		rawLength, err := io.Copy(io.MultiWriter(rawWriter, &output), stdoutPipe)
 		if err != nil {
 			wrappedErr := fmt.Errorf("failed to write .raw: %w", err)
 			sentry.CaptureException(wrappedErr)
-			return testRunError{testCase.id, errMsg{err: wrappedErr}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: wrappedErr}}
 		}
-		if rawLength == 0 {
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf(fmt.Sprintf("empty .raw file %s", stderr.String()))}}
+		err = qemuCmd.Wait()
+		stopKill()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: context.Canceled}}
 		}
 
-		err = qemuCmd.Wait()
+		// a killed iteration still gets its partial output written, marked so it isn't mistaken for the whole run
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		if timedOut {
+			marker := fmt.Sprintf("*** [grunner: killed after %s]", timeout)
+			fmt.Fprintf(rawFile, "\n%s\n", marker)
+			fmt.Fprintf(&output, "\n%s\n", marker)
+		} else if rawLength == 0 {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf(fmt.Sprintf("empty .raw file %s", stderr.String()))}}
+		}
 
 		// keep only the lines that start with ***
 		lines := strings.Split(output.String(), "\n")
 		var newOutput string
 		for _, line := range lines {
-			line := ansiRe.ReplaceAllString(line, "")
+			line := strings.TrimRight(ansiRe.ReplaceAllString(line, ""), " \t\r")
 			if strings.HasPrefix(line, "***") {
 				newOutput += line + "\n"
 			}
 		}
 
 		// write the filtered output
-		outErr := os.WriteFile(fmt.Sprintf("%s.out", testCase.name), []byte(newOutput), 0644)
+		outErr := writeFileAtomic(outPath(testCase), []byte(newOutput), 0644)
 		if outErr != nil {
 			wrappedErr := fmt.Errorf("failed to write .out: %w", outErr)
 			sentry.CaptureException(wrappedErr)
-			return testRunError{testCase.id, errMsg{err: wrappedErr}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: wrappedErr}}
 		}
 
+		// a stub is reported as such whatever the diff says, a timeout or crash usually follows it
+		if missing, ok := findMissingCode(output.String()); ok {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: missing}}
+		}
+
+		// the partial output would only produce a misleading diff
 		if err := ctx.Err(); err != nil {
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("timed out")}}
+			timeoutErr := errTimedOut
+			if guestStatus != "" {
+				// a paused or panicked guest points somewhere else than one still running, e.g. deadlocked
+				timeoutErr = fmt.Errorf("%w (guest status: %s)", errTimedOut, guestStatus)
+			}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: outputError{err: timeoutErr, output: output.Bytes()}}}
+		}
+
+		if m.hostMemLimit > 0 && err != nil && hitMemLimit(stderr.String()) {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("host memory limit exceeded (%d MB), raise it with --host-mem-limit", m.hostMemLimit>>20)}}
 		}
 
 		var exitErr2 *exec.ExitError
 		if errors.As(err, &exitErr2) {
-			if exitErr2.ExitCode() != 1 {
+			if !cleanExit(exitErr2.ExitCode()) {
 				wrappedErr := fmt.Errorf("qemu failed: %w, %s", err, stderr.String())
 				sentry.CaptureException(wrappedErr)
-				return testRunError{testCase.id, errMsg{err: wrappedErr}}
+				return testRunError{testCase.id, testCase.currIter, errMsg{err: wrappedErr}}
 			}
 		}
 
-		if stderr.Len() > 0 {
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("qemu stderr: %w, %s", err, stderr.String())}}
+		project := m.settings(testCase.makefileDir)
+		qemuStderr, suppressed := filterStderr(stderr.String(), project.stderrAllow)
+		if len(qemuStderr) > 0 {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("qemu stderr: %w, %s", err, qemuStderr)}}
 		}
 
-		// run diff between the output and the .ok file
-		var diffOut bytes.Buffer
-		diffArgs := fmt.Sprintf("-wBb --color=always - %s", testExtRe.ReplaceAllString(testCase.filePath, ".ok"))
-		d := exec.CommandContext(ctx, "diff", strings.Fields(diffArgs)...)
-		d.Dir = dir
-		d.Stdin = strings.NewReader(newOutput)
-		d.Stdout = &diffOut
-		diffErr := d.Run()
+		// without an .ok file there is nothing to diff against, and the .diff would just be the whole output
+		if !hasExpectedOutput(testCase) {
+			if !m.updateOk {
+				return testRunError{testCase.id, testCase.currIter, errMsg{err: missingOkError{okPath(testCase)}}}
+			}
+			if err := writeFileAtomic(okPath(testCase), []byte(newOutput), 0644); err != nil {
+				return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("failed to create .ok: %w", err)}}
+			}
+		}
 
+		// compare the output against the .ok file(s)
+		result, diffErr := compareOutput(ctx, m, testCase, newOutput)
+
+		var cmdErr compareCmdError
+		if errors.As(diffErr, &cmdErr) {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: cmdErr}}
+		}
 		if diffErr != nil {
 			// store to .diff
-			err = os.WriteFile(filepath.Join(dir, testCase.name+".diff"), diffOut.Bytes(), 0644)
+			err = writeFileAtomic(diffPath(testCase), result.diff, 0644)
 			if err != nil {
-				return testRunError{testCase.id, errMsg{err: fmt.Errorf("failed to write diff: %w", err)}}
+				return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("failed to write diff: %w", err)}}
 			}
 
-			if strings.Contains(output.String(), "*** Missing code at") {
-				return testRunError{testCase.id, errMsg{err: fmt.Errorf("missing code")}}
+			diffErr := diffError{msg: "diff found", diff: result.diff, diffPath: diffPath(testCase)}
+			if m.partial {
+				diffErr.score = partialScore(result.matched, newOutput)
 			}
-
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("diff found")}}
+			if len(result.candidates) > 1 {
+				diffErr.msg = fmt.Sprintf("diff found (closest to %s, tried %s)", filepath.Base(result.matched), result.describeCandidates())
+			}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: diffErr}}
 		} else {
 			if testCase.resolved {
 				log.Panicf("tried running an already resolved test %s", testCase.name)
 			}
+			// a pass supersedes the diff left by a previous run, but not one from an earlier iteration of this run
+			if testCase.currIter == 0 {
+				_ = os.Remove(diffPath(testCase))
+			}
 		}
 
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			if exitErr.ExitCode() == 1 {
-				return testRunSuccess(testCase.id)
+			if cleanExit(exitErr.ExitCode()) {
+				return testRunSuccess{testCase.id, testCase.currIter, suppressed, result}
 			}
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("failed with code %d: %s", exitErr.ExitCode(), exitErr.Stderr)}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("failed with code %d: %s", exitErr.ExitCode(), exitErr.Stderr)}}
 		}
 		if err != nil {
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("failed: %w", err)}}
-		} else if diffOut.Len() > 0 || strings.Contains(output.String(), "fail") {
-			return testRunError{testCase.id, errMsg{err: fmt.Errorf("failed test: %s", output.String())}}
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("failed: %w", err)}}
+		} else if len(result.diff) > 0 {
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("failed test: %s", output.String())}}
+		} else if line, ok := failLine(newOutput, project.failPattern); ok {
+			// the diff passed, so say which rule tripped
+			return testRunError{testCase.id, testCase.currIter, errMsg{err: fmt.Errorf("diff passed but output matched the fail pattern %q: %s", project.failPattern, line)}}
 		} else {
-			return testRunSuccess(testCase.id)
+			return testRunSuccess{testCase.id, testCase.currIter, suppressed, result}
+		}
+	}
+}
+
+// how long a quit waits for executors to finish writing artifacts
+const quitGrace = 2 * time.Second
+
+// the executors have wound down after a quit, or quitGrace ran out
+type executorsDoneMsg struct{}
+
+// runs cmd as an executor that a quit waits for
+func (m model) track(cmd tea.Cmd) tea.Cmd {
+	m.executors.Add(1)
+	return func() tea.Msg {
+		defer m.executors.Done()
+		return cmd()
+	}
+}
+
+// waits for the executors, whose context was cancelled, to return so no artifact is left half-written
+func waitForExecutors(executors *sync.WaitGroup) tea.Cmd {
+	return func() tea.Msg {
+		done := make(chan struct{})
+		go func() {
+			executors.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(quitGrace):
 		}
+		return executorsDoneMsg{}
+	}
+}
+
+// size at which --capture-stderr cuts an iteration's stderr, so a qemu stuck printing warnings can't fill the disk
+const maxCapturedStderr = 1 << 20
+
+func writeCapturedStderr(path string, stderr []byte) error {
+	if len(stderr) > maxCapturedStderr {
+		stderr = append(stderr[:maxCapturedStderr:maxCapturedStderr], fmt.Sprintf("\n[grunner: truncated, %d bytes in total]\n", len(stderr))...)
 	}
+	return writeFileAtomic(path, stderr, 0644)
 }
 
+// results of an iteration, tagged with its 0-based index so a late or duplicate one can be told apart
 type testRunError struct {
 	int
+	iteration int
 	errMsg
 }
 
-type testRunSuccess int
+type testRunSuccess struct {
+	int
+	iteration int
+	// allowlisted qemu stderr lines, shown in verbose mode
+	warnings []string
+	comparison
+}
 
-func tryStartExecutors(m model) tea.Cmd {
-	return func() tea.Msg {
-		threadsLeft := m.maxThreads
-		var toStart []int
+// marks the tests of the project in dir as ready to build once its kernel and pre-hook are done
+func (m *model) startProject(dir string) []tea.Cmd {
+	for i := range m.testCases {
+		if test := &m.testCases[i]; test.makefileDir == dir && test.config == m.phase {
+			test.depsReady = true
+		}
+	}
+	return append(m.startPrereqs(dir), m.tryStartExecutors())
+}
 
-		for _, test := range m.testCases {
-			if test.running {
-				threadsLeft--
-			}
+// the threads a run backs off to when the machine is over --max-load
+const overloadedThreads = 2
+
+/*
+ * Whether the load of the machine, not counting the run's own qemus, is over --max-load. Each running
+ * test counts as one; TCG qemus can use more, so a busy run backs off a little early.
+ */
+func (m model) overloaded() bool {
+	if m.maxLoad <= 0 {
+		return false
+	}
+	load, err := loadAverage()
+	if err != nil {
+		return false
+	}
+	var running int
+	for _, test := range m.testCases {
+		if test.running {
+			running++
 		}
+	}
+	return load-float64(running) > m.maxLoad
+}
 
-		for i := range m.testCases {
-			test := &m.testCases[i]
-			if test.state == TestStateWaiting {
-				toStart = append(toStart, i)
-				threadsLeft--
-			}
+/*
+ * Schedules a dispatch of the tests that can run. The tests are picked in Update, from the state at
+ * that point, so several tests resolving at once can't start the same test twice or more tests than
+ * there are threads; a dispatch already scheduled covers them all.
+ */
+func (m *model) tryStartExecutors() tea.Cmd {
+	if m.scheduled {
+		return nil
+	}
+	m.scheduled = true
+	return func() tea.Msg { return scheduleMsg{} }
+}
+
+// the waiting tests to start now, in dispatch order
+func (m model) testsToStart() []int {
+	if !m.canDispatch() {
+		return nil
+	}
+	threadsLeft := m.maxThreads
+	if m.overloaded() {
+		threadsLeft = min(threadsLeft, overloadedThreads)
+	}
+	var toStart []int
+	// exclusive groups of the running tests, and of the ones about to start
+	busy := make(map[string]bool)
+	var running int
+
+	for _, test := range m.testCases {
+		if test.running {
+			threadsLeft--
+			running++
+			busy[test.exclusive] = true
+		}
+	}
+
+	// tests whose project or prerequisites aren't built yet, or whose group is busy, keep their place
+	for _, i := range m.queue() {
+		if threadsLeft <= 0 || busy[exclusiveAll] {
+			break
+		}
+		test := m.testCases[i]
+		if !test.depsReady || !m.prereqsReady(test) {
+			continue
+		}
+		if test.exclusive == exclusiveAll && running+len(toStart) > 0 {
+			continue
+		}
+		if test.exclusive != "" && busy[test.exclusive] {
+			continue
+		}
+		toStart = append(toStart, i)
+		threadsLeft--
+		busy[test.exclusive] = true
+	}
+	// todo: parallelize iterations if nothing else to do
 
-			if threadsLeft == 0 {
+	// staggered tests are dispatched one at a time, see dispatch
+	if m.stagger > 0 && len(toStart) > 1 {
+		toStart = toStart[:1]
+	}
+	return toStart
+}
+
+// starts building the tests, marking them as building before the build runs so none starts twice
+func (m *model) dispatch(toStart []int) []tea.Cmd {
+	var cmds []tea.Cmd
+	for _, testId := range toStart {
+		test := &m.testCases[testId]
+		if test.state != TestStateWaiting {
+			continue
+		}
+		if m.stagger > 0 {
+			// spaced out so qemu processes don't all open the same image at once
+			if wait := m.stagger - time.Since(m.lastDispatch); wait > 0 {
+				cmds = append(cmds, tea.Tick(wait, func(time.Time) tea.Msg { return staggerDoneMsg{} }))
 				break
 			}
+			m.lastDispatch = time.Now()
+		}
+		test.state = TestStateBuilding
+		test.running = true
+		test.iterations[test.currIter].dispatchTime = time.Now()
+		m.events.emit(event{Event: "test-building", Test: test.name})
+		m.startTestSpan(test)
+		cmds = append(cmds, m.track(buildTestCase(test.span.Context(), test.makefileDir, m.buildEnv(test.config), m.settings(test.makefileDir), m.scaleTimeout(buildTimeout), m.scaleTimeout(hookTimeout), *test)))
+		if m.stagger > 0 {
+			// the next test, once the interval has passed
+			cmds = append(cmds, m.tryStartExecutors())
 		}
-		// todo: parallelize iterations if nothing else to do
-
-		return buildTestMsg(toStart)
 	}
+	return cmds
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// several tests resolving in one batch must not dispatch a waiting test twice, nor more than -T at once
+func TestBatchedResolutionsDispatchOnce(t *testing.T) {
+	m := newTestModel(8)
+	m.maxThreads = 3
+	dispatches := make(map[int]int)
+	var dispatchTimes [8]time.Time
+
+	// counts the tests that went from waiting to building in the last update
+	record := func() {
+		t.Helper()
+		running := 0
+		for i, test := range m.testCases {
+			if dispatch := test.iterations[0].dispatchTime; !dispatch.IsZero() && dispatch != dispatchTimes[i] {
+				dispatches[i]++
+				dispatchTimes[i] = dispatch
+			}
+			if test.running {
+				running++
+			}
+		}
+		if running > m.maxThreads {
+			t.Fatalf("%d tests running with -T %d", running, m.maxThreads)
+		}
+	}
+
+	m = update(t, m, m.tryStartExecutors()())
+	record()
+	for round := 0; !m.isFinished(); round++ {
+		if round > 20 {
+			t.Fatal("the run doesn't finish")
+		}
+		// every building test fails at once, each resolution asking for a dispatch
+		var msgs []tea.Msg
+		for _, test := range m.testCases {
+			if test.state == TestStateBuilding {
+				msgs = append(msgs, testBuildErr{test.id, errMsg{err: errors.New("compile error")}})
+			}
+		}
+		for _, msg := range msgs {
+			m = update(t, m, msg)
+			record()
+		}
+		// the scheduled dispatch, delivered as often as it was asked for
+		for range len(msgs) {
+			m = update(t, m, scheduleMsg{})
+			record()
+		}
+	}
+
+	for i := range m.testCases {
+		if dispatches[i] != 1 {
+			t.Errorf("t%d was dispatched %d times, want 1", i, dispatches[i])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// the commit a run tested, recorded in reports and the history
+type gitInfo struct {
+	Commit string `json:"commit"`
+	Branch string `json:"branch,omitempty"`
+	Dirty  bool   `json:"dirty"`
+}
+
+func (g gitInfo) String() string {
+	str := g.Commit
+	if len(str) > 12 {
+		str = str[:12]
+	}
+	if g.Branch != "" && g.Branch != "HEAD" {
+		str += " on " + g.Branch
+	}
+	if g.Dirty {
+		str += " (dirty)"
+	}
+	return str
+}
+
+// git state of the repository containing dir, nil when git or the repository is missing
+func readGitInfo(dir string) *gitInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	git := func(args ...string) (string, error) {
+		out, err := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	commit, err := git("rev-parse", "HEAD")
+	if err != nil || commit == "" {
+		return nil
+	}
+	info := &gitInfo{Commit: commit}
+	info.Branch, _ = git("rev-parse", "--abbrev-ref", "HEAD")
+	// untracked files don't count, grunner's own artifacts would make every run after the first dirty
+	if status, err := git("status", "--porcelain", "--untracked-files=no"); err == nil {
+		info.Dirty = status != ""
+	}
+	return info
+}
+
+func (g *gitInfo) summary() string {
+	if g == nil {
+		return ""
+	}
+	return fmt.Sprintf("Tested commit %s.", g)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the history file is rotated to <path>.1 once it grows past this size
+const historyMaxBytes = 1 << 20
+
+type historyRecord struct {
+	Time time.Time `json:"time"`
+	// Makefile directory the run was in, so a shared history can be filtered per project
+	Dir   string        `json:"dir"`
+	Args  []string      `json:"args"`
+	Git   *gitInfo      `json:"git,omitempty"`
+	Tests []historyTest `json:"tests"`
+}
+
+type historyTest struct {
+	Name       string `json:"name"`
+	Result     string `json:"result"`
+	Passed     int    `json:"passed"`
+	Iterations int    `json:"iterations"`
+	// average iteration time in milliseconds
+	AverageMs int64 `json:"average_ms"`
+}
+
+// the configured project-local history, or ~/.local/share/grunner/history.jsonl
+func historyPath(makefileDir string, config projectConfig) (string, error) {
+	if config.History != "" {
+		if filepath.IsAbs(config.History) {
+			return config.History, nil
+		}
+		return filepath.Join(makefileDir, config.History), nil
+	}
+
+	dataDir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "grunner", "history.jsonl"), nil
+}
+
+// $XDG_DATA_HOME, or ~/.local/share
+func userDataDir() (string, error) {
+	if dataDir := os.Getenv("XDG_DATA_HOME"); dataDir != "" {
+		return dataDir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+func (m model) historyRecord(args []string) historyRecord {
+	dir, err := filepath.Abs(m.makefileDir)
+	if err != nil {
+		dir = m.makefileDir
+	}
+
+	record := historyRecord{Time: time.Now(), Dir: dir, Args: args, Git: m.git}
+	for _, testCase := range m.testCases {
+		record.Tests = append(record.Tests, historyTest{
+			Name:       testCase.name,
+			Result:     stateLabel(testCase),
+			Passed:     testCase.CountPassed(),
+			Iterations: len(testCase.iterations),
+			AverageMs:  testCase.AverageTime().Milliseconds(),
+		})
+	}
+	return record
+}
+
+// appends this run to the project's history
+func (m model) recordHistory(args []string) error {
+	path, err := historyPath(m.makefileDir, m.config)
+	if err != nil {
+		return err
+	}
+	return appendHistory(path, m.historyRecord(args))
+}
+
+/*
+ * Appends the record as a line of the history file, rotating the file first if it is too large.
+ * Callers should treat errors as warnings, the history is best-effort.
+ */
+func appendHistory(path string, record historyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(data)) > historyMaxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// records of the given project from the rotated and current history files, oldest first
+func readHistory(path string, dir string) ([]historyRecord, error) {
+	var records []historyRecord
+	for _, file := range []string{path + ".1", path} {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, historyMaxBytes)
+		for scanner.Scan() {
+			var record historyRecord
+			// skip lines from interrupted writes
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				continue
+			}
+			if record.Dir == dir {
+				records = append(records, record)
+			}
+		}
+		f.Close()
+	}
+	return records, nil
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// one block per run scaled by the pass rate, or a gap for runs that didn't include the test
+func sparkline(rates []float64) string {
+	var str strings.Builder
+	for _, rate := range rates {
+		if rate < 0 {
+			str.WriteRune(' ')
+			continue
+		}
+		str.WriteRune(sparkBlocks[int(rate*float64(len(sparkBlocks)-1)+0.5)])
+	}
+	return str.String()
+}
+
+// pass rate, average time and sparkline of each test over the last n recorded runs
+func trendReport(records []historyRecord, n int, only map[string]bool) string {
+	if len(records) > n {
+		records = records[len(records)-n:]
+	}
+	if len(records) == 0 {
+		return "No runs recorded yet."
+	}
+
+	var names []string
+	var longestName int
+	seen := make(map[string]bool)
+	for _, record := range records {
+		for _, test := range record.Tests {
+			if !seen[test.Name] && (only == nil || only[test.Name]) {
+				seen[test.Name] = true
+				names = append(names, test.Name)
+				longestName = max(longestName, len(test.Name))
+			}
+		}
+	}
+
+	var str strings.Builder
+	fmt.Fprintf(&str, "Trend over the last %d run(s), %s to %s:\n\n", len(records), records[0].Time.Format(time.DateTime), records[len(records)-1].Time.Format(time.DateTime))
+	for _, name := range names {
+		var passed, iterations int
+		var totalMs int64
+		var timed int
+		rates := make([]float64, len(records))
+		for i, record := range records {
+			rates[i] = -1
+			for _, test := range record.Tests {
+				if test.Name != name || test.Iterations == 0 {
+					continue
+				}
+				rates[i] = float64(test.Passed) / float64(test.Iterations)
+				passed += test.Passed
+				iterations += test.Iterations
+				if test.AverageMs > 0 {
+					totalMs += test.AverageMs
+					timed++
+				}
+			}
+		}
+
+		rate := "   -"
+		if iterations > 0 {
+			rate = fmt.Sprintf("%3.f%%", float64(passed)/float64(iterations)*100)
+		}
+		avg := "-"
+		if timed > 0 {
+			avg = (time.Duration(totalMs/int64(timed)) * time.Millisecond).String()
+		}
+		fmt.Fprintf(&str, "%*s  %s  %-10s %s\n", longestName, name, rate, avg, sparkline(rates))
+	}
+	return str.String()
+}
+
+// prints the trend of the project the given tests (or the working directory) belong to
+func runTrend(flags *argumentConfig) error {
+	dir := "."
+	var only map[string]bool
+	if len(flags.TestFiles) > 0 {
+		testFiles, err := findTestFiles(flags.TestFiles, flags.MaxDepth)
+		if err != nil && !(missingTestsOnly(err) && flags.IgnoreMissing) {
+			return err
+		}
+		if len(testFiles) == 0 {
+			return fmt.Errorf("no test files found")
+		}
+		dir = filepath.Dir(testFiles[0].filePath)
+		only = make(map[string]bool)
+		for _, testFile := range testFiles {
+			only[testFile.testName] = true
+		}
+	}
+
+	makefile, err := findMakefile(dir)
+	if err != nil {
+		return err
+	}
+	makefileDir, err := filepath.Abs(filepath.Dir(makefile))
+	if err != nil {
+		return err
+	}
+
+	config, err := loadProjectConfig(makefileDir)
+	if err != nil {
+		return err
+	}
+	path, err := historyPath(makefileDir, config)
+	if err != nil {
+		return err
+	}
+	records, err := readHistory(path, makefileDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(trendReport(records, flags.TrendRuns, only))
+	return nil
+}
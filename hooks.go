@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// the pre-hook of the project in dir succeeded, so its tests can start
+type preHookDone struct{ dir string }
+
+// how long the post-hook and each pre-test hook may take, apart from the build's own timeout
+const hookTimeout = time.Minute
+
+/*
+ * Runs a hook command through sh in the Makefile directory. Extra arguments are the shell's positional
+ * parameters, e.g. the test name as $1 for the pre-test hook; the command itself is passed as is, so
+ * comments, & and heredocs at its end keep working.
+ */
+func runHook(ctx context.Context, dir string, env []string, command string, args ...string) error {
+	var output bytes.Buffer
+	e := exec.CommandContext(ctx, "sh", append([]string{"-c", command, "grunner-hook"}, args...)...)
+	e.Dir = dir
+	e.Env = env
+	e.Stdout = &output
+	e.Stderr = &output
+	if err := e.Run(); err != nil {
+		return outputError{err: fmt.Errorf("hook %q failed: %w", command, err), output: output.Bytes()}
+	}
+	return nil
+}
+
+func preHookCmd(ctx context.Context, dir string, env []string, command string) tea.Cmd {
+	return func() tea.Msg {
+		if err := runHook(ctx, dir, env, command); err != nil {
+			// the output is shown with the error since the run is aborted
+			return dependencyErr{dir, fmt.Errorf("%w\n%s", err, err.(outputError).output)}
+		}
+		return preHookDone{dir}
+	}
+}
+
+// runs the post-hook of every project that has one once the run is over; failures are only reported
+func runPostHooks(m model) []error {
+	var errs []error
+	for _, dir := range m.makefileDirs() {
+		postHook := m.settings(dir).postHook
+		if postHook == "" {
+			continue
+		}
+		// the run's context is already cancelled by the time the TUI exits
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		if err := runHook(ctx, dir, m.env, postHook); err != nil {
+			errs = append(errs, err)
+		}
+		cancel()
+	}
+	return errs
+}
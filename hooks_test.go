@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunPostHooksPerProject(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a/.grunner.json": `{"post_hook": "touch post-hook-ran"}`,
+		"b/.grunner.json": `{"compare_cmd": "true"}`,
+		"c/":              "",
+	})
+	dirs := []string{filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c")}
+
+	tests := []struct {
+		name  string
+		flags argumentConfig
+		// the projects whose directory the hook ran in
+		ran []bool
+	}{
+		{"from each project's config", argumentConfig{}, []bool{true, false, false}},
+		{"--post-hook beats every config", argumentConfig{PostHook: "touch post-hook-ran"}, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, dir := range dirs {
+				_ = os.Remove(filepath.Join(dir, "post-hook-ran"))
+			}
+			m := newTestModel(len(dirs))
+			for i, dir := range dirs {
+				m.testCases[i].makefileDir = dir
+			}
+			var err error
+			if m.projects, err = loadProjectSettings(dirs, &tt.flags, accelTCG); err != nil {
+				t.Fatal(err)
+			}
+
+			if errs := runPostHooks(m); len(errs) > 0 {
+				t.Fatalf("runPostHooks() = %v", errs)
+			}
+			for i, dir := range dirs {
+				_, err := os.Stat(filepath.Join(dir, "post-hook-ran"))
+				if ran := err == nil; ran != tt.ran[i] {
+					t.Errorf("post-hook ran in %s = %v, want %v", filepath.Base(dir), ran, tt.ran[i])
+				}
+			}
+			if got := m.settings(dirs[1]).compareCmd; got != "true" {
+				t.Errorf("compare command of b = %q, want its own", got)
+			}
+			if got := m.settings(dirs[0]).compareCmd; got != "" {
+				t.Errorf("compare command of a = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestRunHookCommandText(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		command string
+	}{
+		{"plain", `echo "$1" > hook-arg`},
+		{"trailing comment", `echo "$1" > hook-arg # keeps the test name`},
+		{"trailing semicolon", `echo "$1" > hook-arg;`},
+		{"trailing ampersand", `echo "$1" > hook-arg; true &`},
+		{"heredoc", "cat > hook-arg <<EOF\n$1\nEOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Remove(filepath.Join(dir, "hook-arg"))
+			if err := runHook(context.Background(), dir, nil, tt.command, "t1"); err != nil {
+				t.Fatalf("runHook(%q) = %v", tt.command, err)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "hook-arg"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "t1\n" {
+				t.Errorf("runHook(%q) passed %q, want the test name", tt.command, got)
+			}
+		})
+	}
+}
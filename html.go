@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// diffs and outputs longer than this are cut in the HTML report
+const htmlExcerptLines = 500
+
+// an iteration's bar in the timing chart
+type htmlBar struct {
+	Label   string
+	Percent float64
+	Passed  bool
+}
+
+type htmlTest struct {
+	testReport
+	Bars    []htmlBar
+	Details template.HTML
+	// set on the first test of each --compare-config configuration
+	ConfigHeading string
+}
+
+// escapes the failure details, coloring diff lines and cutting long outputs with a note
+func detailsHTML(details string) template.HTML {
+	lines := strings.Split(strings.TrimRight(details, "\n"), "\n")
+	var note string
+	if len(lines) > htmlExcerptLines {
+		note = fmt.Sprintf("… %d more lines not shown, see the .diff/.raw file", len(lines)-htmlExcerptLines)
+		lines = lines[:htmlExcerptLines]
+	}
+
+	var str strings.Builder
+	for _, line := range lines {
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "<"), strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---"):
+			class = "del"
+		case strings.HasPrefix(line, ">"), strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++"):
+			class = "add"
+		case len(line) > 0 && line[0] >= '0' && line[0] <= '9', strings.HasPrefix(line, "@@"):
+			class = "info"
+		}
+		escaped := template.HTMLEscapeString(line)
+		if class != "" {
+			fmt.Fprintf(&str, "<span class=\"%s\">%s</span>\n", class, escaped)
+		} else {
+			str.WriteString(escaped + "\n")
+		}
+	}
+	if note != "" {
+		fmt.Fprintf(&str, "<span class=\"note\">%s</span>\n", template.HTMLEscapeString(note))
+	}
+	return template.HTML(str.String())
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>grunner results</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #ddd; }
+.passed { color: #1a7f37; } .failed, .blocked { color: #cf222e; } .other { color: #9a6700; }
+pre { background: #f6f8fa; padding: .8em; overflow-x: auto; font-size: .85em; }
+.del { color: #cf222e; } .add { color: #1a7f37; } .info { color: #0969da; } .note { color: #888; font-style: italic; }
+.chart { display: flex; flex-direction: column; gap: 2px; margin: .5em 0; }
+.bar { height: 1em; background: #1a7f37; color: #fff; font-size: .75em; padding-left: .3em; white-space: nowrap; }
+.bar.fail { background: #cf222e; }
+.notice { background: #fff8c5; padding: .5em .8em; }
+</style>
+</head>
+<body>
+<h1>grunner results</h1>
+{{if .Git}}<p>Commit <code>{{.Git.Commit}}</code>{{if and .Git.Branch (ne .Git.Branch "HEAD")}} on <code>{{.Git.Branch}}</code>{{end}}{{if .Git.Dirty}} with uncommitted changes{{end}}.</p>{{end}}
+{{if .QemuVersion}}<p>qemu {{.QemuVersion}}.</p>{{end}}
+{{if .Interrupted}}<p class="notice">The run was interrupted ({{.EndReason}}) before all tests finished, results are partial.</p>
+{{else if .BudgetExceeded}}<p class="notice">The time budget ran out before all tests finished, results are partial.</p>{{end}}
+<p><strong>{{.Passed}}/{{.Counted}}</strong> tests passed.{{if .HasPoints}} Score: <strong>{{.Score}}</strong>.{{end}}</p>
+{{if .WallTime}}<p>Took {{.WallTime}}, {{.IterationTime}} of iterations.</p>{{end}}
+<table>
+<tr><th>Test</th><th>State</th><th>Iterations passed</th><th>Average time</th>{{if .HasPoints}}<th>Points</th>{{end}}</tr>
+{{range .Tests}}{{if .ConfigHeading}}<tr><th colspan="{{if $.HasPoints}}5{{else}}4{{end}}">Configuration {{.ConfigHeading}}</th></tr>
+{{end}}<tr><td><a href="#{{.Name}}"><code>{{.Name}}</code></a></td><td class="{{.StateClass}}">{{.State}}{{if .Score}} {{.Score}}{{end}}</td><td>{{.Passed}}/{{.Iterations}}{{if .CappedFrom}} (capped, {{.CappedFrom}} requested){{end}}</td><td>{{.AverageTime}}</td>{{if $.HasPoints}}<td>{{.PointsText}}</td>{{end}}</tr>
+{{end}}</table>
+{{if .Comparisons}}<h2>Differences between configurations</h2>
+<table>
+<tr><th>Test</th>{{range .Configs}}<th>{{.}}</th>{{end}}</tr>
+{{range .Comparisons}}{{if .Differs}}<tr><td><code>{{.Test}}</code></td>{{range .States}}<td>{{.}}</td>{{end}}</tr>
+{{end}}{{end}}</table>{{end}}
+{{range .Tests}}
+<h2 id="{{.Name}}"><code>{{.Name}}</code> <span class="{{.StateClass}}">{{.State}}{{if .Score}} {{.Score}}{{end}}</span></h2>
+{{if .Bars}}<div class="chart">{{range .Bars}}<div class="bar{{if not .Passed}} fail{{end}}" style="width: {{printf "%.1f" .Percent}}%">{{.Label}}</div>{{end}}</div>{{end}}
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{if .Reasons}}<p>{{.Reasons}}</p>{{end}}
+{{if .Divergences}}<ul>{{range .Divergences}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Details}}<pre>{{.Details}}</pre>{{end}}
+{{if .Warnings}}<p>{{len .Warnings}} compiler warning(s)</p><pre class="warnings">{{range .Warnings}}{{.}}
+{{end}}</pre>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func (t htmlTest) StateClass() string {
+	switch t.State {
+	case "passed", "unexpectedly passed":
+		return "passed"
+	case "failed", "blocked":
+		return "failed"
+	default:
+		return "other"
+	}
+}
+
+func (t htmlTest) PointsText() string {
+	return formatPoints(t.Points) + "/" + formatPoints(t.MaxPoints)
+}
+
+// self-contained HTML report with a summary table, timing charts and colored diffs
+func (r runReport) html() (string, error) {
+	var longest time.Duration
+	for _, test := range r.Tests {
+		for _, d := range test.IterationTimes {
+			longest = max(longest, d)
+		}
+	}
+
+	var tests []htmlTest
+	for i, test := range r.Tests {
+		t := htmlTest{testReport: test}
+		if i == 0 || test.Config != r.Tests[i-1].Config {
+			t.ConfigHeading = test.Config
+		}
+		if test.Details != "" {
+			t.Details = detailsHTML(test.Details)
+		}
+		for i, d := range test.IterationTimes {
+			if d == 0 || longest == 0 {
+				continue
+			}
+			t.Bars = append(t.Bars, htmlBar{
+				Label:   fmt.Sprintf("#%d %s", i+1, d.Round(time.Millisecond)),
+				Percent: float64(d) / float64(longest) * 100,
+				Passed:  test.IterationPassed[i],
+			})
+		}
+		tests = append(tests, t)
+	}
+
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, struct {
+		runReport
+		Tests   []htmlTest
+		Counted int
+		Score   string
+	}{r, tests, len(r.Tests) - r.ExpectedFailures, formatPoints(r.Score) + "/" + formatPoints(r.MaxScore)})
+	return buf.String(), err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// other systems have no /proc/loadavg, --max-load is ignored there
+func loadAverage() (float64, error) {
+	return 0, errors.New("the load average isn't available on this system")
+}
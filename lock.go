@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const lockFileName = ".grunner.lock"
+
+// an advisory flock on .grunner.lock, held for the whole run
+type projectLock struct {
+	file *os.File
+}
+
+// pid and start time of the run holding a lock, as written in the lock file
+type lockHolder struct {
+	pid     int
+	started time.Time
+}
+
+func (h lockHolder) String() string {
+	if h.pid == 0 {
+		return "another grunner run"
+	}
+	return fmt.Sprintf("grunner (pid %d, started %s)", h.pid, h.started.Format(time.DateTime))
+}
+
+func readLockHolder(path string) lockHolder {
+	var holder lockHolder
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return holder
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) >= 1 {
+		holder.pid, _ = strconv.Atoi(fields[0])
+	}
+	if len(fields) >= 2 {
+		if unix, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			holder.started = time.Unix(unix, 0)
+		}
+	}
+	return holder
+}
+
+// whether the process is gone, in which case its lock is stale
+func processDead(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	return errors.Is(syscall.Kill(pid, 0), syscall.ESRCH)
+}
+
+/*
+ * Tries to take the lock of the project in dir without blocking. Returns the holder when it is taken
+ * by a live process; a lock left behind by a dead one is broken and retaken.
+ */
+func tryLockProject(dir string) (*projectLock, lockHolder, error) {
+	path := filepath.Join(dir, lockFileName)
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, lockHolder{}, fmt.Errorf("error creating %s: %w", path, err)
+		}
+
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			_ = f.Truncate(0)
+			_, _ = f.WriteAt([]byte(fmt.Sprintf("%d %d\n", os.Getpid(), time.Now().Unix())), 0)
+			return &projectLock{file: f}, lockHolder{}, nil
+		}
+		f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, lockHolder{}, fmt.Errorf("error locking %s: %w", path, err)
+		}
+
+		holder := readLockHolder(path)
+		if !processDead(holder.pid) {
+			return nil, holder, nil
+		}
+		// the holder is gone but the lock wasn't released (e.g. inherited by an orphaned child), start over on a new file
+		_ = os.Remove(path)
+	}
+	return nil, readLockHolder(path), nil
+}
+
+/*
+ * The Makefile directories a run will work in, found ahead of discovery so their locks are held before
+ * anything is written to them. Arguments that don't resolve are left for discovery to report. Sorted,
+ * so runs waiting on each other take the locks in the same order.
+ */
+func runProjectDirs(flags *argumentConfig) []string {
+	argSets := [][]string{flags.TestFiles}
+	if flags.Projects != "" {
+		projects, _ := listProjects(flags.Projects)
+		argSets = nil
+		for _, project := range projects {
+			argSets = append(argSets, projectArgs(project, flags.TestFiles))
+		}
+	}
+
+	var dirs []string
+	for _, args := range argSets {
+		testFiles, _ := findTestFiles(args, flags.MaxDepth)
+		for _, testFile := range testFiles {
+			makefile, err := findMakefile(filepath.Dir(testFile.filePath))
+			if dir := filepath.Dir(makefile); err == nil && !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+/*
+ * Locks every project directory, either failing when one is held by another run or, with wait,
+ * showing a spinner until it is released.
+ */
+func lockProjects(dirs []string, wait bool) ([]*projectLock, error) {
+	var locks []*projectLock
+	frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+	for _, dir := range dirs {
+		for frame := 0; ; frame++ {
+			lock, holder, err := tryLockProject(dir)
+			if err != nil {
+				unlockProjects(locks)
+				return nil, err
+			}
+			if lock != nil {
+				if frame > 0 {
+					fmt.Print("\r\x1b[K")
+				}
+				locks = append(locks, lock)
+				break
+			}
+			if !wait {
+				unlockProjects(locks)
+				return nil, fmt.Errorf("%s is already running in %s, wait for it to finish or pass --wait-lock", holder, dir)
+			}
+			fmt.Printf("\r\x1b[K%s %s", spinnerStyle.Render(frames[frame%len(frames)]), grayStyle.Render(fmt.Sprintf("waiting for %s to finish...", holder)))
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return locks, nil
+}
+
+func unlockProjects(locks []*projectLock) {
+	for _, lock := range locks {
+		_ = lock.file.Truncate(0)
+		_ = syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN)
+		_ = lock.file.Close()
+	}
+}
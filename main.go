@@ -1,17 +1,23 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"grunner/stopwatch"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/fred1268/go-clap/clap"
@@ -20,28 +26,117 @@ import (
 
 type errMsg struct{ err error }
 
-func (e errMsg) Error() string { return e.err.Error() }
+func (e errMsg) Error() string {
+	if e.err == nil {
+		return "unknown error"
+	}
+	return e.err.Error()
+}
 
 type model struct {
 	spinner      spinner.Model
 	smallSpinner spinner.Model
 	testCases    []testInfo
+	prereqs      []prereqInfo
+	// --max-duration deadline of the whole run, zero when unlimited
+	deadline   time.Time
+	budget     time.Duration
+	overBudget bool
+	// when the run started and, once it is over, ended; see elapsed
+	runStart time.Time
+	runEnd   time.Time
+	// --confirm: nothing starts until the user has checked the settings and pressed enter
+	awaitingConfirm bool
+	// dispatch order of the tests with --order slowest-first, nil for the default order
+	order []int
+	// --compare-config configurations, run one after the other, and the index of the current one
+	configs []buildConfig
+	phase   int
 
 	// settings
+	iterations       int
 	maxThreads       int
 	timeCap          time.Duration
 	iterationTimeout time.Duration
-	earlyExit        bool
-	verbose          bool
+	// scales iterationTimeout and the build timeouts, see timeouts.go
+	timeoutMultiplier float64
+	// derive each test's timeout from its first passing iteration
+	autoTimeout bool
+	// load average over which dispatching backs off to overloadedThreads, 0 when off
+	maxLoad float64
+	// how long qemu gets to exit after SIGINT before it is killed
+	killGrace time.Duration
+	// address space limit of each qemu in bytes, 0 for none
+	hostMemLimit uint64
+	// write the second serial port to <test>.debug.log
+	captureDebug bool
+	// write qemu's stderr of every iteration to <test>.iterN.stderr
+	captureStderr bool
+	// qemu accelerator, kvm, hvf, tcg or deterministic
+	accel         string
+	earlyExit     bool
+	minIterations int
+	verbose       bool
+	partialPoints bool
+	// score mismatches by the fraction of expected lines in the output
+	partial bool
+	// why the run ended early, see runReport.EndReason
+	endReason string
+	// minimum time between two test dispatches, and when the last one happened
+	stagger      time.Duration
+	lastDispatch time.Time
+	// a scheduleMsg is on its way, see tryStartExecutors
+	scheduled bool
+	// grading several projects at once, see --projects
+	batch    bool
+	keepOpen bool
+	notify   bool
+	// remove the generated files of tests that passed every iteration
+	cleanArtifacts bool
+	// --event-fd/--event-file progress events, nil when disabled
+	events *eventWriter
+	// commit of the project under test, nil outside a git repository
+	git *gitInfo
+	// create missing .ok files from the test's output
+	updateOk bool
+	// prefix .raw lines with the time since qemu started
+	timestamps bool
+	// environment of make and qemu, nil to inherit grunner's
+	env []string
 
 	makefileDir string
 	directory   string
+	// the first project's config, for what is shared by the whole run such as the history
+	config projectConfig
+	// by Makefile directory, see settings
+	projects map[string]projectSettings
+	// test weights from the points file, nil when there is none
+	points map[string]float64
+	// printed before the TUI starts, notes in gray
+	warnings []string
+	notes    []string
+	// scratch files of the run, see createRunTmpDir
+	tmpDir string
 
 	// tui data
-	window    struct{ width, height int }
-	quitting  bool
-	context   context.Context
-	cancelCtx context.CancelFunc
+	selected   int
+	showDetail bool
+	detail     viewport.Model
+	notice     string
+	// hide passed tests, toggled with f
+	failuresOnly bool
+	showHelp     bool
+	// render order of the tests, cycled with s
+	sortDisplay string
+	notified    bool
+	window      struct{ width, height int }
+	quitting    bool
+	// waiting on executors to finish writing after a quit, see waitForExecutors
+	cleaningUp bool
+	context    context.Context
+	cancelCtx  context.CancelFunc
+	// build and run goroutines still in flight
+	executors *sync.WaitGroup
 	err       error
 }
 
@@ -74,64 +169,302 @@ func initialModel(ctx context.Context, flags *argumentConfig) model {
 		spinner:      s,
 		smallSpinner: s2,
 
+		iterations:       flags.Iterations,
 		maxThreads:       flags.MaxThreads,
-		timeCap:          time.Duration(flags.TimeCap) * time.Second,
-		iterationTimeout: time.Duration(flags.Timeout) * time.Second,
+		timeCap:          durationFlag(flags.TimeCap),
+		iterationTimeout: durationFlag(flags.Timeout),
+		killGrace:        durationFlag(flags.KillGrace),
+		captureDebug:     flags.CaptureDebug,
+		hostMemLimit:     hostMemLimit(flags.HostMemLimit),
 		earlyExit:        flags.EarlyExit,
 		verbose:          flags.Verbose,
+		partialPoints:    flags.PartialPoints,
+		keepOpen:         flags.KeepOpen,
+		notify:           flags.Notify,
+		cleanArtifacts:   flags.CleanArtifacts,
+		timestamps:       flags.Timestamps,
+		updateOk:         flags.UpdateOk,
+		sortDisplay:      flags.SortDisplay,
 
 		context:   ctx,
 		cancelCtx: cancel,
+		executors: &sync.WaitGroup{},
 		window:    struct{ width, height int }{80, 24}, // set some defaults
 	}
 
-	testFiles, err := findTestFiles(flags.TestFiles)
+	model.minIterations = flags.MinIterations
+	model.captureStderr = flags.CaptureStderr
+	model.timeoutMultiplier = multiplierFlag(flags.TimeoutMultiplier)
+	model.iterationTimeout = model.scaleTimeout(model.iterationTimeout)
+	model.autoTimeout = flags.AutoTimeout
+	if !flags.Force {
+		model.maxLoad = flags.MaxLoad
+	}
+
+	// with --confirm the budget starts counting once the user confirms
+	model.budget = durationFlag(flags.MaxDuration)
+	model.awaitingConfirm = flags.Confirm
+	if !model.awaitingConfirm {
+		model.runStart = time.Now()
+		if model.budget > 0 {
+			model.deadline = model.runStart.Add(model.budget)
+		}
+	}
+
+	var err error
+	model.env, err = subprocessEnv(flags.CleanEnv, flags.Env)
 	if err != nil {
 		model.err = err
 		return model
 	}
-	if (len(testFiles)) == 0 {
-		model.err = fmt.Errorf("no test files found")
+
+	// --iterations-for beats a test's iterations= directive, which beats -n
+	iterationsFor, err := parseIterationsFor(flags.IterationsFor)
+	if err != nil {
+		model.err = err
 		return model
 	}
 
-	// assumption is that any tests to run would be within the same Makefile project
-	dir := filepath.Dir(testFiles[0].filePath)
-	makefile, err := findMakefile(dir)
+	model.configs, err = parseBuildConfigs(flags.CompareConfigs)
 	if err != nil {
 		model.err = err
 		return model
 	}
+	if len(model.configs) > 0 && flags.Projects != "" {
+		model.err = errors.New("--compare-config can't be combined with --projects")
+		return model
+	}
+
+	// in batch mode every subdirectory of --projects is graded as its own Makefile project
+	projects := []string{""}
+	if flags.Projects != "" {
+		projects, err = listProjects(flags.Projects)
+		if err != nil {
+			model.err = err
+			return model
+		}
+		model.batch = true
+	}
 
 	var testCases []testInfo
-	var longestName int
-	for i, testFile := range testFiles {
-		if len(testFile.testName) > longestName {
-			longestName = len(testFile.testName)
+	var longestName, longestTags int
+	for _, project := range projects {
+		args := flags.TestFiles
+		if project != "" {
+			args = projectArgs(project, flags.TestFiles)
 		}
 
-		tIterations := make([]testIteration, flags.Iterations)
-		for i := range tIterations {
-			tIterations[i] = testIteration{passed: false, timeSpanned: 0}
+		testFiles, directives, err := discoverTests(args, flags)
+		if missingTestsOnly(err) && (flags.IgnoreMissing || model.batch) {
+			model.warnings = append(model.warnings, fmt.Sprintf("WARNING: %s.", err))
+		} else if missingTestsOnly(err) {
+			model.err = fmt.Errorf("%w (pass --ignore-missing to run the other tests anyway)", err)
+			return model
+		} else if err != nil {
+			model.err = err
+			return model
+		}
+		if flags.Changed && len(testFiles) > 0 {
+			var note string
+			testFiles, note, err = filterChanged(testFiles, flags.ChangedWindow)
+			if err != nil {
+				model.err = err
+				return model
+			}
+			if len(testFiles) == 0 && !model.batch {
+				model.err = errors.New(note)
+				return model
+			}
+			model.notes = append(model.notes, note)
+		}
+		if (len(testFiles)) == 0 {
+			if !model.batch {
+				model.err = fmt.Errorf("no test files found")
+				return model
+			}
+			model.warnings = append(model.warnings, fmt.Sprintf("WARNING: no test files found in %s, skipping it.", project))
+			continue
 		}
 
-		testCases = append(testCases, testInfo{
-			id:         i,
-			name:       testFile.testName,
-			filePath:   testFile.filePath,
-			resolved:   false,
-			running:    false,
-			state:      TestStateWaiting,
-			iterations: tIterations,
-			stopwatch:  stopwatch.NewWithInterval(time.Millisecond * 31),
-		})
+		// each test builds with its nearest Makefile, so tests from several projects can run together
+		makefileDirs, err := findMakefileDirs(testFiles)
+		if err != nil {
+			if !model.batch {
+				model.err = err
+				return model
+			}
+			model.warnings = append(model.warnings, fmt.Sprintf("WARNING: %s in %s, skipping it.", err, project))
+			continue
+		}
+
+		if model.makefileDir == "" {
+			model.makefileDir = makefileDirs[0]
+			model.directory = filepath.Dir(testFiles[0].filePath)
+		}
+
+		var projectName string
+		if project != "" {
+			projectName = filepath.Base(project)
+		}
+		for i, testFile := range testFiles {
+			makefileDir := makefileDirs[i]
+			longestTags = max(longestTags, len(formatTags(directives[testFile.testName].tags)))
+
+			numIterations := cmp.Or(iterationsFor[testFile.testName], directives[testFile.testName].iterations, flags.Iterations)
+			tIterations := make([]testIteration, numIterations)
+			for i := range tIterations {
+				tIterations[i] = testIteration{passed: false, timeSpanned: 0}
+			}
+
+			testCases = append(testCases, testInfo{
+				id:          len(testCases),
+				name:        testFile.testName,
+				filePath:    testFile.filePath,
+				project:     projectName,
+				makefileDir: makefileDir,
+				artifactDir: artifactDir(flags.OutDir, projectName, makefileDir, testFile.testName),
+				baseName:    testFile.baseName,
+				isDir:       testFile.isDir,
+				tags:        directives[testFile.testName].tags,
+				xfail:       directives[testFile.testName].xfail,
+				requires:    directives[testFile.testName].requires,
+				exclusive:   directives[testFile.testName].exclusive,
+				resolved:    false,
+				running:     false,
+				state:       TestStateWaiting,
+				iterations:  tIterations,
+				stopwatch:   stopwatch.NewWithInterval(time.Millisecond * 31),
+			})
+		}
+	}
+	if len(testCases) == 0 {
+		model.err = fmt.Errorf("no test files found in any project under %s", flags.Projects)
+		return model
 	}
 
-	testStyle = lipgloss.NewStyle().Width(longestName).Align(lipgloss.Right)
+	for name := range iterationsFor {
+		if !slices.ContainsFunc(testCases, func(t testInfo) bool { return t.name == name }) {
+			model.warnings = append(model.warnings, fmt.Sprintf("WARNING: --iterations-for names %s, which isn't one of the tests.", name))
+		}
+	}
 
+	if err := checkNameCollisions(testCases); err != nil {
+		model.err = err
+		return model
+	}
 	model.testCases = testCases
-	model.makefileDir = filepath.Dir(makefile)
-	model.directory = dir
+
+	// tests from several Makefiles are prefixed with their project, which also tells apart tests sharing a name
+	if !model.batch && len(model.makefileDirs()) > 1 {
+		for i := range model.testCases {
+			test := &model.testCases[i]
+			test.name = groupLabel(test.makefileDir) + "/" + test.baseName
+		}
+	}
+	if len(model.configs) > 0 {
+		model.testCases = expandConfigs(model.testCases, model.configs, flags.OutDir)
+		model.warnings = append(model.warnings, cleanRuleWarnings(model.makefileDirs())...)
+	}
+	for _, testCase := range model.testCases {
+		longestName = max(longestName, len(testCase.name))
+	}
+
+	if model.batch {
+		longestName = 0
+		for _, project := range projects {
+			longestName = max(longestName, len(filepath.Base(project)))
+		}
+	}
+	testStyle = lipgloss.NewStyle().Width(longestName).Align(lipgloss.Right)
+	tagStyle = lipgloss.NewStyle().Width(longestTags)
+
+	for _, testCase := range model.testCases {
+		if err := os.MkdirAll(testCase.artifactDir, 0755); err != nil {
+			model.err = fmt.Errorf("error creating output directory: %w", err)
+			return model
+		}
+	}
+
+	accel := probeAccel(flags.NoAccel)
+	if flags.Deterministic {
+		accel = accelDeterministic
+	}
+	model.projects, err = loadProjectSettings(model.makefileDirs(), flags, accel)
+	if err != nil {
+		model.err = err
+		return model
+	}
+	model.config = model.settings(model.makefileDir).config
+	model.accel = model.settings(model.makefileDir).accel
+	model.partial = flags.Partial
+	model.stagger = durationFlag(flags.Stagger)
+	// --fixture targets and the config's fixtures, by Makefile directory
+	fixtures := make(map[string][]string)
+	for i := range model.testCases {
+		test := &model.testCases[i]
+		// per-test settings come from the config next to each test's own Makefile
+		config := model.settings(test.makefileDir).config
+		test.target = buildTarget(test.baseName, test.isDir, config)
+		test.okFile = expectedOutputFile(test.filePath, test.baseName, test.isDir, config)
+		if err := embedExpectedOutput(test); err != nil {
+			model.err = err
+			return model
+		}
+		if slices.Contains(config.Xfail, test.baseName) {
+			test.xfail = true
+		}
+		for _, target := range config.Requires[test.baseName] {
+			if !slices.Contains(test.requires, target) {
+				test.requires = append(test.requires, target)
+			}
+		}
+		// fixtures come first, so they are also the first rows
+		if _, ok := fixtures[test.makefileDir]; !ok {
+			var dirFixtures []string
+			for _, target := range append(slices.Clone(flags.Fixtures), config.Fixtures...) {
+				if !slices.Contains(dirFixtures, target) {
+					dirFixtures = append(dirFixtures, target)
+				}
+			}
+			fixtures[test.makefileDir] = dirFixtures
+		}
+		others := slices.DeleteFunc(test.requires, func(target string) bool { return slices.Contains(fixtures[test.makefileDir], target) })
+		test.requires = append(slices.Clone(fixtures[test.makefileDir]), others...)
+	}
+	model.prereqs = collectPrereqs(model.testCases, fixtures)
+	model.git = readGitInfo(model.makefileDir)
+	model.events, err = openEventWriter(flags.EventFd, flags.EventFile)
+	if err != nil {
+		model.err = err
+		return model
+	}
+	if flags.Order == orderSlowestFirst {
+		model.order = slowestFirstOrder(model.testCases, historicalDurations(model))
+	} else if flags.Order == orderFile {
+		listed, _ := readTestLists(flags.TestLists)
+		model.order = listOrder(model.testCases, listed, flags.MaxDepth)
+	}
+	for _, prereq := range model.prereqs {
+		if !model.batch && len(prereq.target) > testStyle.GetWidth() {
+			testStyle = testStyle.Width(len(prereq.target))
+		}
+	}
+
+	points, pointsFile, err := loadPoints(model.directory, model.makefileDir)
+	if err != nil {
+		model.err = err
+		return model
+	}
+	model.points = points
+	if points != nil {
+		warned := make(map[string]bool)
+		for _, testCase := range model.testCases {
+			if _, ok := points[testCase.baseName]; !ok && !warned[testCase.baseName] {
+				warned[testCase.baseName] = true
+				model.warnings = append(model.warnings, fmt.Sprintf("WARNING: %s is missing from %s, counting it as 1 point.", testCase.baseName, pointsFile))
+			}
+		}
+	}
 
 	if len(testCases) == 1 {
 		model.verbose = true
@@ -141,7 +474,30 @@ func initialModel(ctx context.Context, flags *argumentConfig) model {
 }
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(m.spinner.Tick, m.smallSpinner.Tick, makeDependencies(m.context, m.makefileDir))
+	ticks := tea.Batch(m.spinner.Tick, m.smallSpinner.Tick)
+	if m.awaitingConfirm {
+		return ticks
+	}
+	return tea.Batch(ticks, m.start())
+}
+
+// starts the run: the budget, and the prerequisites of every Makefile directory
+func (m model) start() tea.Cmd {
+	m.events.runStarted(m)
+	var cmds []tea.Cmd
+	if !m.deadline.IsZero() {
+		cmds = append(cmds, tea.Tick(time.Until(m.deadline), func(time.Time) tea.Msg {
+			return budgetExceededMsg{}
+		}))
+	}
+	for _, dir := range m.makefileDirs() {
+		if len(m.configs) > 0 {
+			cmds = append(cmds, m.cleanAndMakeDependencies(dir))
+			continue
+		}
+		cmds = append(cmds, makeDependencies(m.context, dir, m.env, m.scaleTimeout(buildTimeout)))
+	}
+	return tea.Batch(cmds...)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -150,112 +506,349 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds []tea.Cmd
 	)
 
-	defer sentry.RecoverWithContext(m.context)
-
 	resolveTestCase := func(test *testInfo) {
 		test.resolved = true
 		test.running = false
+		test.stopwatch = test.stopwatch.StopNow()
 
 		test.iterations = test.iterations[:test.currIter+1]
 
-		cmds = append(cmds, tryStartExecutors(m))
+		cmds = append(cmds, m.tryStartExecutors())
+	}
+
+	// results of work killed when the budget ran out are stale
+	if m.overBudget {
+		switch msg.(type) {
+		case dependencyErr, startBuildingTests, prereqBuildErr, prereqBuildSuccess,
+			testBuildErr, testBuildSuccess, testRunError, testRunSuccess:
+			return m, nil
+		}
 	}
 
 	switch msg := msg.(type) {
+	case budgetExceededMsg:
+		m.endReason = "time budget exceeded"
+		cmds = append(cmds, m.exceedBudget())
+	case signalMsg:
+		if m.cleaningUp {
+			// a second signal doesn't wait for the executors any longer
+			return m, tea.Quit
+		}
+		m.endReason = fmt.Sprintf("stopped by signal (%s)", msg.signal)
+		m.quitting = true
+		m.cancelCtx()
+		m.stopStopwatches()
+		m.cleaningUp = true
+		return m, waitForExecutors(m.executors)
 	case tea.KeyMsg:
+		if m.awaitingConfirm && m.err == nil {
+			switch msg.String() {
+			case "enter":
+				m.awaitingConfirm = false
+				m.runStart = time.Now()
+				if m.budget > 0 {
+					m.deadline = m.runStart.Add(m.budget)
+				}
+				return m, m.start()
+			case "q", "esc", "ctrl+c":
+				m.endReason = "quit by the user before starting"
+				m.quitting = true
+				m.cancelCtx()
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+
+		if m.showDetail {
+			switch msg.String() {
+			case "q", "esc":
+				m.showDetail = false
+				return m, nil
+			case "ctrl+c":
+				m.endReason = "quit by the user"
+				m.quitting = true
+				m.cancelCtx()
+				m.stopStopwatches()
+				m.cleaningUp = true
+				return m, waitForExecutors(m.executors)
+			}
+			m.detail, cmd = m.detail.Update(msg)
+			return m, cmd
+		}
+
+		m.notice = ""
+		if m.showHelp && msg.String() != "ctrl+c" {
+			// any key dismisses the help overlay
+			m.showHelp = false
+			return m, nil
+		}
+
 		switch msg.String() {
+		case "?":
+			m.showHelp = true
+			return m, nil
 		case "q", "esc", "ctrl+c":
+			m.endReason = "quit by the user"
 			m.quitting = true
 			m.cancelCtx()
-			return m, delayCmd(time.Millisecond, tea.Quit)
+			m.stopStopwatches()
+			m.cleaningUp = true
+			return m, waitForExecutors(m.executors)
+		case "o":
+			if m.keepOpen && !m.batch && len(m.testCases) > 0 {
+				cmd, m.notice = openInPager(m)
+			}
+			return m, cmd
+		case "v":
+			m.verbose = !m.verbose
+			return m, nil
+		case "f":
+			m.failuresOnly = !m.failuresOnly
+			// e.g. --changed with nothing changed leaves no test to select
+			if len(m.testCases) == 0 {
+				return m, nil
+			}
+			if !m.isVisible(m.testCases[m.selected]) {
+				m.moveSelection(1)
+			}
+			if !m.isVisible(m.testCases[m.selected]) {
+				m.moveSelection(-1)
+			}
+			return m, nil
+		case "s":
+			m.sortDisplay = nextSortDisplay(m.sortDisplay)
+			return m, nil
+		case "up", "k":
+			if m.keepOpen && !m.batch {
+				m.moveSelection(-1)
+			}
+			return m, nil
+		case "down", "j":
+			if m.keepOpen && !m.batch {
+				m.moveSelection(1)
+			}
+			return m, nil
+		case "enter":
+			if m.keepOpen && !m.batch {
+				m.openDetail()
+			}
+			return m, nil
 		default:
 			return m, nil
 		}
 
+	case executorsDoneMsg:
+		m.cleaningUp = false
+		// out of budget, the run ends below like a finished one, staying open with --keep-open
+		if m.overBudget && !m.quitting && m.err == nil {
+			break
+		}
+		return m, tea.Quit
+
+	case pagerClosedMsg:
+		if msg.err != nil {
+			m.notice = fmt.Sprintf("pager failed: %s", msg.err)
+		}
+		return m, nil
+
 	case errMsg:
 		if m.err == nil {
 			m.err = msg.err
 		}
 		m.cancelCtx()
-		return m, delayCmd(time.Millisecond, tea.Quit)
+		m.stopStopwatches()
+		return m, waitForExecutors(m.executors)
+
+	case dependencyErr:
+		if !m.batch && len(m.configs) == 0 {
+			if m.err == nil {
+				m.err = msg.err
+				m.endReason = "aborted: " + strings.SplitN(msg.err.Error(), "\n", 2)[0]
+			}
+			m.cancelCtx()
+			m.stopStopwatches()
+			return m, waitForExecutors(m.executors)
+		}
+		// only this project's tests fail in batch mode, or the current configuration's with --compare-config
+		for i := range m.testCases {
+			if test := &m.testCases[i]; test.makefileDir == msg.dir && test.config == m.phase {
+				test.state = TestStateCompileFailure
+				test.err = msg.err
+				resolveTestCase(test)
+			}
+		}
 
 	case startBuildingTests:
-		cmds = append(cmds, tryStartExecutors(m))
-	case buildTestMsg:
-		for _, testId := range msg {
-			test := &m.testCases[testId]
-			test.state = TestStateBuilding
-			test.running = true
-			cmds = append(cmds, buildTestCase(m.context, m.makefileDir, *test))
+		if preHook := m.settings(msg.dir).preHook; preHook != "" {
+			cmds = append(cmds, preHookCmd(m.context, msg.dir, m.env, preHook))
+			break
+		}
+		cmds = append(cmds, m.startProject(msg.dir)...)
+	case preHookDone:
+		cmds = append(cmds, m.startProject(msg.dir)...)
+	case prereqBuildSuccess:
+		m.prereqs[msg].state = TestStateSuccess
+		if m.prereqs[msg].fixture {
+			// the prerequisites held back by the fixtures
+			cmds = append(cmds, m.startPrereqs(m.prereqs[msg].dir)...)
 		}
+		cmds = append(cmds, m.tryStartExecutors())
+	case prereqBuildErr:
+		prereq := &m.prereqs[msg.int]
+		prereq.state = TestStateCompileFailure
+		prereq.err = outputError{err: msg.err, output: msg.output}
+		if prereq.fixture {
+			// every test needs it, so this fails like the kernel build
+			err := fmt.Errorf("fixture %s failed to build: %w\n%s", prereq.target, msg.err, msg.output)
+			dir := prereq.dir
+			cmds = append(cmds, func() tea.Msg { return dependencyErr{dir, err} })
+			break
+		}
+		// dependents are blocked rather than failing on a missing target
+		for i := range m.testCases {
+			if test := &m.testCases[i]; test.state == TestStateWaiting && test.config == m.phase && test.requiresPrereq(*prereq) {
+				test.state = TestStateBlocked
+				test.err = outputError{err: fmt.Errorf("blocked: prerequisite %s failed to build", prereq.target), output: msg.output}
+				resolveTestCase(test)
+			}
+		}
+	case staggerDoneMsg:
+		cmds = append(cmds, m.tryStartExecutors())
+	case scheduleMsg:
+		m.scheduled = false
+		if m.overBudget {
+			break
+		}
+		cmds = append(cmds, m.dispatch(m.testsToStart())...)
 	case testBuildErr:
+		// only the build of a dispatched test, once
+		if m.testCases[msg.int].resolved || m.testCases[msg.int].state != TestStateBuilding {
+			return m, nil
+		}
+		m.testCases[msg.int].iterations[0].buildTime = time.Since(m.testCases[msg.int].iterations[0].dispatchTime)
 		m.testCases[msg.int].state = TestStateCompileFailure
+		if errors.As(msg.err, new(setupError)) {
+			m.testCases[msg.int].state = TestStateSetupError
+		}
 		resolveTestCase(&m.testCases[msg.int])
 		m.testCases[msg.int].err = msg.err
 	case testBuildSuccess:
-		test := &m.testCases[msg]
+		test := &m.testCases[msg.int]
+		if test.resolved || test.state != TestStateBuilding {
+			return m, nil
+		}
+		test.boot = msg.boot
+		test.buildWarnings = msg.warnings
 		test.state = TestStateRunning
+		m.startRunSpan(test)
+		m.events.emit(event{Event: "test-running", Test: test.name})
 		test.iterations[test.currIter].startTime = time.Now()
+		test.iterations[test.currIter].buildTime = test.iterations[test.currIter].startTime.Sub(test.iterations[test.currIter].dispatchTime)
 		cmds = append(cmds, test.stopwatch.Start())
-		cmds = append(cmds, runTestCase(&m, *test))
+		cmds = append(cmds, m.track(runTestCase(&m, *test)))
 
 	case testRunError:
 		test := &m.testCases[msg.int]
+		if !test.awaitsResult(msg.iteration) {
+			return m, nil
+		}
 		test.iterations[test.currIter].passed = false
+		var diffErr diffError
+		if errors.As(msg.err, &diffErr) {
+			test.iterations[test.currIter].score = diffErr.score
+			test.iterations[test.currIter].divergence = firstDivergence(diffErr.diff)
+		}
 		test.state = TestStateFailure
 		// update timers
 		currTime := time.Now()
 		test.iterations[test.currIter].timeSpanned = timeDiff(test.iterations[test.currIter].startTime, currTime)
 		cmds = append(cmds, test.stopwatch.Stop())
+		m.events.iterationFinished(*test, false, msg.err)
 
-		test.err = msg.err
-		if m.earlyExit || test.currIter == len(test.iterations)-1 || (m.timeCap > 0 && test.TimeElapsed() > m.timeCap) {
-			// all iterations have been run
+		test.iterations[test.currIter].err = msg.err
+		// the most common failure rather than the latest, see reasons.go
+		test.err = test.commonFailure()
+		if m.captureDebug {
+			test.debugTail = readDebugTail(debugLogPath(*test))
+		}
+		var missingOk missingOkError
+		if errors.As(msg.err, &missingOk) {
+			// every iteration would fail the same way
+			test.state = TestStateNoExpected
+			resolveTestCase(test)
+		} else if errors.As(msg.err, new(missingCodeError)) {
+			// the stub is hit on every iteration too
+			test.state = TestStateUnimplemented
+			resolveTestCase(test)
+		} else if (m.earlyExit && test.currIter+1 >= m.minIterations) || test.currIter == len(test.iterations)-1 || m.timeCapReached(test) {
+			// all iterations have been run, or enough of them to stop at a failure
 			resolveTestCase(test)
 		} else {
 			// run the next iteration
 			test.currIter++
 			test.iterations[test.currIter].startTime = time.Now()
-			cmds = append(cmds, runTestCase(&m, *test))
+			cmds = append(cmds, m.track(runTestCase(&m, *test)))
 		}
 	case testRunSuccess:
-		test := &m.testCases[msg]
+		test := &m.testCases[msg.int]
+		if !test.awaitsResult(msg.iteration) {
+			return m, nil
+		}
 		test.iterations[test.currIter].passed = true
+		test.iterations[test.currIter].score = 1
+		test.warnings = msg.warnings
+		test.comparison = msg.comparison
 		// update timers
 		currTime := time.Now()
 		test.iterations[test.currIter].timeSpanned = timeDiff(test.iterations[test.currIter].startTime, currTime)
 		cmds = append(cmds, test.stopwatch.Stop())
+		m.events.iterationFinished(*test, true, nil)
+		if m.autoTimeout && test.autoTimeout == 0 {
+			test.autoTimeout = autoTimeout(test.iterations[test.currIter].timeSpanned)
+		}
 
-		if test.currIter == len(test.iterations)-1 || (m.timeCap > 0 && test.TimeElapsed() > m.timeCap) {
+		if test.currIter == len(test.iterations)-1 || m.timeCapReached(test) {
 			// all iterations have been run
 			resolveTestCase(test)
 			if test.state != TestStateFailure {
 				test.state = TestStateSuccess
+				if m.cleanArtifacts {
+					removeArtifacts(*test)
+				}
 			}
 		} else {
 			// run the next iteration
 			test.currIter++
 			test.iterations[test.currIter].startTime = time.Now()
-			cmds = append(cmds, runTestCase(&m, *test))
+			cmds = append(cmds, m.track(runTestCase(&m, *test)))
 		}
 	case stopwatch.StartStopMsg:
+		// a resolved test's stopwatch was stopped for good, a late start or stop can't change its time
 		for i := range m.testCases {
-			testCase := &m.testCases[i]
-			testCase.stopwatch, cmd = testCase.stopwatch.Update(msg)
-			if cmd != nil {
-				cmds = append(cmds, cmd)
+			if testCase := &m.testCases[i]; !testCase.resolved {
+				testCase.stopwatch, cmd = testCase.stopwatch.Update(msg)
+				if cmd != nil {
+					cmds = append(cmds, cmd)
+				}
 			}
 		}
 	case stopwatch.TickMsg:
 		for i := range m.testCases {
 			testCase := &m.testCases[i]
 			if testCase.stopwatch.ID() == msg.ID {
-				testCase.stopwatch, cmd = testCase.stopwatch.Update(msg)
-				cmds = append(cmds, cmd)
+				// not ticking again ends the tick loop of a resolved test
+				if !testCase.resolved {
+					testCase.stopwatch, cmd = testCase.stopwatch.Update(msg)
+					cmds = append(cmds, cmd)
+				}
 				break
 			}
 		}
 	case spinner.TickMsg:
+		// nothing spins once every test is resolved
+		if m.isFinished() {
+			break
+		}
 		m.spinner, cmd = m.spinner.Update(msg)
 		cmds = append(cmds, cmd)
 		m.smallSpinner, cmd = m.smallSpinner.Update(msg)
@@ -263,18 +856,28 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.window.width = msg.Width
 		m.window.height = msg.Height
+		m.detail.Width = msg.Width
+		m.detail.Height = max(msg.Height-4, 1)
 	}
 
-	// check if any test cases are still running
-	shouldExit := true
-	for _, testCase := range m.testCases {
-		if !testCase.resolved {
-			shouldExit = false
-			break
-		}
+	m.finishTestSpans(false)
+	cmds = append(cmds, m.nextConfig()...)
+
+	// not before the executors killed by the budget are reaped
+	shouldExit := m.isFinished() && !m.cleaningUp
+	if shouldExit && m.runEnd.IsZero() {
+		m.runEnd = time.Now()
 	}
 
-	if shouldExit {
+	m.events.sync(m, shouldExit)
+
+	if shouldExit && m.notify && !m.notified {
+		m.notified = true
+		cmds = append(cmds, notifyCmd(m))
+	}
+
+	// in keep-open mode the results stay on screen until the user quits
+	if shouldExit && !m.keepOpen {
 		m.cancelCtx()
 		cmds = append(cmds, delayCmd(time.Millisecond, tea.Quit))
 	}
@@ -282,92 +885,203 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
-func (m model) View() string {
-	if m.err != nil {
-		return errorStyle.Render("Error: " + m.err.Error() + "\n")
+// whether a test's row is shown under the current display filter
+func (m model) isVisible(t testInfo) bool {
+	return !m.failuresOnly || t.state != TestStateSuccess
+}
+
+// moves the cursor to the next visible test in display order, staying put if there is none
+func (m *model) moveSelection(delta int) {
+	order := m.displayOrder()
+	pos := slices.Index(order, m.selected)
+	for i := pos + delta; i >= 0 && i < len(order); i += delta {
+		if m.isVisible(m.testCases[order[i]]) {
+			m.selected = order[i]
+			return
+		}
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func (m model) footerView(hidden int) string {
+	filter := "f failures only: " + onOff(m.failuresOnly)
+	if m.failuresOnly {
+		filter += fmt.Sprintf(" (%d passed hidden)", hidden)
+	}
+	return helpStyle.Render("v verbose: "+onOff(m.verbose)+" · "+filter+" · ? help") + "\n"
+}
+
+// stops the stopwatches of tests that are still running, e.g. on quit, so none keeps counting, and the run's clock
+func (m *model) stopStopwatches() {
+	for i := range m.testCases {
+		m.testCases[i].stopwatch = m.testCases[i].stopwatch.StopNow()
+	}
+	if m.runEnd.IsZero() {
+		m.runEnd = time.Now()
 	}
+}
 
-	defer sentry.RecoverWithContext(m.context)
+// how long the run has been going, frozen once it's over; zero until it starts
+func (m model) elapsed() time.Duration {
+	if m.runStart.IsZero() {
+		return 0
+	}
+	if m.runEnd.IsZero() {
+		return time.Since(m.runStart)
+	}
+	return m.runEnd.Sub(m.runStart)
+}
 
-	var isFinished bool
+// whether every test has its final result, i.e. none is waiting, building or running
+func (m model) isFinished() bool {
 	for _, testCase := range m.testCases {
 		if !testCase.resolved {
-			isFinished = false
-			break
+			return false
 		}
-		isFinished = true
 	}
+	return true
+}
 
+func (m model) View() string {
+	if m.err != nil {
+		return errorStyle.Render("Error: " + m.err.Error() + "\n")
+	}
+
+	if m.awaitingConfirm {
+		return m.confirmView()
+	}
+
+	if m.showDetail {
+		return m.detailView()
+	}
+
+	isFinished := m.isFinished()
 	isResolved := isFinished || m.quitting
 
 	var str string
-	if m.quitting {
+	if m.cleaningUp {
+		str += titleStyle.Render("Cleaning up...")
+	} else if m.quitting {
 		str += titleStyle.Render("Terminated")
+	} else if m.overBudget {
+		str += titleStyle.Render("Out of time")
 	} else if isFinished {
 		str += titleStyle.Render("Finished!")
 	} else {
 		str += titleStyle.Render("Running tests...")
 	}
 
-	var passed int
-	var compiled int
-	for _, testCase := range m.testCases {
-		if testCase.state == TestStateSuccess {
-			passed++
-		}
-		if testCase.state != TestStateCompileFailure {
-			compiled++
-		}
-	}
-
 	var titleSpinStr string
 
 	if !isResolved {
 		titleSpinStr = m.smallSpinner.View()
 	}
 
-	str = lipgloss.JoinHorizontal(lipgloss.Center, str, fmt.Sprintf("  %d/%d test cases passed. %s", passed, compiled, titleSpinStr))
+	var scoreStr string
+	if m.points != nil {
+		earned, total := m.score()
+		scoreStr = fmt.Sprintf(" · score: %s/%s", formatPoints(earned), formatPoints(total))
+	}
+	// redrawn on every spinner tick
+	if elapsed := m.elapsed(); elapsed > 0 {
+		scoreStr += fmt.Sprintf(" · elapsed %s", elapsed.Round(time.Second))
+	}
+
+	// the counts give way on narrow terminals so the header stays on one line
+	var summaryWidth int
+	if m.window.width > 0 {
+		summaryWidth = max(m.window.width-lipgloss.Width(str)-lipgloss.Width(scoreStr)-lipgloss.Width(titleSpinStr)-3, 1)
+	}
+	summary := m.summaryView(isResolved, summaryWidth)
+
+	str = lipgloss.JoinHorizontal(lipgloss.Center, str, fmt.Sprintf("  %s%s %s", summary, scoreStr, titleSpinStr))
+
+	// what was resolved, so a wrong Makefile shows before its build errors do
+	str += "\n" + grayStyle.Render(m.settingsLine(m.window.width))
 
 	str += "\n\n"
 
 	var testLines []string
-	for _, testCase := range m.testCases {
-		testLines = append(testLines, testCase.View(m))
+	var multiline bool
+	var hidden int
+	if m.batch {
+		testLines = m.projectRows()
+	} else {
+		for _, prereq := range m.prereqs {
+			testLines = append(testLines, prereq.View(m))
+		}
+		for _, i := range m.displayOrder() {
+			testCase := m.testCases[i]
+			if !m.isVisible(testCase) {
+				hidden++
+				continue
+			}
+			line := testCase.View(m)
+			if m.keepOpen {
+				if i == m.selected {
+					line = selectedStyle.Render("›") + " " + line
+				} else {
+					line = "  " + line
+				}
+			}
+			multiline = multiline || strings.Count(line, "\n") > 1
+			testLines = append(testLines, line)
+		}
 	}
 
 	testStr := strings.Join(testLines, "")
 
-	yPadding := 5
-	columnWidth := 38
+	// the final frame only needs the footer when rows are hidden
+	showFooter := (!isResolved || m.keepOpen || m.failuresOnly) && !m.quitting
+
+	yPadding := 6
 
-	if m.verbose {
-		columnWidth += 2
+	if showFooter {
+		yPadding += 2
+	}
+	if m.keepOpen {
+		yPadding += 2
 	}
 
-	if height := len(testLines); height > m.window.height-yPadding {
+	// rows with diff previews don't fit the column layout, so fall back to a single column
+	if height := len(testLines); height > m.window.height-yPadding && !multiline {
 		maxLines := m.window.height - yPadding
 
 		if maxLines <= 0 {
 			return str
 		}
 
-		columns := (len(testLines) + maxLines - 1) / maxLines
-		columnLines := (len(testLines) + 1) / columns
-		// split the test cases into columns
-		columnsStrMatrix := make([][]string, columns)
-		for i := 0; i < columns; i++ {
-			columnsStrMatrix[i] = testLines[i*columnLines : min((i+1)*columnLines, len(testLines)-1)]
+		str += columnsView(testLines, maxLines, m.window.width)
+	} else {
+		str += testStr
+	}
+
+	if showFooter {
+		str += "\n" + m.footerView(hidden)
+	}
+
+	if isFinished && m.keepOpen && !m.quitting {
+		str += "\n"
+		for _, line := range m.wallTimeSummary() {
+			str += grayStyle.Render(line) + "\n"
 		}
+	}
 
-		// join the columns
-		var columnStrings []string
-		for _, column := range columnsStrMatrix {
-			columnStrings = append(columnStrings, lipgloss.NewStyle().Width(columnWidth).Render(strings.Join(column, "")))
+	if m.keepOpen && !m.quitting {
+		str += helpStyle.Render("↑/↓ select · enter details · o open in pager · q quit") + "\n"
+		if m.notice != "" {
+			str += errorStyle.Render(m.notice) + "\n"
 		}
+	}
 
-		str += lipgloss.JoinHorizontal(lipgloss.Top, columnStrings...)
-	} else {
-		str += testStr
+	if m.showHelp && !m.quitting {
+		str = overlay(str, m.helpView(), m.window.width, m.window.height)
 	}
 
 	if m.quitting || isFinished {
@@ -377,19 +1091,101 @@ func (m model) View() string {
 }
 
 type argumentConfig struct {
-	Iterations int      `clap:"--iterations,-n"`
-	MaxThreads int      `clap:"--threads,-T"`
-	EarlyExit  bool     `clap:"--earlyexit,-e"`
-	TimeCap    float64  `clap:"--timecap,-c"`
-	Timeout    int      `clap:"--timeout,-t"`
-	ShowHelp   bool     `clap:"--help,-h"`
-	Verbose    bool     `clap:"--verbose,-v"`
-	TestFiles  []string `clap:"trailing"`
+	Iterations        int      `clap:"--iterations,-n"`
+	IterationsFor     string   `clap:"--iterations-for"`
+	MaxThreads        int      `clap:"--threads,-T"`
+	EarlyExit         bool     `clap:"--earlyexit,-e"`
+	MinIterations     int      `clap:"--min-iterations"`
+	TimeCap           string   `clap:"--timecap,-c"`
+	Timeout           string   `clap:"--timeout,-t"`
+	TimeoutMultiplier string   `clap:"--timeout-multiplier"`
+	AutoTimeout       bool     `clap:"--auto-timeout"`
+	MaxLoad           float64  `clap:"--max-load"`
+	Force             bool     `clap:"--force"`
+	ShowHelp          bool     `clap:"--help,-h"`
+	Verbose           bool     `clap:"--verbose,-v"`
+	Debug             string   `clap:"--debug"`
+	OkWildcards       bool     `clap:"--ok-wildcards"`
+	CompareCmd        string   `clap:"--compare-cmd"`
+	Partial           bool     `clap:"--partial"`
+	Stagger           string   `clap:"--stagger"`
+	Confirm           bool     `clap:"--confirm"`
+	KeepOpen          bool     `clap:"--keep-open,-k"`
+	SortDisplay       string   `clap:"--sort-display"`
+	Notify            bool     `clap:"--notify"`
+	Markdown          string   `clap:"--markdown"`
+	PartialPoints     bool     `clap:"--partial-points"`
+	Projects          string   `clap:"--projects"`
+	Matrix            string   `clap:"--matrix"`
+	Trend             bool     `clap:"--trend"`
+	TrendRuns         int      `clap:"--trend-runs"`
+	Baseline          string   `clap:"--baseline"`
+	Tags              string   `clap:"--tags"`
+	SkipTags          string   `clap:"--skip-tags"`
+	List              bool     `clap:"--list"`
+	Order             string   `clap:"--order"`
+	MaxDuration       string   `clap:"--max-duration"`
+	WaitLock          bool     `clap:"--wait-lock"`
+	OutDir            string   `clap:"--out-dir"`
+	KeepTmp           bool     `clap:"--keep-tmp"`
+	CleanArtifacts    bool     `clap:"--clean-artifacts"`
+	CleanEnv          bool     `clap:"--clean-env"`
+	PreHook           string   `clap:"--pre-hook"`
+	PostHook          string   `clap:"--post-hook"`
+	PreTestHook       string   `clap:"--pre-test-hook"`
+	Timestamps        bool     `clap:"--timestamps"`
+	EventFd           int      `clap:"--event-fd"`
+	EventFile         string   `clap:"--event-file"`
+	HTML              string   `clap:"--html"`
+	Timeline          string   `clap:"--timeline"`
+	UpdateOk          bool     `clap:"--update-ok"`
+	MaxDepth          int      `clap:"--max-depth"`
+	Ext               string   `clap:"--ext"`
+	IgnoreMissing     bool     `clap:"--ignore-missing"`
+	KillGrace         string   `clap:"--kill-grace"`
+	NoAccel           bool     `clap:"--no-accel"`
+	Arch              string   `clap:"--arch"`
+	Deterministic     bool     `clap:"--deterministic"`
+	CaptureDebug      bool     `clap:"--capture-debug"`
+	CaptureStderr     bool     `clap:"--capture-stderr"`
+	HostMemLimit      int      `clap:"--host-mem-limit"`
+	MetricsEndpoint   string   `clap:"--metrics-endpoint"`
+	MetricsFile       string   `clap:"--metrics-file"`
+	MetricsPlainNames bool     `clap:"--metrics-plain-names"`
+	WarningsAsErrors  bool     `clap:"--warnings-as-errors"`
+	FailPattern       string   `clap:"--fail-pattern"`
+	Offline           bool     `clap:"--offline"`
+	ShowConfig        bool     `clap:"--show-config"`
+	TestFiles         []string `clap:"trailing"`
+	// collected before parsing, since they can be repeated
+	Env       []string
+	TestLists []string
+	Fixtures  []string
+	// LABEL:VARS, see parseBuildConfigs
+	CompareConfigs []string
+	// --changed and its window, empty for since the last run
+	Changed       bool
+	ChangedWindow string
 }
 
-func main() {
-	exitCode := 0
-	defer func() { os.Exit(exitCode) }()
+/*
+ * Whether the run must not touch the network: --offline, which is looked for before parsing as Sentry
+ * starts first, or SENTRY_DSN set to nothing, the usual way of turning Sentry off.
+ */
+func offlineMode(args []string) bool {
+	if dsn, ok := os.LookupEnv("SENTRY_DSN"); ok && dsn == "" {
+		return true
+	}
+	return slices.Contains(args, "--offline")
+}
+
+const sentryDSN = "https://b84a1ffbb51adf6e332cbca2922b2362@o4507745204895744.ingest.us.sentry.io/4508022556131328"
+
+// starts reporting to Sentry at dsn unless the run is offline; without a client every sentry call is a no-op
+func initSentry(offline bool, dsn string) error {
+	if offline {
+		return nil
+	}
 	var sentryEnvironment string
 	var sampleRate float64
 	if IsEdge {
@@ -399,15 +1195,21 @@ func main() {
 		sampleRate = 0.1
 		sentryEnvironment = "production"
 	}
-
-	err := sentry.Init(sentry.ClientOptions{
-		Dsn:              "https://b84a1ffbb51adf6e332cbca2922b2362@o4507745204895744.ingest.us.sentry.io/4508022556131328",
+	return sentry.Init(sentry.ClientOptions{
+		Dsn:              dsn,
 		EnableTracing:    true,
 		Release:          Version,
 		AttachStacktrace: true,
 		TracesSampleRate: sampleRate,
 		Environment:      sentryEnvironment,
 	})
+}
+
+func main() {
+	exitCode := 0
+	defer func() { os.Exit(exitCode) }()
+	offline := offlineMode(os.Args)
+	err := initSentry(offline, sentryDSN)
 	if err != nil {
 		log.Fatalf("sentry.Init: %s", err)
 	}
@@ -421,44 +1223,176 @@ func main() {
 	defer sentry.Flush(2 * time.Second)
 
 	flags := &argumentConfig{
-		Iterations: 1,
-		EarlyExit:  false, // todo: figure out if a boolean flag can be set to false with clap
-		MaxThreads: runtime.NumCPU() / 4,
-		TimeCap:    -1,
-		Timeout:    10,
-		Verbose:    IsEdge,
+		Iterations:  1,
+		EarlyExit:   false, // todo: figure out if a boolean flag can be set to false with clap
+		MaxThreads:  max(runtime.NumCPU()/4, 1),
+		TimeCap:     "-1",
+		Timeout:     "10",
+		KillGrace:   defaultKillGrace,
+		Verbose:     IsEdge,
+		SortDisplay: sortByName,
+		TrendRuns:   10,
+		MaxDepth:    defaultMaxDepth,
 	}
 
 	var results *clap.Results
-	if results, err = clap.Parse(os.Args, flags); err != nil {
-		fmt.Println(errorStyle.Render("Invalid arguments: " + err.Error()))
-		printHelp()
-		exitCode = 1
+	args, envs := extractRepeatedFlag(splitFlagValues(os.Args), "--env")
+	flags.Env = envs
+	args, flags.TestLists = extractRepeatedFlag(args, "--tests-file", "-f")
+	args, flags.Fixtures = extractRepeatedFlag(args, "--fixture")
+	args, flags.CompareConfigs = extractRepeatedFlag(args, "--compare-config")
+	args, flags.ChangedWindow, flags.Changed = extractChangedFlag(args)
+	if results, err = clap.Parse(args, flags); err != nil {
+		fmt.Fprintln(os.Stderr, errorStyle.Render("Invalid arguments: "+err.Error()))
+		printHelp(os.Stderr)
+		exitCode = exitUsage
 		return
 	}
+	flags.Offline = offline
+
+	// without any flags the parser treats the program name as the first trailing argument
+	if len(flags.TestFiles) > 0 && flags.TestFiles[0] == os.Args[0] {
+		flags.TestFiles = flags.TestFiles[1:]
+	}
 
-	if len(os.Args) == 1 || flags.ShowHelp {
-		printHelp()
+	// only an explicit --help is a success, a bare grunner is a usage error
+	if flags.ShowHelp {
+		printHelp(os.Stdout)
+		return
+	} else if len(os.Args) == 1 {
+		printHelp(os.Stderr)
+		exitCode = exitUsage
 		return
 	}
 
 	if len(results.Ignored) > 1 {
 		ignored := results.Ignored[1:]
 		if len(ignored) == 1 {
-			fmt.Println(errorStyle.Render("Unknown argument: " + ignored[0]))
+			fmt.Fprintln(os.Stderr, errorStyle.Render("Unknown argument: "+ignored[0]))
 		} else {
-			fmt.Println(errorStyle.Render("Unknown arguments: " + strings.Join(results.Ignored[1:], ", ")))
+			fmt.Fprintln(os.Stderr, errorStyle.Render("Unknown arguments: "+strings.Join(results.Ignored[1:], ", ")))
 		}
-		printHelp()
-		exitCode = 1
+		for _, arg := range ignored {
+			if suggestion, ok := suggestFlag(arg); ok {
+				fmt.Fprintln(os.Stderr, errorStyle.Render(fmt.Sprintf("Did you mean %s instead of %s?", suggestion, arg)))
+			}
+		}
+		printHelp(os.Stderr)
+		exitCode = exitUsage
 		return
 	}
 
-	if flags.TestFiles == nil {
-		fmt.Println(errorStyle.Render("No test directory(s) or file(s) given to run."))
+	// the flag beats the environment, like everywhere else
+	if flags.TimeoutMultiplier == "" {
+		flags.TimeoutMultiplier = os.Getenv(timeoutMultiplierEnv)
+	}
+
+	// the listed tests are run, and their Makefile found, as if they were given as arguments
+	listed, err := readTestLists(flags.TestLists)
+	if err != nil {
+		fmt.Println(errorStyle.Render("Error: " + err.Error()))
 		exitCode = 1
 		return
 	}
+	flags.TestFiles = append(flags.TestFiles, listed...)
+
+	// `# grunner: key=value` lines of the Makefile, below the command line and .grunner.json
+	makefile, _ := argsMakefile(flags.TestFiles)
+	var argsConfig projectConfig
+	var sources settingSources
+	if makefile != "" {
+		argsConfig, _ = loadProjectConfig(filepath.Dir(makefile))
+		defaults, warnings, err := readMakefileDefaults(makefile)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("WARNING: failed to read the settings of %s: %v.", makefile, err))
+		}
+		var applyWarnings []string
+		sources, applyWarnings = applyMakefileDefaults(flags, args, makefile, defaults, argsConfig)
+		for _, warning := range append(warnings, applyWarnings...) {
+			fmt.Fprintln(os.Stderr, errorStyle.Render(warning))
+		}
+	}
+	if flags.ShowConfig {
+		showConfig(os.Stdout, flags, args, makefile, sources, argsConfig)
+		return
+	}
+	if violations := validateFlags(flags); len(violations) > 0 {
+		for _, violation := range violations {
+			fmt.Fprintln(os.Stderr, errorStyle.Render("Invalid arguments: "+violation+"."))
+		}
+		exitCode = exitUsage
+		return
+	}
+
+	if err := selectArch(cmp.Or(flags.Arch, argsConfig.Arch, defaultArch)); err != nil {
+		fmt.Fprintln(os.Stderr, errorStyle.Render("Invalid arguments: "+err.Error()+"."))
+		exitCode = exitUsage
+		return
+	}
+
+	exts := splitList(flags.Ext)
+	if len(exts) == 0 {
+		exts = argsConfig.Extensions
+	}
+	if len(exts) == 0 {
+		exts = defaultTestExts
+	}
+	if err := setTestExtensions(exts); err != nil {
+		fmt.Fprintln(os.Stderr, errorStyle.Render("Invalid arguments: "+err.Error()+"."))
+		exitCode = exitUsage
+		return
+	}
+
+	if flags.Debug != "" {
+		if err := runDebugSession(flags); err != nil {
+			fmt.Println(errorStyle.Render("Error: " + err.Error()))
+			exitCode = 1
+		}
+		return
+	}
+
+	if flags.List {
+		if err := runList(flags); err != nil {
+			fmt.Println(errorStyle.Render("Error: " + err.Error()))
+			exitCode = 1
+		}
+		return
+	}
+
+	if flags.Trend {
+		if err := runTrend(flags); err != nil {
+			fmt.Println(errorStyle.Render("Error: " + err.Error()))
+			exitCode = 1
+		}
+		return
+	}
+
+	// `grunner clean [tests...]`, unless there is an actual test path named clean
+	if len(flags.TestFiles) > 0 && flags.TestFiles[0] == "clean" {
+		if _, err := os.Stat("clean"); err != nil {
+			if err := runClean(flags, flags.TestFiles[1:]); err != nil {
+				fmt.Println(errorStyle.Render("Error: " + err.Error()))
+				exitCode = 1
+			}
+			return
+		}
+	}
+
+	if len(flags.TestFiles) == 0 {
+		fmt.Fprintln(os.Stderr, errorStyle.Render("No test directory(s) or file(s) given to run."))
+		exitCode = exitUsage
+		return
+	}
+
+	if flags.MaxLoad > 0 && !flags.Force {
+		if load, err := loadAverage(); err != nil {
+			fmt.Println(errorStyle.Render("WARNING: --max-load is ignored, " + err.Error() + "."))
+		} else if load > flags.MaxLoad {
+			fmt.Println(errorStyle.Render(fmt.Sprintf("The machine is overloaded: the 1-minute load average is %.2f, over --max-load %g. Try again later, or pass --force to run anyway.", load, flags.MaxLoad)))
+			exitCode = 1
+			return
+		}
+	}
 
 	if flags.MaxThreads > runtime.NumCPU()/4 {
 		userCount, err := countOtherUsers()
@@ -472,9 +1406,6 @@ func main() {
 				fmt.Println(errorStyle.Render(fmt.Sprintf("WARNING: May incur high CPU usage, be mindful of the %d other user%s on the system.", userCount, pluralUsers)))
 			}
 		}
-	} else if flags.MaxThreads < 1 {
-		fmt.Println(errorStyle.Render("Invalid number of threads."))
-		return
 	}
 
 	options := []sentry.SpanOption{
@@ -488,28 +1419,271 @@ func main() {
 	transaction := sentry.StartSpan(context.Background(), "run", options...)
 	defer transaction.Finish()
 
-	model := initialModel(transaction.Context(), flags)
+	// argument construction adapts to known incompatibilities of older and newer qemus
+	qemuVersion = detectQemuVersion()
+	sentry.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag("qemu.version", qemuVersion.String())
+	})
 
-	p := tea.NewProgram(model)
+	// concurrent runs in the same project would overwrite each other's artifacts and race make; taken
+	// before discovery, which already creates --out-dir folders and writes embedded expected outputs
+	locks, err := lockProjects(runProjectDirs(flags), flags.WaitLock)
+	if err != nil {
+		fmt.Println(errorStyle.Render("Error: " + err.Error()))
+		exitCode = 1
+		return
+	}
+	defer unlockProjects(locks)
 
-	if _, err := p.Run(); err != nil {
+	initial := initialModel(transaction.Context(), flags)
+	for _, note := range qemuVersion.compatNotes() {
+		initial.warnings = append(initial.warnings, "WARNING: "+note+".")
+	}
+	if initial.err == nil {
+		initial.tmpDir, err = createRunTmpDir(flags.OutDir)
+		if err != nil {
+			fmt.Println(errorStyle.Render("Error: failed to create a temporary directory: " + err.Error()))
+			exitCode = 1
+			return
+		}
+		defer removeRunTmpDir(initial.tmpDir, flags.KeepTmp)
+	}
+	if initial.err == nil && slices.ContainsFunc(initial.makefileDirs(), makefileBuildsData) {
+		if err := probeDataDrive(initial.tmpDir); err != nil {
+			initial.warnings = append(initial.warnings, "WARNING: "+err.Error()+".")
+		}
+	}
+	if warning := initial.timeCapWarning(); warning != "" {
+		initial.warnings = append(initial.warnings, warning)
+	}
+	for _, warning := range initial.warnings {
+		fmt.Println(errorStyle.Render(warning))
+	}
+	for _, note := range initial.notes {
+		fmt.Println(grayStyle.Render(note))
+	}
+	if flags.Verbose {
+		// KVM runs are much faster, which matters when comparing timings
+		fmt.Println(grayStyle.Render("accel: " + initial.accel))
+		fmt.Println(grayStyle.Render("qemu: " + qemuVersion.String()))
+		fmt.Println(grayStyle.Render("run: " + initial.settingsLine(0)))
+		if initial.stagger > 0 {
+			// explains why the tests start one by one
+			fmt.Println(grayStyle.Render(fmt.Sprintf("stagger: tests are dispatched %s apart", initial.stagger)))
+		}
+		for _, line := range envDiff(os.Environ(), initial.env) {
+			fmt.Println(grayStyle.Render("env: " + line))
+		}
+	}
+
+	// panics are caught by the guard rather than bubbletea, so they are reported and exit non-zero
+	guard := &crashGuard{last: &initial}
+	guard.program = tea.NewProgram(guardedModel{initial, guard}, tea.WithoutCatchPanics(), tea.WithoutSignalHandler())
+	stopSignals := forwardSignals(guard.program)
+
+	// the last state the event loop got to, reported on when the program didn't end normally
+	writeLastReports := func(reason string) {
+		if guard.last == nil {
+			return
+		}
+		last := *guard.last
+		last.endReason = reason
+		for _, err := range writeReports(last.report(), flags.HTML, flags.Markdown) {
+			fmt.Println(errorStyle.Render("Failed to write " + err.Error()))
+		}
+		if flags.Timeline != "" {
+			if err := writeTimeline(flags.Timeline, last.timeline()); err != nil {
+				fmt.Println(errorStyle.Render("Failed to write timeline: " + err.Error()))
+			}
+		}
+	}
+
+	start := time.Now()
+	finalModel, err := guard.run()
+	stopSignals()
+	if errors.Is(err, errCrashed) {
+		guard.report()
+		writeLastReports(fmt.Sprintf("crashed: %v", guard.value))
+		exitCode = exitCrashed
+		return
+	} else if err != nil {
 		fmt.Println(errorStyle.Render(err.Error()))
 		sentry.CaptureException(err)
+		writeLastReports("error: " + err.Error())
 		exitCode = 1
 		return
 	}
+	if initial.err != nil {
+		exitCode = 1
+	}
+
+	if final, ok := finalModel.(guardedModel); ok && len(final.testCases) > 0 {
+		m := final.model
+		// an early quit skips the end of Update, so the run is finished here
+		m.finishTestSpans(true)
+		m.events.sync(m, true)
+		m.events.Close()
+		// written first, so a slow post-hook or metrics endpoint can't leave CI without them
+		for _, err := range writeReports(m.report(), flags.HTML, flags.Markdown) {
+			fmt.Println(errorStyle.Render("Failed to write " + err.Error()))
+			exitCode = 1
+		}
+		if flags.Timeline != "" {
+			if err := writeTimeline(flags.Timeline, m.timeline()); err != nil {
+				fmt.Println(errorStyle.Render("Failed to write timeline: " + err.Error()))
+				exitCode = 1
+			}
+		}
+		for _, err := range runPostHooks(m) {
+			fmt.Println(errorStyle.Render("WARNING: post-hook failed: " + err.Error()))
+		}
+		exitCode = cmp.Or(m.resultExitCode(), exitCode)
+		// sent in the background while the rest of the results are written
+		waitMetrics := func() string { return "" }
+		endpoint, metricsFile := metricsDestination(flags, m.config)
+		if endpoint != "" || metricsFile != "" {
+			payload := m.metricsPayload(time.Since(start), flags.MetricsPlainNames || m.config.MetricsPlainNames)
+			waitMetrics = sendMetrics(payload, endpoint, metricsFile)
+		}
+		if m.batch {
+			matrixPath := flags.Matrix
+			if matrixPath == "" {
+				matrixPath = "grunner-matrix.csv"
+			}
+			if err := writeMatrix(matrixPath, m); err != nil {
+				fmt.Println(errorStyle.Render("Failed to write results matrix: " + err.Error()))
+				exitCode = 1
+			} else {
+				fmt.Println(grayStyle.Render("Results matrix written to " + matrixPath))
+			}
+		}
+		if summary := m.git.summary(); summary != "" {
+			fmt.Println(grayStyle.Render(summary))
+		}
+		for _, line := range m.wallTimeSummary() {
+			fmt.Println(line)
+		}
+		if summary := m.timeSummary(); summary != "" {
+			fmt.Print(summary)
+		}
+		if flags.Timeline != "" && !m.batch {
+			fmt.Print(grayStyle.Render(timelineView(m.timeline())))
+		}
+		if flags.Order != "" && !m.report().Interrupted {
+			makespan, lowerBound := m.makespan()
+			fmt.Println(grayStyle.Render(fmt.Sprintf("Makespan %s, lower bound %s with %d thread(s).", makespan.Round(time.Millisecond), lowerBound.Round(time.Millisecond), m.maxThreads)))
+		}
+		// only complete runs are compared and recorded, an early quit would skew the pass rates
+		if len(m.configs) > 0 {
+			fmt.Print(m.configComparisonView())
+		}
+		if !m.batch && len(m.configs) == 0 && !m.report().Interrupted {
+			if flags.Baseline != "" {
+				if baseline, err := loadBaseline(flags.Baseline); err != nil {
+					fmt.Println(errorStyle.Render("WARNING: " + err.Error()))
+				} else {
+					fmt.Print(changesView(baseline, m.historyRecord(nil), "baseline"))
+				}
+			} else if previous, ok := m.previousRun(); ok {
+				fmt.Print(changesView(previous, m.historyRecord(nil), "last run"))
+			}
+			if err := m.recordHistory(os.Args[1:]); err != nil {
+				fmt.Println(errorStyle.Render("WARNING: failed to record run history: " + err.Error()))
+			}
+		}
+		if note := waitMetrics(); note != "" {
+			fmt.Println(errorStyle.Render(note))
+		}
+	}
 }
 
-func printHelp() {
-	fmt.Println("Usage: grunner [options] [... test files/directories]")
-	fmt.Println("Runs test files in the given directories or files. Multiple directories or files can be given.")
-	fmt.Println("\nOptions:")
-	fmt.Println("  -h, --help             show this help message")
-	fmt.Println("  -n, --iterations int   number of iterations to execute (default 1)")
-	fmt.Println("  -T, --threads int      maximum number of concurrent threads to use (default CPUThreads/4)")
-	fmt.Println("  -e, --earlyexit        exit iterating early if a test fails")
-	fmt.Println("  -t, --timeout int      max time an iteration will run until being killed (default 10)")
-	fmt.Println("  -c, --timecap float    cap total execution time to n seconds (useful with -n) (default unlimited)")
-	fmt.Println("  -v, --verbose          show error information for test failures")
-	fmt.Printf("(gRunner version %s)\n", strings.TrimSpace(Version))
+func printHelp(w io.Writer) {
+	fmt.Fprintln(w, "Usage: grunner [options] [... test files/directories]")
+	fmt.Fprintln(w, "       grunner clean [... test files/directories]")
+	fmt.Fprintln(w, "Runs test files in the given directories or files. Multiple directories or files can be given.")
+	fmt.Fprintln(w, "Exits with 5 when a test fails or an xfail test passes, 3 when --max-duration runs out and 2 on invalid arguments.")
+	fmt.Fprintln(w, "\nOptions:")
+	fmt.Fprintln(w, "  -h, --help             show this help message")
+	fmt.Fprintln(w, "  -n, --iterations int   number of iterations to execute (default 1)")
+	fmt.Fprintln(w, "      --iterations-for t8=20,t9=20")
+	fmt.Fprintln(w, "                         iterations of specific tests, overriding -n and // grunner: iterations=n")
+	fmt.Fprintln(w, "  -T, --threads int      maximum number of concurrent threads to use (default CPUThreads/4)")
+	fmt.Fprintln(w, "  -e, --earlyexit        exit iterating early if a test fails")
+	fmt.Fprintln(w, "      --min-iterations k with --earlyexit, run at least k iterations before stopping at a failure")
+	fmt.Fprintln(w, "  -t, --timeout d        max time an iteration will run until being killed, in seconds (1.5) or as a duration (1500ms) (default 10)")
+	fmt.Fprintln(w, "      --timeout-multiplier x")
+	fmt.Fprintln(w, "                         scale the iteration and build timeouts by x, for slow machines (default $GRUNNER_TIMEOUT_MULTIPLIER or 1)")
+	fmt.Fprintln(w, "      --max-load n       refuse to start when the 1-minute load average is over n, and back off to 2 threads")
+	fmt.Fprintln(w, "                         when it gets there during the run (Linux only)")
+	fmt.Fprintln(w, "      --force            run even when the machine is over --max-load")
+	fmt.Fprintln(w, "      --auto-timeout     time out later iterations of a test at 3x its first passing one (at least 2s)")
+	fmt.Fprintln(w, "      --confirm          show the resolved Makefile, tests and settings and wait for enter before starting")
+	fmt.Fprintln(w, "      --stagger d        wait d (e.g. 500ms or 0.5) between test dispatches so qemus don't start all at once")
+	fmt.Fprintln(w, "  -c, --timecap d        cap total execution time to d, e.g. 90 or 1m30s (useful with -n) (default unlimited)")
+	fmt.Fprintln(w, "      --arch a           qemu architecture: x86_64, riscv64 or aarch64 (default x86_64)")
+	fmt.Fprintln(w, "      --no-accel         run qemu with TCG even if KVM or HVF is available")
+	fmt.Fprintln(w, "      --deterministic    count guest instructions (-icount) so timing-dependent output is reproducible;")
+	fmt.Fprintln(w, "                         wall-clock timings then no longer reflect performance (not with --auto-timeout")
+	fmt.Fprintln(w, "                         or --stagger)")
+	fmt.Fprintf(w, "      --host-mem-limit n memory limit of each qemu in MB, -1 for none (default %d, the guest's plus overhead)\n", hostMemLimit(0)>>20)
+	fmt.Fprintln(w, "      --kill-grace d     time qemu gets to exit after SIGINT before it is killed (default 2)")
+	fmt.Fprintln(w, "      --max-depth int    levels of subdirectories searched for tests (default 3)")
+	fmt.Fprintln(w, "      --max-duration d   stop the whole run after d (e.g. 12m or 720), exiting with code 3")
+	fmt.Fprintln(w, "      --wait-lock        wait for another run in the same project to finish instead of exiting")
+	fmt.Fprintln(w, "      --out-dir path     write .raw/.out/.diff files to path/<test>/ (default next to the Makefile)")
+	fmt.Fprintln(w, "      --keep-tmp         keep the run's temporary files (QMP sockets, normalized .ok files) and print where")
+	fmt.Fprintln(w, "      --clean-artifacts  remove the generated files of tests that pass every iteration")
+	fmt.Fprintln(w, "      --env KEY=VALUE    set a variable for make and qemu (repeatable)")
+	fmt.Fprintln(w, "      --clean-env        run make and qemu with only PATH, HOME, QEMU_SMP and --env variables")
+	fmt.Fprintln(w, "      --fixture target   make target built once before any test, e.g. fs.img (repeatable)")
+	fmt.Fprintln(w, "      --pre-hook cmd     run cmd in the Makefile directory before any test (aborts on failure)")
+	fmt.Fprintln(w, "      --post-hook cmd    run cmd in the Makefile directory after the run, even on early quit")
+	fmt.Fprintln(w, "      --pre-test-hook c  run c with the test name as $1 before each test is built")
+	fmt.Fprintln(w, "      --warnings-as-errors")
+	fmt.Fprintln(w, "                         fail the build of tests that compile with warnings")
+	fmt.Fprintln(w, "      --fail-pattern re  fail tests whose *** output lines match re even if the diff passes (default fail)")
+	fmt.Fprintln(w, "      --capture-debug    write the second serial port (COM2) to <test>.debug.log")
+	fmt.Fprintln(w, "      --capture-stderr   write qemu's stderr of every iteration to <test>.iterN.stderr")
+	fmt.Fprintln(w, "      --timestamps       prefix .raw lines with the seconds since qemu started")
+	fmt.Fprintln(w, "      --event-fd n       write newline-delimited JSON progress events to file descriptor n")
+	fmt.Fprintln(w, "      --event-file path  write newline-delimited JSON progress events to path")
+	fmt.Fprintln(w, "      --timeline path    write when each test built and ran as a Chrome trace (chrome://tracing, Perfetto)")
+	fmt.Fprintln(w, "      --html path        write a self-contained HTML report with diffs and timing charts")
+	fmt.Fprintln(w, "      --update-ok        create missing .ok files from the output of the test")
+	fmt.Fprintln(w, "      --ext .c,.S        extensions of test files (default .cc,.dir)")
+	fmt.Fprintln(w, "      --ignore-missing   warn instead of failing when an argument matches no test")
+	fmt.Fprintln(w, "      --offline          no network access: no crash reports, metrics are only written to the metrics file")
+	fmt.Fprintln(w, "      --metrics-endpoint url")
+	fmt.Fprintln(w, "                         post an anonymous summary of the run (outcomes, durations) to url")
+	fmt.Fprintln(w, "      --metrics-file f   append the same summary as a line of f, for staff to collect offline")
+	fmt.Fprintln(w, "      --metrics-plain-names")
+	fmt.Fprintln(w, "                         send test names in the summary as is instead of hashed")
+	fmt.Fprintln(w, "  -v, --verbose          show error information for test failures")
+	fmt.Fprintln(w, "  -k, --keep-open        keep the results open to browse diffs and output of each test")
+	fmt.Fprintln(w, "      --changed [d]      only run tests whose files changed in the last d, or since the last run without d")
+	fmt.Fprintln(w, "      --tags a,b         only run tests tagged with any of the tags (// grunner: tags=a,b)")
+	fmt.Fprintln(w, "      --skip-tags a,b    skip tests tagged with any of the tags")
+	fmt.Fprintln(w, "      --show-config      print the settings the run would use and where each comes from, including the")
+	fmt.Fprintln(w, "                         Makefile's `# grunner: key=value` lines (flag names, e.g. timeout=30, and smp=4)")
+	fmt.Fprintln(w, "      --list             list the tests that would run along with their tags")
+	fmt.Fprintln(w, "      --order o          dispatch tests by name, slowest-first (using run history) or file (default name)")
+	fmt.Fprintln(w, "  -f, --tests-file path  also run the tests listed in path, one per line (repeatable)")
+	fmt.Fprintln(w, "      --sort-display s   order rows by name or status (failures first) (default name)")
+	fmt.Fprintln(w, "      --notify           send a desktop/terminal notification when the run finishes")
+	fmt.Fprintln(w, "      --markdown path    write a markdown summary of the results (also on early quit)")
+	fmt.Fprintln(w, "      --partial-points   scale each test's points (points.json) by its iteration pass rate")
+	fmt.Fprintln(w, "      --partial          score failing tests by the share of .ok lines found in order in the output")
+	fmt.Fprintln(w, "      --projects dir     grade the tests in every project (subdirectory) of dir")
+	fmt.Fprintln(w, "      --compare-config L:VARS")
+	fmt.Fprintln(w, "                         run the tests under each configuration, e.g. A:DEBUG=1 and B:, and compare (repeatable)")
+	fmt.Fprintln(w, "      --matrix path      project × test results as .csv or .json (default grunner-matrix.csv)")
+	fmt.Fprintln(w, "      --trend            show each test's pass rate and average time over recent runs")
+	fmt.Fprintln(w, "      --trend-runs int   number of recent runs --trend covers (default 10)")
+	fmt.Fprintln(w, "      --baseline file    compare against the last run recorded in file instead of the previous run")
+	fmt.Fprintln(w, "      --debug test       boot a single test paused under qemu's gdb stub (port 1234)")
+	fmt.Fprintln(w, "      --ok-wildcards     allow ? (any character) and {{regex}} placeholders in .ok files")
+	fmt.Fprintln(w, "      --compare-cmd t    compare with t instead of diff, the paths being in $GRUNNER_EXPECTED and")
+	fmt.Fprintln(w, "                         $GRUNNER_ACTUAL, or unquoted {expected} and {actual}; exit code 0 passes and")
+	fmt.Fprintln(w, "                         its stdout is written as the .diff")
+	fmt.Fprintf(w, "(gRunner version %s)\n", strings.TrimSpace(Version))
 }
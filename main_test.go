@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grunner/stopwatch"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// a model of n waiting tests with one planned iteration each, as initialModel leaves it minus discovery
+func newTestModel(n int) model {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := model{
+		context:    ctx,
+		cancelCtx:  cancel,
+		executors:  &sync.WaitGroup{},
+		maxThreads: 1,
+		runStart:   time.Now(),
+	}
+	for i := range n {
+		m.testCases = append(m.testCases, testInfo{
+			id:         i,
+			name:       fmt.Sprintf("t%d", i),
+			baseName:   fmt.Sprintf("t%d", i),
+			depsReady:  true,
+			state:      TestStateWaiting,
+			iterations: make([]testIteration, 1),
+			stopwatch:  stopwatch.NewWithInterval(time.Second),
+		})
+	}
+	return m
+}
+
+// runs msg through Update, returning the updated model
+func update(t *testing.T, m model, msg tea.Msg) model {
+	t.Helper()
+	updated, _ := m.Update(msg)
+	return updated.(model)
+}
+
+func TestIsFinishedMixedResolution(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolved []bool
+		finished bool
+	}{
+		{"none resolved", []bool{false, false, false}, false},
+		{"unresolved first", []bool{false, true, true}, false},
+		{"unresolved in the middle", []bool{true, false, true}, false},
+		{"unresolved last", []bool{true, true, false}, false},
+		{"all resolved", []bool{true, true, true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(len(tt.resolved))
+			for i, resolved := range tt.resolved {
+				m.testCases[i].resolved = resolved
+				if resolved {
+					m.testCases[i].state = TestStateSuccess
+				}
+			}
+
+			if got := m.isFinished(); got != tt.finished {
+				t.Errorf("isFinished() = %v, want %v", got, tt.finished)
+			}
+			if got := strings.Contains(m.View(), "Finished!"); got != tt.finished {
+				t.Errorf("View() shows Finished! = %v, want %v", got, tt.finished)
+			}
+
+			// the end of Update freezes the run time only once it should exit
+			m = update(t, m, tea.WindowSizeMsg{Width: 80, Height: 24})
+			if got := !m.runEnd.IsZero(); got != tt.finished {
+				t.Errorf("Update exited = %v, want %v", got, tt.finished)
+			}
+			if got := m.context.Err() != nil; got != tt.finished {
+				t.Errorf("context cancelled = %v, want %v", got, tt.finished)
+			}
+		})
+	}
+}
+
+// the StartStopMsg that Start sends before its first tick
+func startMsg(t *testing.T, sw stopwatch.Model) tea.Msg {
+	t.Helper()
+	batch, ok := sw.Start()().(tea.BatchMsg)
+	if !ok || len(batch) == 0 {
+		t.Fatal("stopwatch.Start() didn't batch its start message")
+	}
+	return batch[0]()
+}
+
+func TestStoppedStopwatchIgnoresTicks(t *testing.T) {
+	m := newTestModel(2)
+	for i := range m.testCases {
+		m = update(t, m, startMsg(t, m.testCases[i].stopwatch))
+		m = update(t, m, stopwatch.TickMsg{ID: m.testCases[i].stopwatch.ID()})
+	}
+	if got := m.testCases[0].stopwatch.Elapsed(); got != time.Second {
+		t.Fatalf("a running test's stopwatch after a tick = %s, want 1s", got)
+	}
+
+	// resolved as resolveTestCase does, the tick loops still in flight
+	for i := range m.testCases {
+		test := &m.testCases[i]
+		test.stopwatch = test.stopwatch.StopNow()
+		test.state = TestStateSuccess
+		test.resolved = true
+	}
+	m = update(t, m, tea.WindowSizeMsg{Width: 80, Height: 24})
+	elapsed, view := m.testCases[0].stopwatch.Elapsed(), m.View()
+
+	late := []tea.Msg{
+		stopwatch.TickMsg{ID: m.testCases[0].stopwatch.ID()},
+		stopwatch.TickMsg{ID: m.testCases[0].stopwatch.ID()},
+		startMsg(t, m.testCases[0].stopwatch),
+		stopwatch.TickMsg{ID: m.testCases[0].stopwatch.ID()},
+	}
+	for _, msg := range late {
+		m = update(t, m, msg)
+	}
+	if got := m.testCases[0].stopwatch.Elapsed(); got != elapsed {
+		t.Errorf("stopped stopwatch after late ticks = %s, want %s", got, elapsed)
+	}
+	if got := m.View(); got != view {
+		t.Errorf("View() changed after late ticks:\n%s\nwant:\n%s", got, view)
+	}
+}
+
+func TestSelectionKeysWithoutTests(t *testing.T) {
+	for _, key := range []string{"f", "o", "j", "k", "up", "down", "enter", "s", "v", "?"} {
+		t.Run(key, func(t *testing.T) {
+			m := newTestModel(0)
+			m.keepOpen = true
+			var msg tea.KeyMsg
+			switch key {
+			case "up":
+				msg = tea.KeyMsg{Type: tea.KeyUp}
+			case "down":
+				msg = tea.KeyMsg{Type: tea.KeyDown}
+			case "enter":
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			default:
+				msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+			}
+			m = update(t, m, msg)
+			m = update(t, m, msg)
+			if m.showDetail {
+				t.Errorf("%s opened the detail view of no test", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// `# grunner: timeout=30` lines in the Makefile
+var makefileDefaultRe = regexp.MustCompile(`^\s*#\s*grunner:\s*(.*)$`)
+
+// a setting from the Makefile's `# grunner:` lines, see readMakefileDefaults
+type makefileDefault struct {
+	key   string
+	value string
+	// where it was read, for warnings and --show-config
+	line int
+}
+
+// flags that are actions rather than settings, which a Makefile can't turn on
+var actionFlags = []string{"--help", "--debug", "--list", "--trend", "--show-config", "--offline"}
+
+// .grunner.json keys whose name isn't the flag's with - replaced by _
+var configKeyRenames = map[string]string{"--ext": "extensions"}
+
+// flags whose default comes from an environment variable, which beats the Makefile as the command line does
+var flagEnvs = map[string]string{"--timeout-multiplier": timeoutMultiplierEnv}
+
+// the qemu core count, which is set through QEMU_SMP rather than a flag
+const smpKey = "smp"
+
+/*
+ * Reads the `# grunner: key=value` lines of a Makefile, one setting per line, so starter code can
+ * ship recommended settings without an extra file. The keys are the names of the flags without
+ * their dashes, and smp for QEMU_SMP. Lines without a key come back as warnings.
+ */
+func readMakefileDefaults(makefile string) ([]makefileDefault, []string, error) {
+	f, err := os.Open(makefile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var defaults []makefileDefault
+	var warnings []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		match := makefileDefaultRe.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		// a bare key turns a boolean flag on, like `// grunner: xfail`
+		key, value, _ := strings.Cut(match[1], "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "" {
+			warnings = append(warnings, fmt.Sprintf("WARNING: ignoring %q on line %d of %s, expected # grunner: key=value.", strings.TrimSpace(match[1]), line, makefile))
+			continue
+		}
+		defaults = append(defaults, makefileDefault{key, value, line})
+	}
+	return defaults, warnings, scanner.Err()
+}
+
+// the field of argumentConfig a Makefile key sets, if it is a setting that takes a single value
+func settingField(key string) (reflect.StructField, bool) {
+	name := "--" + key
+	if strings.HasPrefix(key, "-") || slices.Contains(actionFlags, name) {
+		return reflect.StructField{}, false
+	}
+	fields := reflect.TypeOf(argumentConfig{})
+	for i := range fields.NumField() {
+		field := fields.Field(i)
+		if slices.Contains(strings.Split(field.Tag.Get("clap"), ","), name) && field.Type.Kind() != reflect.Slice {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// whether one of a flag's names is among the arguments, or its environment variable is set
+func flagGiven(args []string, field reflect.StructField) bool {
+	for _, name := range strings.Split(field.Tag.Get("clap"), ",") {
+		if slices.Contains(args, name) {
+			return true
+		}
+		if env, ok := flagEnvs[name]; ok && os.Getenv(env) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// the long name of a flag, from its clap tag
+func longFlag(field reflect.StructField) string {
+	for _, name := range strings.Split(field.Tag.Get("clap"), ",") {
+		if strings.HasPrefix(name, "--") {
+			return name
+		}
+	}
+	return ""
+}
+
+// the value a .grunner.json sets for a flag with the same meaning, if it sets one
+func configSetting(config projectConfig, flag string) (string, bool) {
+	key := cmp.Or(configKeyRenames[flag], strings.ReplaceAll(strings.TrimPrefix(flag, "--"), "-", "_"))
+	fields := reflect.TypeOf(config)
+	for i := range fields.NumField() {
+		if fields.Field(i).Tag.Get("json") != key {
+			continue
+		}
+		value := reflect.ValueOf(config).Field(i)
+		if value.IsZero() {
+			return "", false
+		}
+		return fmt.Sprint(value.Interface()), true
+	}
+	return "", false
+}
+
+// where each setting of a run came from, keyed by long flag name (or smp), for --show-config
+type settingSources map[string]string
+
+/*
+ * Applies the Makefile's settings to the flags given neither on the command line nor in .grunner.json,
+ * which the project config would otherwise lose to. Unknown keys and values that don't parse are
+ * warned about and skipped rather than failing the run, as the Makefile may be newer than grunner.
+ */
+func applyMakefileDefaults(flags *argumentConfig, args []string, makefile string, defaults []makefileDefault, config projectConfig) (settingSources, []string) {
+	sources := make(settingSources)
+	var warnings []string
+	value := reflect.ValueOf(flags).Elem()
+	for _, setting := range defaults {
+		from := fmt.Sprintf("%s:%d", filepath.Base(makefile), setting.line)
+		if setting.key == smpKey {
+			if _, ok := os.LookupEnv("QEMU_SMP"); ok || slices.ContainsFunc(flags.Env, func(entry string) bool { return strings.HasPrefix(entry, "QEMU_SMP=") }) {
+				continue
+			}
+			// before the --env entries, which win when a key appears twice
+			flags.Env = append([]string{"QEMU_SMP=" + setting.value}, flags.Env...)
+			sources[smpKey] = from
+			continue
+		}
+
+		field, ok := settingField(setting.key)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("WARNING: unknown setting %q on line %d of %s is ignored.", setting.key, setting.line, makefile))
+			continue
+		}
+		if _, set := configSetting(config, longFlag(field)); set || flagGiven(args, field) {
+			continue
+		}
+
+		target := value.FieldByIndex(field.Index)
+		var err error
+		if setting.value == "" && target.Kind() != reflect.Bool {
+			err = errors.New("missing value")
+		}
+		switch {
+		case err != nil:
+		case target.Kind() == reflect.String:
+			target.SetString(setting.value)
+		case target.Kind() == reflect.Int:
+			var n int
+			if n, err = strconv.Atoi(setting.value); err == nil {
+				target.SetInt(int64(n))
+			}
+		case target.Kind() == reflect.Float64:
+			var x float64
+			if x, err = strconv.ParseFloat(setting.value, 64); err == nil {
+				target.SetFloat(x)
+			}
+		case target.Kind() == reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(cmp.Or(setting.value, "true")); err == nil {
+				target.SetBool(b)
+			}
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("WARNING: %s=%s on line %d of %s is ignored, %q is not a valid value.", setting.key, setting.value, setting.line, makefile, setting.value))
+			continue
+		}
+		sources[longFlag(field)] = from
+	}
+	return sources, warnings
+}
+
+/*
+ * Prints the settings a run would use and where each came from: the command line, .grunner.json, the
+ * Makefile or grunner's defaults. Settings left empty are left out.
+ */
+func showConfig(w io.Writer, flags *argumentConfig, args []string, makefile string, sources settingSources, config projectConfig) {
+	if makefile == "" {
+		fmt.Fprintln(w, "Makefile: none found")
+	} else {
+		fmt.Fprintf(w, "Makefile: %s\n", makefile)
+	}
+
+	type row struct{ name, value, source string }
+	var rows []row
+	fields := reflect.TypeOf(*flags)
+	value := reflect.ValueOf(*flags)
+	for i := range fields.NumField() {
+		field := fields.Field(i)
+		flag := longFlag(field)
+		if flag == "" || field.Type.Kind() == reflect.Slice || slices.Contains(actionFlags, flag) {
+			continue
+		}
+		var current row
+		if configValue, ok := configSetting(config, flag); ok && !flagGiven(args, field) {
+			current = row{flag, configValue, projectConfigName}
+		} else if from, ok := sources[flag]; ok {
+			current = row{flag, fmt.Sprint(value.Field(i).Interface()), from}
+		} else if flagGiven(args, field) {
+			current = row{flag, fmt.Sprint(value.Field(i).Interface()), "command line"}
+			if env, ok := flagEnvs[flag]; ok && !slices.Contains(args, flag) {
+				current.source = "$" + env
+			}
+		} else if !value.Field(i).IsZero() {
+			current = row{flag, fmt.Sprint(value.Field(i).Interface()), "default"}
+		} else {
+			continue
+		}
+		rows = append(rows, current)
+	}
+	for _, entry := range flags.Env {
+		key, entryValue, _ := strings.Cut(entry, "=")
+		source := "--env"
+		if key == "QEMU_SMP" && sources[smpKey] != "" {
+			source = sources[smpKey]
+		}
+		rows = append(rows, row{key, entryValue, source})
+	}
+	if smp, ok := os.LookupEnv("QEMU_SMP"); ok && !slices.ContainsFunc(rows, func(r row) bool { return r.name == "QEMU_SMP" }) {
+		rows = append(rows, row{"QEMU_SMP", smp, "environment"})
+	}
+
+	// long values such as hooks only push their own source out
+	nameWidth, valueWidth := 0, 0
+	for _, r := range rows {
+		nameWidth = max(nameWidth, len(r.name))
+		valueWidth = min(max(valueWidth, len(r.value)), 24)
+	}
+	for _, r := range rows {
+		fmt.Fprintf(w, "  %-*s  %-*s  (%s)\n", nameWidth, r.name, valueWidth, r.value, r.source)
+	}
+}
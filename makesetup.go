@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// a build that failed over the project setup rather than the test's code, see buildSetupError
+type setupError struct {
+	msg string
+}
+
+func (e setupError) Error() string {
+	return "setup error: " + e.msg
+}
+
+var noRuleRe = regexp.MustCompile("No rule to make target [`']([^']*)'")
+
+/*
+ * Tells a build that failed over the project setup from a compile error, from make's output: make
+ * itself missing, no rule for the test's target, or a command the recipe runs that isn't installed.
+ */
+func buildSetupError(err error, output string, target string) (setupError, bool) {
+	if errors.Is(err, exec.ErrNotFound) {
+		return setupError{"make isn't installed or isn't in PATH"}, true
+	}
+
+	output = ansiRe.ReplaceAllString(output, "")
+	if match := noRuleRe.FindStringSubmatch(output); match != nil {
+		if match[1] == target {
+			return setupError{fmt.Sprintf("the Makefile has no rule for %s, is the test named after a Makefile target?", target)}, true
+		}
+		return setupError{fmt.Sprintf("no rule to make %s, which building the test needs", match[1])}, true
+	}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		// bash, and dash as /bin/sh
+		if strings.HasSuffix(line, "command not found") || strings.HasSuffix(line, ": not found") {
+			return setupError{fmt.Sprintf("a command the Makefile runs isn't installed (%s)", line)}, true
+		}
+	}
+	// make runs simple recipes without a shell and only reports the exit code
+	if strings.Contains(output, "Error 127") {
+		return setupError{"a command the Makefile runs isn't installed (exit code 127)"}, true
+	}
+	return setupError{}, false
+}
+
+/*
+ * The targets and pattern rules of the Makefile in dir, from make's database, which make prints
+ * without building anything when asked for a target that doesn't exist. Nil when make can't print it.
+ */
+func makeRules(dir string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// -r leaves out the built-in rules, which would match any name
+	cmd := exec.CommandContext(ctx, "make", "-pqr", ".grunner-no-such-target")
+	cmd.Dir = dir
+	database, _ := cmd.Output()
+	if len(database) == 0 {
+		return nil
+	}
+
+	rules := []string{}
+	var previous string
+	for _, line := range strings.Split(string(database), "\n") {
+		notTarget := previous == "# Not a target:"
+		previous = line
+		if notTarget || line == "" || strings.ContainsAny(line[:1], "\t #") {
+			continue
+		}
+		targets, rest, ok := strings.Cut(line, ":")
+		// variables are printed as NAME := value
+		if !ok || strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":=") || strings.Contains(targets, "=") {
+			continue
+		}
+		rules = append(rules, strings.Fields(targets)...)
+	}
+	return rules
+}
+
+// whether one of rules, as listed by makeRules, can build target
+func hasMakeRule(rules []string, target string) bool {
+	for _, rule := range rules {
+		prefix, suffix, pattern := strings.Cut(rule, "%")
+		if rule == target || (pattern && len(target) > len(prefix)+len(suffix) && strings.HasPrefix(target, prefix) && strings.HasSuffix(target, suffix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import "strings"
+
+const (
+	// guest memory given to qemu with -m
+	guestMemMB = 128
+	// TCG's translation cache under a memory limit, which qemu would otherwise size at up to 1 GB
+	limitedTBSizeMB = 64
+	// qemu's own heap, thread stacks and libraries on top of the guest memory and translation cache
+	qemuOverheadMB = 512
+)
+
+/*
+ * The --host-mem-limit in bytes, 0 for none with -1. By default it is the guest memory and the capped
+ * translation cache plus qemu's overhead, so a runaway qemu fails alone instead of the host's OOM killer
+ * picking other users' processes.
+ */
+func hostMemLimit(limitMB int) uint64 {
+	if limitMB < 0 {
+		return 0
+	}
+	if limitMB == 0 {
+		limitMB = guestMemMB + limitedTBSizeMB + qemuOverheadMB
+	}
+	return uint64(limitMB) << 20
+}
+
+// the tb-size qemu gets under a memory limit, 0 for qemu's own default without one
+func limitedTBSize(limit uint64) int {
+	if limit == 0 {
+		return 0
+	}
+	return limitedTBSizeMB
+}
+
+/*
+ * Whether qemu died from running into its address space limit rather than a bug in the kernel under
+ * test, from the allocation failure it reports. An abort alone could as well be a qemu assert.
+ */
+func hitMemLimit(stderr string) bool {
+	for _, message := range []string{"Cannot allocate memory", "cannot allocate memory", "failed to allocate", "Out of memory"} {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
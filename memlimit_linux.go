@@ -0,0 +1,18 @@
+package main
+
+import "fmt"
+
+/*
+ * Runs a command under a data segment limit, set by the shell's ulimit before it execs the command so
+ * the limit holds from its first allocation. Unlike an address space limit it only counts writable
+ * memory, not the address space qemu and its threads reserve without using. exec keeps the pid, so
+ * qemu still gets the signals.
+ */
+func memLimitedCommand(name string, args []string, limit uint64) (string, []string) {
+	if limit == 0 {
+		return name, args
+	}
+	// ulimit -d counts KiB
+	script := fmt.Sprintf(`ulimit -d %d && exec "$0" "$@"`, limit>>10)
+	return "/bin/sh", append([]string{"-c", script, name}, args...)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHostMemLimitDefault(t *testing.T) {
+	tests := []struct {
+		name    string
+		limitMB int
+		want    uint64
+		tbSize  int
+	}{
+		{"default", 0, (guestMemMB + limitedTBSizeMB + qemuOverheadMB) << 20, limitedTBSizeMB},
+		{"none", -1, 0, 0},
+		{"given", 2048, 2048 << 20, limitedTBSizeMB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := hostMemLimit(tt.limitMB)
+			if limit != tt.want {
+				t.Errorf("hostMemLimit(%d) = %d, want %d", tt.limitMB, limit, tt.want)
+			}
+			if got := limitedTBSize(limit); got != tt.tbSize {
+				t.Errorf("limitedTBSize(%d) = %d, want %d", limit, got, tt.tbSize)
+			}
+		})
+	}
+}
+
+func TestAccelArgsTBSize(t *testing.T) {
+	known := func(major int, minor int) qemuVersionInfo {
+		return qemuVersionInfo{major: major, minor: minor, raw: "QEMU emulator version"}
+	}
+	tests := []struct {
+		name    string
+		version qemuVersionInfo
+		accel   string
+		tbSize  int
+		want    []string
+	}{
+		{"unlimited", known(8, 2), accelTCG, 0, []string{"-accel", "tcg,thread=multi"}},
+		{"limited", known(8, 2), accelTCG, 64, []string{"-accel", "tcg,tb-size=64,thread=multi"}},
+		{"kvm falling back to limited tcg", known(8, 2), "kvm", 64, []string{"-accel", "kvm", "-accel", "tcg,tb-size=64,thread=multi"}},
+		{"deterministic", known(8, 2), accelDeterministic, 64, []string{"-accel", "tcg,tb-size=64", "-icount", "shift=auto,sleep=off"}},
+		{"before 5.0", known(4, 2), accelTCG, 64, []string{"-accel", "tcg,thread=multi"}},
+	}
+	saved := qemuVersion
+	t.Cleanup(func() { qemuVersion = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qemuVersion = tt.version
+			if got := accelArgs(tt.accel, "4", tt.tbSize); !slices.Equal(got, tt.want) {
+				t.Errorf("accelArgs(%q, 4, %d) = %q, want %q", tt.accel, tt.tbSize, got, tt.want)
+			}
+		})
+	}
+}
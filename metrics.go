@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// sending the metrics never holds up the end of a run for longer than this
+const metricsTimeout = time.Second
+
+/*
+ * An anonymous summary of a run for course staff: no user, paths, git info or output, and test names
+ * hashed unless --metrics-plain-names is given. Posted to the metrics endpoint and/or appended as a
+ * line of the metrics file, both opt-in through flags or the project config.
+ */
+type metricsPayload struct {
+	// day of the run, coarse so runs can't be matched to submissions
+	Date        string       `json:"date"`
+	Version     string       `json:"version"`
+	QemuVersion string       `json:"qemu_version,omitempty"`
+	Machine     machineClass `json:"machine"`
+	Interrupted bool         `json:"interrupted"`
+	// wall-clock time of the run in milliseconds
+	DurationMs int64 `json:"duration_ms"`
+	// number of tests by outcome, e.g. passed, failed, compile error
+	Outcomes    map[string]int `json:"outcomes"`
+	HashedNames bool           `json:"hashed_names"`
+	Tests       []metricsTest  `json:"tests"`
+}
+
+type machineClass struct {
+	OS    string `json:"os"`
+	Arch  string `json:"arch"`
+	CPUs  int    `json:"cpus"`
+	Accel string `json:"accel"`
+	// qemu binary the tests ran on, e.g. qemu-system-x86_64
+	Target string `json:"target"`
+}
+
+type metricsTest struct {
+	Name       string `json:"name"`
+	Outcome    string `json:"outcome"`
+	Passed     int    `json:"passed"`
+	Iterations int    `json:"iterations"`
+	AverageMs  int64  `json:"average_ms"`
+}
+
+// a short stable hash of a test name, the same for every student so failures can still be aggregated
+func hashTestName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (m model) metricsPayload(duration time.Duration, plainNames bool) metricsPayload {
+	payload := metricsPayload{
+		Date:        time.Now().UTC().Format(time.DateOnly),
+		Version:     strings.TrimSpace(Version),
+		QemuVersion: qemuVersion.raw,
+		Machine: machineClass{
+			OS:     runtime.GOOS,
+			Arch:   runtime.GOARCH,
+			CPUs:   runtime.NumCPU(),
+			Accel:  m.accel,
+			Target: qemuArch.binary,
+		},
+		DurationMs:  duration.Milliseconds(),
+		Outcomes:    make(map[string]int),
+		HashedNames: !plainNames,
+	}
+
+	for _, testCase := range m.testCases {
+		if !testCase.resolved {
+			payload.Interrupted = true
+		}
+		outcome := stateLabel(testCase)
+		payload.Outcomes[outcome]++
+
+		name := testCase.name
+		if !plainNames {
+			name = hashTestName(name)
+		}
+		payload.Tests = append(payload.Tests, metricsTest{
+			Name:       name,
+			Outcome:    outcome,
+			Passed:     testCase.CountPassed(),
+			Iterations: len(testCase.iterations),
+			AverageMs:  testCase.AverageTime().Milliseconds(),
+		})
+	}
+	return payload
+}
+
+// the metrics file of offline mode, or ~/.local/share/grunner/metrics.jsonl as the fallback of a failed post
+/*
+ * Where the run's metrics go, the flags beating the project config. Offline they are kept in the
+ * metrics file for later instead, as when the post fails.
+ */
+func metricsDestination(flags *argumentConfig, config projectConfig) (endpoint string, file string) {
+	endpoint, file = cmp.Or(flags.MetricsEndpoint, config.MetricsEndpoint), cmp.Or(flags.MetricsFile, config.MetricsFile)
+	if flags.Offline && endpoint != "" {
+		endpoint = ""
+		if file == "" {
+			file, _ = metricsFilePath("")
+		}
+	}
+	return endpoint, file
+}
+
+func metricsFilePath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	dataDir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "grunner", "metrics.jsonl"), nil
+}
+
+func appendMetrics(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func postMetrics(ctx context.Context, endpoint string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "grunner/"+strings.TrimSpace(Version))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("metrics endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+/*
+ * Sends the payload in the background. The returned function waits for it for what is left of
+ * metricsTimeout and returns a note to print, if any; failures are only ever reported, never fatal.
+ * A failed post falls back to appending the payload to the metrics file.
+ */
+func sendMetrics(payload metricsPayload, endpoint string, file string) func() string {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	notes := make(chan string, 1)
+
+	go func() {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			notes <- "WARNING: failed to encode metrics: " + err.Error()
+			return
+		}
+
+		var postErr error
+		if endpoint != "" {
+			// leaves time to fall back to the file
+			postCtx, cancelPost := context.WithTimeout(ctx, metricsTimeout*3/4)
+			postErr = postMetrics(postCtx, endpoint, data)
+			cancelPost()
+			if postErr == nil && file == "" {
+				notes <- ""
+				return
+			}
+		}
+
+		path, err := metricsFilePath(file)
+		if err == nil {
+			err = appendMetrics(path, data)
+		}
+		switch {
+		case err != nil:
+			notes <- "WARNING: failed to write metrics: " + err.Error()
+		case postErr != nil:
+			notes <- fmt.Sprintf("WARNING: failed to send metrics (%s), appended them to %s instead", postErr, path)
+		default:
+			notes <- ""
+		}
+	}()
+
+	return func() string {
+		defer cancel()
+		select {
+		case note := <-notes:
+			return note
+		case <-ctx.Done():
+			return "WARNING: sending metrics took too long, skipped"
+		}
+	}
+}
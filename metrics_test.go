@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func TestOfflineMakesNoRequests(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+	dsn := strings.Replace(server.URL, "http://", "http://public@", 1) + "/1"
+	t.Cleanup(func() { sentry.CurrentHub().BindClient(nil) })
+
+	tests := []struct {
+		name string
+		args []string
+		// SENTRY_DSN, unset when nil
+		sentryDSN *string
+		offline   bool
+	}{
+		{"--offline", []string{"grunner", "--offline", "tests"}, nil, true},
+		{"empty SENTRY_DSN", []string{"grunner", "tests"}, new(string), true},
+		{"online", []string{"grunner", "tests"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_DATA_HOME", t.TempDir())
+			t.Setenv("SENTRY_DSN", "")
+			if tt.sentryDSN == nil {
+				os.Unsetenv("SENTRY_DSN")
+			}
+			requests.Store(0)
+			sentry.CurrentHub().BindClient(nil)
+
+			offline := offlineMode(tt.args)
+			if offline != tt.offline {
+				t.Fatalf("offlineMode(%q) = %v, want %v", tt.args, offline, tt.offline)
+			}
+			if err := initSentry(offline, dsn); err != nil {
+				t.Fatal(err)
+			}
+			sentry.CaptureMessage("grunner test event")
+			sentry.Flush(2 * time.Second)
+
+			endpoint, file := metricsDestination(&argumentConfig{Offline: offline, MetricsEndpoint: server.URL}, projectConfig{})
+			if note := sendMetrics(metricsPayload{Version: "test"}, endpoint, file)(); strings.HasPrefix(note, "WARNING") {
+				t.Errorf("sendMetrics() = %q", note)
+			}
+
+			if got := requests.Load(); offline && got != 0 {
+				t.Errorf("offline run made %d requests, want none", got)
+			} else if !offline && got < 2 {
+				t.Errorf("online run made %d requests, want the Sentry event and the metrics post", got)
+			}
+			_, err := os.Stat(filepath.Join(os.Getenv("XDG_DATA_HOME"), "grunner", "metrics.jsonl"))
+			if written := err == nil; written != offline {
+				t.Errorf("metrics file written = %v, want %v", written, offline)
+			}
+		})
+	}
+}
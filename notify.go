@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pass/fail summary of the run for notifications, distinguishing a full pass from failures
+func (m model) notificationMessage() (string, string) {
+	var passed, total int
+	for _, testCase := range m.testCases {
+		if testCase.expectedFailure() {
+			continue
+		}
+		total++
+		if testCase.state == TestStateSuccess {
+			passed++
+		}
+	}
+
+	if passed == total {
+		return "grunner: all passed", fmt.Sprintf("All %d tests passed.", passed)
+	}
+	return "grunner: failures", fmt.Sprintf("%d of %d tests failed (%d passed).", total-passed, total, passed)
+}
+
+/*
+ * Notifies that the run finished with a terminal bell plus OSC 9 and OSC 777 escapes (iTerm2, kitty,
+ * wezterm, ...), and notify-send when it is available.
+ */
+func notifyCmd(m model) tea.Cmd {
+	title, body := m.notificationMessage()
+	return func() tea.Msg {
+		// stderr goes to the same terminal without interleaving with the renderer's writes to stdout
+		fmt.Fprintf(os.Stderr, "\a\x1b]9;%s: %s\x07\x1b]777;notify;%s;%s\x07", title, body, title, body)
+
+		if notifySend, err := exec.LookPath("notify-send"); err == nil {
+			e := exec.Command(notifySend, "--app-name=grunner", title, body)
+			if err := e.Start(); err == nil {
+				go func() {
+					timer := time.AfterFunc(5*time.Second, func() { _ = e.Process.Kill() })
+					_ = e.Wait()
+					timer.Stop()
+				}()
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+	xansi "github.com/charmbracelet/x/ansi"
+)
+
+var helpPanelStyle = lipgloss.NewStyle().
+	BorderStyle(lipgloss.RoundedBorder()).
+	BorderForeground(lipgloss.Color("63")).
+	Padding(0, 2)
+
+// drops the first n cells of printable text from s, keeping escape sequences so styling carries over
+func cutLeft(s string, n int) string {
+	var result strings.Builder
+	var skipped int
+	for s != "" {
+		if s[0] == '\x1b' || strings.HasPrefix(s, "\u009b") {
+			if loc := ansiRe.FindStringIndex(s); loc != nil && loc[0] == 0 {
+				result.WriteString(s[:loc[1]])
+				s = s[loc[1]:]
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		if skipped >= n {
+			result.WriteRune(r)
+		} else {
+			skipped += xansi.StringWidth(string(r))
+		}
+		s = s[size:]
+	}
+	return result.String()
+}
+
+// draws the panel centered on top of the background, leaving the rest of the background visible
+func overlay(background string, panel string, width int, height int) string {
+	bgLines := strings.Split(background, "\n")
+	for len(bgLines) < height {
+		bgLines = append(bgLines, "")
+	}
+
+	panelLines := strings.Split(panel, "\n")
+	panelWidth := lipgloss.Width(panel)
+	x := max((width-panelWidth)/2, 0)
+	y := max((height-len(panelLines))/2, 0)
+
+	for i, line := range panelLines {
+		row := y + i
+		if row >= len(bgLines) {
+			break
+		}
+		bg := bgLines[row]
+		left := xansi.Truncate(bg, x, "")
+		left += strings.Repeat(" ", x-xansi.StringWidth(left))
+		bgLines[row] = left + "\x1b[0m" + line + "\x1b[0m" + cutLeft(bg, x+panelWidth)
+	}
+	return strings.Join(bgLines, "\n")
+}
+
+func (m model) helpView() string {
+	keys := [][2]string{
+		{"?", "toggle this help"},
+		{"v", "toggle verbose errors"},
+		{"f", "toggle showing only failures"},
+		{"s", "sort by name or status"},
+		{"q, esc", "quit"},
+	}
+	if m.keepOpen {
+		keys = append(keys,
+			[2]string{"↑/↓, k/j", "select a test"},
+			[2]string{"enter", "show details of the selected test"},
+			[2]string{"o", "open the diff or raw output in $PAGER"},
+		)
+	}
+
+	var str strings.Builder
+	str.WriteString(lipgloss.NewStyle().Bold(true).Render("Keys") + "\n")
+	for _, key := range keys {
+		str.WriteString(settingKeyStyle.Render(key[0]) + key[1] + "\n")
+	}
+	str.WriteString("\n" + lipgloss.NewStyle().Bold(true).Render("Settings") + "\n")
+	str.WriteString(m.settingsView())
+	str.WriteString("\n" + darkGrayStyle.Render("press any key to close"))
+
+	return helpPanelStyle.Render(str.String())
+}
+
+var settingKeyStyle = lipgloss.NewStyle().Width(14).Foreground(lipgloss.Color("205"))
+
+// the Makefiles the tests are built with, as shown to the user
+func (m model) makefilePaths() []string {
+	var paths []string
+	for _, dir := range m.makefileDirs() {
+		paths = append(paths, filepath.Join(displayPath(dir), "Makefile"))
+	}
+	return paths
+}
+
+// the directories the tests were found in, in order
+func (m model) testDirs() []string {
+	var dirs []string
+	for _, test := range m.testCases {
+		dir := displayPath(filepath.Dir(test.filePath))
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// the resolved settings of the run, one per line, for the help overlay and the --confirm prompt
+func (m model) settingsView() string {
+	timeCap := "unlimited"
+	if m.timeCap > 0 {
+		timeCap = m.timeCap.String()
+	}
+	// tests whose iteration count was overridden
+	iterations := fmt.Sprint(m.iterations)
+	var overrides []string
+	for _, test := range m.testCases {
+		if len(test.iterations) != m.iterations {
+			overrides = append(overrides, fmt.Sprintf("%s: %d", test.name, len(test.iterations)))
+		}
+	}
+	if len(overrides) > 0 {
+		iterations += " (" + strings.Join(overrides, ", ") + ")"
+	}
+	timeout := m.iterationTimeout.String()
+	if m.timeoutMultiplier > 0 && m.timeoutMultiplier != 1 {
+		timeout += fmt.Sprintf(" (×%g)", m.timeoutMultiplier)
+	}
+	if m.autoTimeout {
+		timeout += ", then auto"
+	}
+	settings := [][2]string{
+		{"makefile", strings.Join(m.makefilePaths(), ", ")},
+		{"tests", strings.Join(m.testDirs(), ", ")},
+		{"iterations", iterations},
+		{"timeout", timeout},
+		{"timecap", timeCap},
+		{"threads", fmt.Sprint(m.maxThreads)},
+		{"stagger", m.stagger.String()},
+		{"qemu", QemuPath},
+		{"accel", m.accel},
+	}
+
+	var str strings.Builder
+	for _, setting := range settings {
+		str.WriteString(settingKeyStyle.Render(setting[0]) + grayStyle.Render(setting[1]) + "\n")
+	}
+	return str.String()
+}
+
+// the --confirm prompt, the settings in full before anything is built
+func (m model) confirmView() string {
+	tests := fmt.Sprintf("%d tests", len(m.testCases))
+	if len(m.testCases) == 1 {
+		tests = "1 test"
+	}
+	str := lipgloss.JoinHorizontal(lipgloss.Center, titleStyle.Render("Ready to run"), "  "+grayStyle.Render(tests))
+	str += "\n\n" + m.settingsView()
+	str += "\n" + helpStyle.Render("enter start · q abort") + "\n"
+	return str
+}
+
+/*
+ * The settings a wrong guess would show in, on one line under the title: the Makefile found by walking
+ * up from the tests, where the tests are, the qemu binary, threads, timeout and iterations. Truncated
+ * to width unless it's 0.
+ */
+func (m model) settingsLine(width int) string {
+	iterations := "1 iteration"
+	if m.iterations != 1 {
+		iterations = fmt.Sprintf("%d iterations", m.iterations)
+	}
+	line := strings.Join([]string{
+		strings.Join(m.makefilePaths(), ", "),
+		"tests in " + strings.Join(m.testDirs(), ", "),
+		QemuPath,
+		fmt.Sprintf("%d threads", m.maxThreads),
+		m.iterationTimeout.String() + " timeout",
+		iterations,
+	}, " · ")
+	if width > 0 {
+		line = xansi.Truncate(line, max(width, 10), "…")
+	}
+	return line
+}
+
+// space between two columns of rows
+const columnGap = 2
+
+/*
+ * Lays rows out in columns of at most maxLines, each as wide as its widest row and together no wider
+ * than the terminal. Widths are measured in cells and rows cut with xansi, which never splits an escape
+ * sequence and keeps the ones past the cut, so a truncated styled row can't leave its color running
+ * into the rows after it.
+ */
+func columnsView(rows []string, maxLines int, windowWidth int) string {
+	columns := (len(rows) + maxLines - 1) / maxLines
+	perColumn := (len(rows) + columns - 1) / columns
+
+	var columnStrings []string
+	for start := 0; start < len(rows); start += perColumn {
+		var lines []string
+		width := 0
+		for _, row := range rows[start:min(start+perColumn, len(rows))] {
+			line := strings.TrimSuffix(row, "\n")
+			lines = append(lines, line)
+			width = max(width, lipgloss.Width(line))
+		}
+		width += columnGap
+		if windowWidth > 0 {
+			width = min(width, max(windowWidth/columns, 10))
+		}
+		for i, line := range lines {
+			line = xansi.Truncate(line, width-columnGap, "…")
+			lines[i] = line + strings.Repeat(" ", width-lipgloss.Width(line))
+		}
+		columnStrings = append(columnStrings, strings.Join(lines, "\n"))
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top, columnStrings...) + "\n"
+}
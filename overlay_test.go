@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// a complete SGR sequence, and the reset that closes it
+var (
+	sgrRe   = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	sgrOpen = regexp.MustCompile(`\x1b\[[0-9;]*[1-9][0-9;]*m`)
+)
+
+func TestColumnsView(t *testing.T) {
+	// styled as lipgloss does on a color terminal
+	rows := []string{
+		"\x1b[31mt1 compile error: undefined reference\x1b[0m\n",
+		"t2 \x1b[1;32mpassed\x1b[0m\n",
+		"\x1b[33mt3\x1b[0m timed out after \x1b[1m10s\x1b[0m\n",
+	}
+	tests := []struct {
+		name        string
+		windowWidth int
+		want        string
+	}{
+		{"unknown width", 0, "" +
+			"\x1b[31mt1 compile error: undefined reference\x1b[0m  \x1b[33mt3\x1b[0m timed out after \x1b[1m10s\x1b[0m  \n" +
+			"t2 \x1b[1;32mpassed\x1b[0m" + strings.Repeat(" ", 30) + strings.Repeat(" ", 24) + "\n"},
+		{"wide enough", 80, "" +
+			"\x1b[31mt1 compile error: undefined reference\x1b[0m  \x1b[33mt3\x1b[0m timed out after \x1b[1m10s\x1b[0m  \n" +
+			"t2 \x1b[1;32mpassed\x1b[0m" + strings.Repeat(" ", 30) + strings.Repeat(" ", 24) + "\n"},
+		{"cut inside a style", 40, "" +
+			"\x1b[31mt1 compile error:…\x1b[0m  \x1b[33mt3\x1b[0m timed out afte…\x1b[1m\x1b[0m  \n" +
+			"t2 \x1b[1;32mpassed\x1b[0m" + strings.Repeat(" ", 11) + strings.Repeat(" ", 20) + "\n"},
+		{"cut before a style", 20, "" +
+			"\x1b[31mt1 comp…\x1b[0m  \x1b[33mt3\x1b[0m time…\x1b[1m\x1b[0m  \n" +
+			"t2 \x1b[1;32mpass…\x1b[0m  " + strings.Repeat(" ", 10) + "\n"},
+		{"narrower than the minimum column", 12, "" +
+			"\x1b[31mt1 comp…\x1b[0m  \x1b[33mt3\x1b[0m time…\x1b[1m\x1b[0m  \n" +
+			"t2 \x1b[1;32mpass…\x1b[0m  " + strings.Repeat(" ", 10) + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := columnsView(rows, 2, tt.windowWidth)
+			if got != tt.want {
+				t.Errorf("columnsView(width %d) =\n%q\nwant\n%q", tt.windowWidth, got, tt.want)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+				if tt.windowWidth >= 20 && lipgloss.Width(line) > tt.windowWidth {
+					t.Errorf("line %q is %d cells wide, over the window's %d", line, lipgloss.Width(line), tt.windowWidth)
+				}
+				// what is left once every complete sequence is gone can't start another one
+				if rest := sgrRe.ReplaceAllString(line, ""); strings.Contains(rest, "\x1b") {
+					t.Errorf("line %q has a cut escape sequence", line)
+				}
+				if sequences := sgrRe.FindAllString(line, -1); len(sequences) > 0 && sgrOpen.MatchString(sequences[len(sequences)-1]) {
+					t.Errorf("line %q leaves its style open", line)
+				}
+			}
+		})
+	}
+}
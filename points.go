@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var pointsFileNames = []string{"points.json", "grunner.points"}
+
+/*
+ * Loads test weights from points.json ({"t0": 2}) or grunner.points ("t0 2" or "t0=2" per line),
+ * looking in the given directories in order. Returns nil if no points file exists.
+ */
+func loadPoints(dirs ...string) (map[string]float64, string, error) {
+	for _, dir := range dirs {
+		for _, name := range pointsFileNames {
+			path := filepath.Join(dir, name)
+			data, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, path, fmt.Errorf("error reading %s: %w", path, err)
+			}
+
+			points, err := parsePoints(name, data)
+			if err != nil {
+				return nil, path, fmt.Errorf("error parsing %s: %w", path, err)
+			}
+			return points, path, nil
+		}
+	}
+	return nil, "", nil
+}
+
+func parsePoints(name string, data []byte) (map[string]float64, error) {
+	points := make(map[string]float64)
+	if filepath.Ext(name) == ".json" {
+		err := json.Unmarshal(data, &points)
+		return points, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<test> <points>\"", lineNum)
+		}
+		weight, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid points %q", lineNum, fields[1])
+		}
+		points[fields[0]] = weight
+	}
+	return points, scanner.Err()
+}
+
+// weight of a test, defaulting to 1 for tests missing from the points file
+func (m model) maxPoints(t testInfo) float64 {
+	if weight, ok := m.points[t.baseName]; ok {
+		return weight
+	}
+	return 1
+}
+
+// points earned by a test, optionally scaled by its iteration pass rate or --partial score
+func (m model) earnedPoints(t testInfo) float64 {
+	if m.partial && t.resolved {
+		return m.maxPoints(t) * t.PartialScore()
+	}
+	if m.partialPoints && len(t.iterations) > 0 && t.resolved {
+		return m.maxPoints(t) * float64(t.CountPassed()) / float64(len(t.iterations))
+	}
+	if t.state == TestStateSuccess {
+		return m.maxPoints(t)
+	}
+	return 0
+}
+
+func (m model) score() (float64, float64) {
+	var earned, total float64
+	for _, testCase := range m.testCases {
+		earned += m.earnedPoints(testCase)
+		total += m.maxPoints(testCase)
+	}
+	return earned, total
+}
+
+func formatPoints(points float64) string {
+	return strconv.FormatFloat(math.Round(points*100)/100, 'f', -1, 64)
+}
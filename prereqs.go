@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/getsentry/sentry-go"
+)
+
+// make target some tests need built first (e.g. fs.img), declared with `// grunner: requires=fs.img`
+type prereqInfo struct {
+	id     int
+	dir    string
+	target string
+	// a --fixture every test of the project requires, built before the other prerequisites; a
+	// failure aborts the run instead of blocking tests
+	fixture bool
+	// waiting, building, success or compile failure
+	state TestState
+	err   error
+}
+
+type prereqBuildErr struct {
+	int
+	err    error
+	output []byte
+}
+type prereqBuildSuccess int
+
+// one prerequisite per unique target of each project, in the order the tests require them
+func collectPrereqs(testCases []testInfo, fixtures map[string][]string) []prereqInfo {
+	var prereqs []prereqInfo
+	for _, testCase := range testCases {
+		for _, target := range testCase.requires {
+			if !slices.ContainsFunc(prereqs, func(p prereqInfo) bool { return p.dir == testCase.makefileDir && p.target == target }) {
+				fixture := slices.Contains(fixtures[testCase.makefileDir], target)
+				prereqs = append(prereqs, prereqInfo{id: len(prereqs), dir: testCase.makefileDir, target: target, fixture: fixture, state: TestStateWaiting})
+			}
+		}
+	}
+	return prereqs
+}
+
+// images take longer than a single test to build
+const prereqTimeout = 60 * time.Second
+
+func buildPrereq(ctx context.Context, env []string, timeout time.Duration, prereq prereqInfo) tea.Cmd {
+	return func() tea.Msg {
+		span := sentry.StartSpan(ctx, "function")
+		span.Description = fmt.Sprintf("prereq.%s", prereq.target)
+		defer span.Finish()
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		var output bytes.Buffer
+		e := exec.CommandContext(ctx, "make", prereq.target)
+		e.Dir = prereq.dir
+		e.Env = env
+		e.Stdout = &output
+		e.Stderr = &output
+		if err := e.Run(); err != nil {
+			return prereqBuildErr{prereq.id, fmt.Errorf("make %s: %w", prereq.target, err), output.Bytes()}
+		}
+		return prereqBuildSuccess(prereq.id)
+	}
+}
+
+/*
+ * Starts building the prerequisites of a project once its kernel is built, its fixtures first: the
+ * other prerequisites wait for every fixture to be built, so nothing races a fixture's make.
+ */
+func (m *model) startPrereqs(dir string) []tea.Cmd {
+	fixturesOnly := slices.ContainsFunc(m.prereqs, func(p prereqInfo) bool {
+		return p.dir == dir && p.fixture && p.state != TestStateSuccess
+	})
+
+	var cmds []tea.Cmd
+	for i := range m.prereqs {
+		if prereq := &m.prereqs[i]; prereq.dir == dir && prereq.state == TestStateWaiting && (prereq.fixture || !fixturesOnly) {
+			prereq.state = TestStateBuilding
+			cmds = append(cmds, buildPrereq(m.context, m.buildEnv(m.phase), m.scaleTimeout(prereqTimeout), *prereq))
+		}
+	}
+	return cmds
+}
+
+// whether every target the test requires has been built
+func (m model) prereqsReady(t testInfo) bool {
+	for _, target := range t.requires {
+		for _, prereq := range m.prereqs {
+			if prereq.dir == t.makefileDir && prereq.target == target && prereq.state != TestStateSuccess {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// whether the test requires the given prerequisite
+func (t testInfo) requiresPrereq(prereq prereqInfo) bool {
+	return t.makefileDir == prereq.dir && slices.Contains(t.requires, prereq.target)
+}
+
+func (p prereqInfo) View(m model) string {
+	name := testStyle.Render(p.target)
+	kind := "prerequisite"
+	if p.fixture {
+		kind = "fixture"
+	}
+	switch p.state {
+	case TestStateBuilding:
+		return fmt.Sprintf("%s %s building %s...\n", m.spinner.View(), name, kind)
+	case TestStateSuccess:
+		return fmt.Sprintf("%s %s %s\n", lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("✔"), name, darkGrayStyle.Render(kind+" built"))
+	case TestStateCompileFailure:
+		row := fmt.Sprintf("%s %s %s\n", lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render("✘"), name, errorStyle.Render(kind+" failed to build"))
+		if m.verbose && p.err != nil {
+			row += grayStyle.Render(p.err.Error()) + "\n"
+		}
+		return row
+	default:
+		return fmt.Sprintf("%s %s waiting...\n", lipgloss.NewStyle().Foreground(lipgloss.Color("7")).Render("•"), name)
+	}
+}
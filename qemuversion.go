@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type qemuVersionInfo struct {
+	major, minor, patch int
+	// the whole version line, empty when qemu couldn't be asked or its answer wasn't understood
+	raw string
+}
+
+var qemuVersionRe = regexp.MustCompile(`version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// version of the qemu in use, detected once at startup; unknown versions get the usual arguments
+var qemuVersion qemuVersionInfo
+
+func detectQemuVersion() qemuVersionInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, QemuPath, "--version").Output()
+	if err != nil {
+		return qemuVersionInfo{}
+	}
+
+	match := qemuVersionRe.FindSubmatch(out)
+	if match == nil {
+		return qemuVersionInfo{}
+	}
+	var version qemuVersionInfo
+	version.major, _ = strconv.Atoi(string(match[1]))
+	version.minor, _ = strconv.Atoi(string(match[2]))
+	version.patch, _ = strconv.Atoi(string(match[3]))
+	version.raw = fmt.Sprintf("%d.%d.%d", version.major, version.minor, version.patch)
+	return version
+}
+
+func (v qemuVersionInfo) known() bool {
+	return v.raw != ""
+}
+
+func (v qemuVersionInfo) String() string {
+	if !v.known() {
+		return "unknown"
+	}
+	return v.raw
+}
+
+func (v qemuVersionInfo) atLeast(major int, minor int) bool {
+	return v.major > major || (v.major == major && v.minor >= minor)
+}
+
+// qemu before 7.0 rejects file.locking=off in -drive shorthand
+func (v qemuVersionInfo) driveLocking() bool {
+	return !v.known() || v.atLeast(7, 0)
+}
+
+// qemu 8.0 and later warn about thread=multi on single-core guests
+func (v qemuVersionInfo) multiThreadTCG(smp string) bool {
+	return !v.known() || !v.atLeast(8, 0) || smp != "1"
+}
+
+// qemu 5.0 added tb-size to -accel tcg, older ones only had the 32 MB translation cache anyway
+func (v qemuVersionInfo) tbSize() bool {
+	return !v.known() || v.atLeast(5, 0)
+}
+
+// what the argument construction adapts for this version, worth telling the user about
+func (v qemuVersionInfo) compatNotes() []string {
+	var notes []string
+	if !v.driveLocking() {
+		notes = append(notes, fmt.Sprintf("qemu %s doesn't support file.locking=off, disk images are opened without it", v))
+	}
+	return notes
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how long grunner waits on qemu's QMP socket before falling back to signals
+const qmpTimeout = 500 * time.Millisecond
+
+// a minimal client for qemu's machine protocol (QMP), enough to query the guest and quit cleanly
+type qmpClient struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+	Event string `json:"event"`
+}
+
+// unix socket paths longer than this don't fit in sockaddr_un on every OS
+const maxSocketPath = 100
+
+/*
+ * A per-iteration socket path in a fresh dir under the run's temp dir, which the returned function
+ * removes. Falls back to the system temp dir when the path would be too long for a socket.
+ */
+func qmpSocketPath(tmpDir string) (string, func(), error) {
+	if len(filepath.Join(tmpDir, "qmp-0123456789", "qmp.sock")) > maxSocketPath {
+		tmpDir = ""
+	}
+	dir, err := os.MkdirTemp(tmpDir, "qmp-")
+	if err != nil {
+		return "", func() {}, err
+	}
+	return filepath.Join(dir, "qmp.sock"), func() { _ = os.RemoveAll(dir) }, nil
+}
+
+// connects to the socket, reads qemu's greeting and negotiates capabilities
+func dialQMP(path string) (*qmpClient, error) {
+	conn, err := net.DialTimeout("unix", path, qmpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(qmpTimeout))
+
+	client := &qmpClient{conn: conn, dec: json.NewDecoder(conn)}
+	var greeting map[string]json.RawMessage
+	if err := client.dec.Decode(&greeting); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading QMP greeting: %w", err)
+	} else if _, ok := greeting["QMP"]; !ok {
+		conn.Close()
+		return nil, errors.New("not a QMP socket")
+	}
+	if _, err := client.execute("qmp_capabilities"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *qmpClient) Close() error {
+	return c.conn.Close()
+}
+
+// runs a command, skipping any asynchronous events sent before its response
+func (c *qmpClient) execute(command string) (json.RawMessage, error) {
+	if err := json.NewEncoder(c.conn).Encode(map[string]string{"execute": command}); err != nil {
+		return nil, err
+	}
+	for {
+		var response qmpResponse
+		if err := c.dec.Decode(&response); err != nil {
+			return nil, err
+		}
+		if response.Event != "" {
+			continue
+		}
+		if response.Error != nil {
+			return nil, fmt.Errorf("QMP %s: %s", command, response.Error.Desc)
+		}
+		return response.Return, nil
+	}
+}
+
+// the guest's run state, e.g. running, paused, guest-panicked or internal-error
+func (c *qmpClient) queryStatus() (string, error) {
+	result, err := c.execute("query-status")
+	if err != nil {
+		return "", err
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(result, &status); err != nil {
+		return "", err
+	}
+	return status.Status, nil
+}
+
+func (c *qmpClient) quit() error {
+	_, err := c.execute("quit")
+	// qemu may hang up before it gets to reply
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
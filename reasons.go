@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// iterations of a test that ended the same way, e.g. with the same diff
+type reasonGroup struct {
+	label string
+	count int
+	// the error of the group's latest iteration, nil for passes
+	err error
+	// index of the group's latest iteration, breaking ties between equally common reasons
+	last int
+}
+
+/*
+ * Canonicalizes the outcome of an iteration so identical failures group together: diffs by the hash
+ * of their uncolored content, timeouts as such and other errors by the first line of their message.
+ */
+func failureReason(err error) (key string, label string) {
+	var diffErr diffError
+	switch {
+	case err == nil:
+		return "passed", "passed"
+	case errors.As(err, &diffErr):
+		diff := ansiRe.ReplaceAllString(string(diffErr.diff), "")
+		sum := sha256.Sum256([]byte(diff))
+		return fmt.Sprintf("diff %x", sum[:8]), fmt.Sprintf("diff (%d lines differ)", countDiffLines(diffErr.diff))
+	case errors.Is(err, errTimedOut):
+		return "timed out", "timed out"
+	default:
+		line := strings.SplitN(err.Error(), "\n", 2)[0]
+		return line, line
+	}
+}
+
+// the outcomes of the iterations that ran, most common first
+func (t testInfo) reasonGroups() []reasonGroup {
+	var groups []reasonGroup
+	index := make(map[string]int)
+	for i, iteration := range t.iterations {
+		if iteration.timeSpanned == 0 {
+			continue
+		}
+		err := iteration.err
+		if !iteration.passed && err == nil {
+			err = t.err
+		}
+		key, label := failureReason(err)
+		if j, ok := index[key]; ok {
+			groups[j].count++
+			groups[j].err = err
+			groups[j].last = i
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, reasonGroup{label: label, count: 1, err: err, last: i})
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		return groups[i].last > groups[j].last
+	})
+	return groups
+}
+
+// the error of the most common failure, which the row, detail view and reports show
+func (t testInfo) commonFailure() error {
+	for _, group := range t.reasonGroups() {
+		if group.err != nil {
+			return group.err
+		}
+	}
+	return t.err
+}
+
+// `32× diff (3 lines differ) · 3× timed out · 15× passed`, empty unless the iterations ended differently
+// or more than one failed
+func (t testInfo) reasonSummary() string {
+	groups := t.reasonGroups()
+	if len(groups) == 0 || (len(groups) == 1 && groups[0].count == 1) || (len(groups) == 1 && groups[0].err == nil) {
+		return ""
+	}
+
+	var parts []string
+	for _, group := range groups {
+		label := group.label
+		if group.count > 1 && strings.HasPrefix(label, "diff") {
+			label = "identical " + label
+		}
+		parts = append(parts, fmt.Sprintf("%d× %s", group.count, label))
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshot of a run's results, shared by the report writers
+type runReport struct {
+	Interrupted bool
+	// why the run ended, e.g. completed, quit by the user or stopped by a signal
+	EndReason string
+	// the --max-duration budget ran out
+	BudgetExceeded bool
+	Git            *gitInfo
+	// empty when qemu's version couldn't be detected
+	QemuVersion string
+	// wall-clock duration of the run, and the time its iterations took added up
+	WallTime      time.Duration
+	IterationTime time.Duration
+	Passed        int
+	// xfail tests that failed, left out of the passed/total count
+	ExpectedFailures int
+	// only set when a points file is in use
+	HasPoints bool
+	Score     float64
+	MaxScore  float64
+	Tests     []testReport
+	// --compare-config labels, and the tests' outcomes under each
+	Configs     []string
+	Comparisons []configComparison
+}
+
+type testReport struct {
+	Name        string
+	State       string
+	Xfail       bool
+	Passed      int
+	Iterations  int
+	AverageTime time.Duration
+	// duration and result of each iteration that ran
+	IterationTimes  []time.Duration
+	IterationPassed []bool
+	Points          float64
+	MaxPoints       float64
+	Error           string
+	// plain-text diff or captured output of the failure
+	Details string
+	// compiler warnings from building the test
+	Warnings []string
+	// the --compare-config configuration the test ran under, e.g. A (DEBUG=1)
+	Config string
+	// --partial score of a failed test, e.g. 73%, empty otherwise
+	Score string
+	// where the failing iterations first diverged from the expected output, most common first
+	Divergences []string
+	// how the iterations ended, grouped, e.g. `3× timed out · 15× passed`
+	Reasons string
+	// the iterations the test was meant to run when --timecap cut it short, 0 otherwise
+	CappedFrom int
+}
+
+// exit code of a run whose tests failed, as opposed to invalid arguments or grunner's own errors
+const exitTestsFailed = 5
+
+// the exit code the tests' results call for, 0 when every test passed or failed as expected
+func (m model) resultExitCode() int {
+	if m.overBudget {
+		return exitBudgetExceeded
+	}
+	if slices.ContainsFunc(m.testCases, testInfo.failsRun) {
+		return exitTestsFailed
+	}
+	return 0
+}
+
+// human-readable state of a test; unresolved tests are reported as interrupted or not run
+func stateLabel(t testInfo) string {
+	if t.resolved && t.expectedFailure() {
+		return "expected failure"
+	} else if t.unexpectedPass() {
+		return "unexpectedly passed"
+	}
+	switch t.state {
+	case TestStateSuccess:
+		return "passed"
+	case TestStateFailure:
+		if !t.resolved {
+			return "interrupted"
+		}
+		return "failed"
+	case TestStateCompileFailure:
+		return "compile error"
+	case TestStateSetupError:
+		return "setup error"
+	case TestStateBlocked:
+		return "blocked"
+	case TestStateOverBudget:
+		return "not run (budget exceeded)"
+	case TestStateUnimplemented:
+		return "unimplemented"
+	case TestStateNoExpected:
+		return "no expected output"
+	case TestStateBuilding, TestStateRunning:
+		return "interrupted"
+	default:
+		return "not run"
+	}
+}
+
+// plain-text failure details of a test for reports
+func failureDetails(t testInfo) string {
+	var diffErr diffError
+	if errors.As(t.err, &diffErr) {
+		return ansiRe.ReplaceAllString(string(diffErr.diff), "")
+	}
+	var outErr outputError
+	if errors.As(t.err, &outErr) {
+		return ansiRe.ReplaceAllString(tail(string(outErr.output), detailTailLines), "")
+	}
+	return ""
+}
+
+func (m model) report() runReport {
+	report := runReport{BudgetExceeded: m.overBudget, Git: m.git, QemuVersion: qemuVersion.raw, EndReason: m.endReason}
+	if m.points != nil {
+		report.HasPoints = true
+		report.Score, report.MaxScore = m.score()
+	}
+	for _, testCase := range m.testCases {
+		if !testCase.resolved {
+			report.Interrupted = true
+		}
+		if testCase.state == TestStateSuccess {
+			report.Passed++
+		}
+		if testCase.expectedFailure() {
+			report.ExpectedFailures++
+		}
+
+		test := testReport{
+			Name:        testCase.name,
+			State:       stateLabel(testCase),
+			Xfail:       testCase.xfail,
+			Passed:      testCase.CountPassed(),
+			Iterations:  len(testCase.iterations),
+			AverageTime: testCase.AverageTime(),
+			Points:      m.earnedPoints(testCase),
+			MaxPoints:   m.maxPoints(testCase),
+			Warnings:    testCase.buildWarnings,
+			Divergences: testCase.divergenceSummary(),
+			Reasons:     testCase.reasonSummary(),
+			CappedFrom:  testCase.cappedFrom,
+		}
+		for _, iteration := range testCase.iterations {
+			test.IterationTimes = append(test.IterationTimes, iteration.timeSpanned)
+			report.IterationTime += iteration.timeSpanned
+			test.IterationPassed = append(test.IterationPassed, iteration.passed)
+		}
+		if testCase.err != nil && testCase.state != TestStateSuccess {
+			test.Error = testCase.err.Error()
+			test.Details = failureDetails(testCase)
+		}
+		if m.partial && testCase.state == TestStateFailure {
+			test.Score = formatScore(testCase.PartialScore())
+		}
+		if len(m.configs) > 0 {
+			test.Config = m.configs[testCase.config].String()
+		}
+		report.Tests = append(report.Tests, test)
+	}
+	if len(m.configs) > 0 {
+		for _, config := range m.configs {
+			report.Configs = append(report.Configs, config.label)
+		}
+		report.Comparisons = m.configComparisons()
+	}
+	report.WallTime = m.elapsed().Round(time.Millisecond)
+	report.IterationTime = report.IterationTime.Round(time.Millisecond)
+	if report.EndReason == "" && report.Interrupted {
+		report.EndReason = "interrupted"
+	} else if report.EndReason == "" {
+		report.EndReason = "completed"
+	}
+	return report
+}
+
+/*
+ * Writes the --html and --markdown reports. Called on every way a run can end, including a crash, so
+ * CI gets a report marking the unfinished tests rather than no file at all.
+ */
+func writeReports(r runReport, htmlPath string, markdownPath string) []error {
+	var errs []error
+	if htmlPath != "" {
+		html, err := r.html()
+		if err == nil {
+			err = writeFileAtomic(htmlPath, []byte(html), 0644)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("HTML report: %w", err))
+		}
+	}
+	if markdownPath != "" {
+		if err := writeFileAtomic(markdownPath, []byte(r.markdown()), 0644); err != nil {
+			errs = append(errs, fmt.Errorf("markdown summary: %w", err))
+		}
+	}
+	return errs
+}
+
+const markdownExcerptLines = 40
+
+var stateEmoji = map[string]string{
+	"passed":                    "✅",
+	"failed":                    "❌",
+	"compile error":             "⚠️",
+	"setup error":               "🔧",
+	"interrupted":               "⏸️",
+	"not run":                   "⏭️",
+	"blocked":                   "⛔",
+	"no expected output":        "❔",
+	"unimplemented":             "🚧",
+	"not run (budget exceeded)": "⏱️",
+	"expected failure":          "🟡",
+	"unexpectedly passed":       "❗",
+}
+
+// markdown summary table with collapsible failure details, for posting on pull requests
+func (r runReport) markdown() string {
+	var str strings.Builder
+
+	str.WriteString("## grunner results\n\n")
+	if r.Git != nil {
+		fmt.Fprintf(&str, "Commit `%s`", r.Git.Commit)
+		if r.Git.Branch != "" && r.Git.Branch != "HEAD" {
+			fmt.Fprintf(&str, " on `%s`", r.Git.Branch)
+		}
+		if r.Git.Dirty {
+			str.WriteString(" with uncommitted changes")
+		}
+		str.WriteString(".\n\n")
+	}
+	if r.QemuVersion != "" {
+		fmt.Fprintf(&str, "qemu %s.\n\n", r.QemuVersion)
+	}
+	if r.Interrupted {
+		fmt.Fprintf(&str, "> **Note:** the run was interrupted (%s) before all tests finished, results are partial.\n\n", r.EndReason)
+	} else if r.BudgetExceeded {
+		str.WriteString("> **Note:** the time budget ran out before all tests finished, results are partial.\n\n")
+	}
+	fmt.Fprintf(&str, "**%d/%d** tests passed.", r.Passed, len(r.Tests)-r.ExpectedFailures)
+	if r.ExpectedFailures > 0 {
+		fmt.Fprintf(&str, " %d expected failure(s) not counted.", r.ExpectedFailures)
+	}
+	if r.HasPoints {
+		fmt.Fprintf(&str, " Score: **%s/%s**.", formatPoints(r.Score), formatPoints(r.MaxScore))
+	}
+	str.WriteString("\n\n")
+	if r.WallTime > 0 {
+		fmt.Fprintf(&str, "Took %s, %s of iterations.\n\n", r.WallTime, r.IterationTime)
+	}
+
+	for i, test := range r.Tests {
+		// with --compare-config each configuration gets its own table
+		if i == 0 || test.Config != r.Tests[i-1].Config {
+			if i > 0 {
+				str.WriteString("\n")
+			}
+			if test.Config != "" {
+				fmt.Fprintf(&str, "### Configuration %s\n\n", test.Config)
+			}
+			if r.HasPoints {
+				str.WriteString("| Test | State | Iterations passed | Average time | Points |\n")
+				str.WriteString("| --- | --- | --- | --- | --- |\n")
+			} else {
+				str.WriteString("| Test | State | Iterations passed | Average time |\n")
+				str.WriteString("| --- | --- | --- | --- |\n")
+			}
+		}
+		state := test.State
+		if test.Score != "" {
+			state += " " + test.Score
+		}
+		if len(test.Warnings) > 0 {
+			state += fmt.Sprintf(" (⚠️ %d warning(s))", len(test.Warnings))
+		}
+		iterations := fmt.Sprintf("%d/%d", test.Passed, test.Iterations)
+		if test.CappedFrom > 0 {
+			iterations += fmt.Sprintf(" (capped, %d requested)", test.CappedFrom)
+		}
+		fmt.Fprintf(&str, "| `%s` | %s %s | %s | %s |", test.Name, stateEmoji[test.State], state, iterations, test.AverageTime)
+		if r.HasPoints {
+			fmt.Fprintf(&str, " %s/%s |", formatPoints(test.Points), formatPoints(test.MaxPoints))
+		}
+		str.WriteString("\n")
+	}
+
+	if len(r.Comparisons) > 0 {
+		differing := slices.DeleteFunc(slices.Clone(r.Comparisons), func(c configComparison) bool { return !c.Differs })
+		fmt.Fprintf(&str, "\n### Differences between configurations\n\n%d of %d tests ended differently.\n", len(differing), len(r.Comparisons))
+		if len(differing) > 0 {
+			str.WriteString("\n| Test | " + strings.Join(r.Configs, " | ") + " |\n")
+			str.WriteString("| --- |" + strings.Repeat(" --- |", len(r.Configs)) + "\n")
+		}
+		for _, comparison := range differing {
+			fmt.Fprintf(&str, "| `%s` | %s |\n", comparison.Test, strings.Join(comparison.States, " | "))
+		}
+	}
+
+	for _, test := range r.Tests {
+		if test.Error == "" {
+			continue
+		}
+		fmt.Fprintf(&str, "\n<details>\n<summary><code>%s</code>: %s</summary>\n\n", test.Name, strings.SplitN(test.Error, "\n", 2)[0])
+		if test.Reasons != "" {
+			fmt.Fprintf(&str, "%s\n\n", test.Reasons)
+		}
+		for _, divergence := range test.Divergences {
+			fmt.Fprintf(&str, "- %s\n", divergence)
+		}
+		if len(test.Divergences) > 0 {
+			str.WriteString("\n")
+		}
+		details := test.Details
+		if details == "" {
+			details = test.Error
+		}
+		lines := strings.Split(strings.TrimRight(details, "\n"), "\n")
+		if len(lines) > markdownExcerptLines {
+			lines = append(lines[:markdownExcerptLines], fmt.Sprintf("… %d more lines", len(lines)-markdownExcerptLines))
+		}
+		fmt.Fprintf(&str, "```diff\n%s\n```\n\n</details>\n", strings.Join(lines, "\n"))
+	}
+
+	for _, test := range r.Tests {
+		if len(test.Warnings) == 0 {
+			continue
+		}
+		fmt.Fprintf(&str, "\n<details>\n<summary><code>%s</code>: %d compiler warning(s)</summary>\n\n", test.Name, len(test.Warnings))
+		fmt.Fprintf(&str, "```\n%s\n```\n\n</details>\n", strings.Join(test.Warnings, "\n"))
+	}
+
+	return str.String()
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestResultExitCode(t *testing.T) {
+	type result struct {
+		state TestState
+		xfail bool
+	}
+	tests := []struct {
+		name       string
+		results    []result
+		overBudget bool
+		want       int
+	}{
+		{"all passed", []result{{TestStateSuccess, false}, {TestStateSuccess, false}}, false, 0},
+		{"failed", []result{{TestStateSuccess, false}, {TestStateFailure, false}}, false, exitTestsFailed},
+		{"compile error", []result{{TestStateCompileFailure, false}}, false, exitTestsFailed},
+		{"no .ok file", []result{{TestStateNoExpected, false}}, false, exitTestsFailed},
+		{"xfail failed", []result{{TestStateSuccess, false}, {TestStateFailure, true}}, false, 0},
+		{"xfail didn't compile", []result{{TestStateCompileFailure, true}}, false, 0},
+		{"xfail passed", []result{{TestStateSuccess, true}}, false, exitTestsFailed},
+		{"xfail failed next to a failure", []result{{TestStateFailure, true}, {TestStateFailure, false}}, false, exitTestsFailed},
+		{"over budget", []result{{TestStateFailure, false}, {TestStateOverBudget, false}}, true, exitBudgetExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(len(tt.results))
+			m.overBudget = tt.overBudget
+			for i, r := range tt.results {
+				m.testCases[i].state = r.state
+				m.testCases[i].xfail = r.xfail
+				m.testCases[i].resolved = true
+			}
+			if got := m.resultExitCode(); got != tt.want {
+				t.Errorf("resultExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
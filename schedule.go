@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	orderName         = "name"
+	orderSlowestFirst = "slowest-first"
+	orderFile         = "file"
+)
+
+// average iteration time of each test over the recorded runs of this project
+func historicalDurations(m model) map[string]time.Duration {
+	path, err := historyPath(m.makefileDir, m.config)
+	if err != nil {
+		return nil
+	}
+	records, err := readHistory(path, m.historyRecord(nil).Dir)
+	if err != nil {
+		return nil
+	}
+
+	totals := make(map[string]int64)
+	counts := make(map[string]int64)
+	for _, record := range records {
+		for _, test := range record.Tests {
+			if test.AverageMs > 0 {
+				totals[test.Name] += test.AverageMs
+				counts[test.Name]++
+			}
+		}
+	}
+
+	durations := make(map[string]time.Duration)
+	for name, total := range totals {
+		durations[name] = time.Duration(total/counts[name]) * time.Millisecond
+	}
+	return durations
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+/*
+ * Orders tests longest first (LPT scheduling) so long tests start early and short ones fill the tail.
+ * Tests without history come after, largest source first, then by name.
+ */
+func slowestFirstOrder(testCases []testInfo, durations map[string]time.Duration) []int {
+	order := make([]int, len(testCases))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := testCases[order[i]], testCases[order[j]]
+		durationA, knownA := durations[a.name]
+		durationB, knownB := durations[b.name]
+		switch {
+		case knownA && knownB:
+			return durationA > durationB
+		case knownA != knownB:
+			return knownA
+		}
+		if sizeA, sizeB := fileSize(a.filePath), fileSize(b.filePath); sizeA != sizeB {
+			return sizeA > sizeB
+		}
+		return strings.Compare(a.name, b.name) < 0
+	})
+	return order
+}
+
+// indices into testCases in the order tests are dispatched
+func (m model) dispatchOrder() []int {
+	if m.order != nil {
+		return m.order
+	}
+	order := make([]int, len(m.testCases))
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+// the tests waiting to be dispatched, in the order they will be
+func (m model) queue() []int {
+	var queue []int
+	for _, i := range m.dispatchOrder() {
+		if m.testCases[i].state == TestStateWaiting {
+			queue = append(queue, i)
+		}
+	}
+	return queue
+}
+
+// 1-based position of a test in the queue, 0 if it isn't waiting
+func (m model) queuePosition(id int) int {
+	for position, i := range m.queue() {
+		if i == id {
+			return position + 1
+		}
+	}
+	return 0
+}
+
+/*
+ * A rough wait until the test at the given queue position starts: the iterations of the tests ahead
+ * of it, plus those left of the running ones with the current one half done, spread over the threads.
+ * Tests can have different iteration counts, so this averages over iterations rather than tests, and
+ * needs a finished iteration to average over.
+ */
+func (m model) estimatedStart(position int) (time.Duration, bool) {
+	var total time.Duration
+	var finished int
+	var ahead float64
+	for _, testCase := range m.testCases {
+		for _, iteration := range testCase.iterations {
+			if iteration.timeSpanned > 0 {
+				total += iteration.timeSpanned
+				finished++
+			}
+		}
+		if testCase.running {
+			ahead += float64(len(testCase.iterations)-testCase.currIter) - 0.5
+		}
+	}
+	if finished == 0 {
+		return 0, false
+	}
+	for _, i := range m.queue()[:position-1] {
+		ahead += float64(len(m.testCases[i].iterations))
+	}
+	average := total / time.Duration(finished)
+	return time.Duration(ahead*float64(average)) / time.Duration(max(m.maxThreads, 1)), true
+}
+
+/*
+ * Wall time from the first iteration starting to the last one ending, and its lower bound given the
+ * thread budget: the longest test, or the total work spread evenly over the threads.
+ */
+func (m model) makespan() (time.Duration, time.Duration) {
+	var start, end time.Time
+	var work, longest time.Duration
+	for _, testCase := range m.testCases {
+		for _, iteration := range testCase.iterations {
+			if iteration.startTime.IsZero() || iteration.timeSpanned == 0 {
+				continue
+			}
+			if start.IsZero() || iteration.startTime.Before(start) {
+				start = iteration.startTime
+			}
+			if iterEnd := iteration.startTime.Add(iteration.timeSpanned); iterEnd.After(end) {
+				end = iterEnd
+			}
+		}
+		work += testCase.TimeElapsed()
+		longest = max(longest, testCase.TimeElapsed())
+	}
+	if start.IsZero() {
+		return 0, 0
+	}
+	return end.Sub(start), max(longest, work/time.Duration(max(m.maxThreads, 1)))
+}
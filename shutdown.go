@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// seconds, as --kill-grace takes them
+const defaultKillGrace = "2"
+
+// SIGINT or SIGTERM, handled like a quit so qemu is shut down and the results still get written
+type signalMsg struct{ signal os.Signal }
+
+/*
+ * Forwards SIGINT and SIGTERM to the program. bubbletea's own handler would quit on the spot, leaving
+ * qemu running in its own process group and the executors' artifacts half written.
+ */
+func forwardSignals(p *tea.Program) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				p.Send(signalMsg{sig})
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+/*
+ * On timeout or cancellation qemu is asked to quit over its QMP socket, or gets SIGINT without one, so it
+ * exits cleanly and flushes its serial output. Whatever is left of its process group after the grace
+ * period is killed. The guest's run state at that moment is stored in guestStatus when QMP answers.
+ * The returned stop must be called once Wait returns, so the kill can't hit another process group that
+ * reused the pgid after qemu exited within the grace period.
+ */
+func setGracefulShutdown(cmd *exec.Cmd, grace time.Duration, qmpSocket string, guestStatus *string) (stop func()) {
+	// its own process group, so helpers qemu spawns go down with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Wait only returns after Cancel did, so stop sees the timer
+	var kill *time.Timer
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		kill = time.AfterFunc(grace, func() {
+			_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+
+		if qmpSocket != "" {
+			if client, err := dialQMP(qmpSocket); err == nil {
+				defer client.Close()
+				if status, err := client.queryStatus(); err == nil && guestStatus != nil {
+					*guestStatus = status
+				}
+				if client.quit() == nil {
+					return nil
+				}
+			}
+		}
+		return syscall.Kill(-pgid, syscall.SIGINT)
+	}
+	// stop waiting on the output pipe if something still holds it open
+	cmd.WaitDelay = grace + time.Second
+	return func() {
+		if kill != nil {
+			kill.Stop()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	const grace = 300 * time.Millisecond
+	tests := []struct {
+		name    string
+		command string
+		// the signal the process should end with
+		signal syscall.Signal
+	}{
+		{"honors SIGINT", "exec sleep 60", syscall.SIGINT},
+		{"ignores SIGINT", `trap "" INT; sleep 60`, syscall.SIGKILL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cmd := exec.CommandContext(ctx, "sh", "-c", tt.command)
+			stop := setGracefulShutdown(cmd, grace, "", nil)
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+			// the shell has to be past its trap before it is interrupted
+			time.Sleep(200 * time.Millisecond)
+
+			cancelled := time.Now()
+			cancel()
+			err := cmd.Wait()
+			stop()
+			elapsed := time.Since(cancelled)
+
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("Wait() = %v, want the process killed by a signal", err)
+			}
+			status := exitErr.Sys().(syscall.WaitStatus)
+			if !status.Signaled() || status.Signal() != tt.signal {
+				t.Errorf("process ended with %v, want %v", exitErr, tt.signal)
+			}
+			if tt.signal == syscall.SIGINT && elapsed >= grace {
+				t.Errorf("exit after SIGINT took %s, want less than the %s grace", elapsed, grace)
+			}
+			if tt.signal == syscall.SIGKILL && (elapsed < grace || elapsed > grace+2*time.Second) {
+				t.Errorf("kill came after %s, want about the %s grace", elapsed, grace)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/getsentry/sentry-go"
+)
+
+/*
+ * Each test gets a "test" span under the run transaction, with a "build" span and a "run" span
+ * holding one "iteration" span per qemu boot. Test and run spans are only started and finished
+ * from Update, build and iteration spans only inside the goroutine doing the work, so no span is
+ * shared between goroutines while it is still being written to.
+ */
+
+// the category of a failed iteration, tagged on its spans: diff, timeout, crash, no-expected or unimplemented
+func failureCategory(err error) string {
+	var diffErr diffError
+	var missingOk missingOkError
+	var missingCode missingCodeError
+	switch {
+	case errors.As(err, &diffErr):
+		return "diff"
+	case errors.Is(err, errTimedOut):
+		return "timeout"
+	case errors.As(err, &missingOk):
+		return "no-expected"
+	case errors.As(err, &missingCode):
+		return "unimplemented"
+	default:
+		return "crash"
+	}
+}
+
+func failureStatus(category string) sentry.SpanStatus {
+	switch category {
+	case "diff":
+		return sentry.SpanStatusFailedPrecondition
+	case "timeout":
+		return sentry.SpanStatusDeadlineExceeded
+	case "no-expected":
+		return sentry.SpanStatusNotFound
+	case "unimplemented":
+		return sentry.SpanStatusUnimplemented
+	default:
+		return sentry.SpanStatusInternalError
+	}
+}
+
+// sets the status and outcome tag of a span from the message its goroutine returned
+func finishWorkSpan(span *sentry.Span, msg tea.Msg) {
+	switch msg := msg.(type) {
+	case testRunSuccess:
+		span.Status = sentry.SpanStatusOK
+		span.SetTag("outcome", "pass")
+	case testRunError:
+		category := failureCategory(msg.err)
+		span.Status = failureStatus(category)
+		span.SetTag("outcome", category)
+	case testBuildSuccess:
+		span.Status = sentry.SpanStatusOK
+		span.SetTag("outcome", "built")
+	case testBuildErr:
+		span.Status = sentry.SpanStatusAborted
+		span.SetTag("outcome", "compile-error")
+	default:
+		// the goroutine panicked
+		span.Status = sentry.SpanStatusInternalError
+		span.SetTag("outcome", "crash")
+	}
+	span.Finish()
+}
+
+// the status and outcome tag of a test's span, from its final state
+func testOutcome(t testInfo) (sentry.SpanStatus, string) {
+	switch {
+	case !t.resolved:
+		return sentry.SpanStatusCanceled, "canceled"
+	case t.state == TestStateSuccess:
+		return sentry.SpanStatusOK, "pass"
+	case t.state == TestStateCompileFailure:
+		return sentry.SpanStatusAborted, "compile-error"
+	case t.state == TestStateSetupError:
+		return sentry.SpanStatusFailedPrecondition, "setup-error"
+	case t.state == TestStateBlocked:
+		return sentry.SpanStatusFailedPrecondition, "blocked"
+	case t.state == TestStateOverBudget:
+		return sentry.SpanStatusDeadlineExceeded, "over-budget"
+	default:
+		category := failureCategory(t.err)
+		return failureStatus(category), category
+	}
+}
+
+// starts the span of a test as it begins building
+func (m model) startTestSpan(test *testInfo) {
+	test.span = sentry.StartSpan(m.context, "test", sentry.WithDescription(test.name))
+	test.span.SetTag("test", test.name)
+	if test.project != "" {
+		test.span.SetTag("project", test.project)
+	}
+}
+
+// starts the span grouping the iterations of a test once it is built
+func (m model) startRunSpan(test *testInfo) {
+	test.runSpan = test.span.StartChild("run", sentry.WithDescription(fmt.Sprintf("run %s", test.name)))
+	test.runSpan.SetTag("test", test.name)
+}
+
+/*
+ * Finishes the spans of resolved tests, or of every test when the run is over, so tests
+ * cut short by a quit or the budget don't leave their spans open.
+ */
+func (m model) finishTestSpans(all bool) {
+	for i := range m.testCases {
+		test := &m.testCases[i]
+		if test.span == nil || (!test.resolved && !all) {
+			continue
+		}
+
+		status, outcome := testOutcome(*test)
+		if test.runSpan != nil {
+			test.runSpan.Status = status
+			test.runSpan.SetTag("outcome", outcome)
+			test.runSpan.Finish()
+			test.runSpan = nil
+		}
+		test.span.Status = status
+		test.span.SetTag("outcome", outcome)
+		test.span.SetTag("iterations", fmt.Sprint(test.currIter+1))
+		test.span.Finish()
+		test.span = nil
+	}
+}
@@ -94,6 +94,17 @@ func (m Model) Stop() tea.Cmd {
 	}
 }
 
+// StopNow stops the stopwatch immediately instead of through a StartStopMsg,
+// so ticks delivered before that message can't advance it any further.
+func (m Model) StopNow() Model {
+	if m.running {
+		m.running = false
+		m.stopTime = time.Now()
+		m.d = (m.stopTime.Sub(m.startTime) / time.Millisecond) * time.Millisecond
+	}
+	return m
+}
+
 // Toggle stops the stopwatch if it is running and starts it if it is stopped.
 func (m Model) Toggle() tea.Cmd {
 	if m.Running() {
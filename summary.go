@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	xansi "github.com/charmbracelet/x/ansi"
+)
+
+// an iteration killed after --timeout
+var errTimedOut = errors.New("timed out")
+
+// a failed test whose last failing iteration hit the timeout
+func (t testInfo) timedOut() bool {
+	return t.state == TestStateFailure && errors.Is(t.err, errTimedOut)
+}
+
+// tests listed in the time summary of runs with several iterations
+const timeSummaryTests = 10
+
+/*
+ * The tests that took the most time in total over their iterations, with their share of the run,
+ * so the ones that dominated a stress run stand out. Empty unless tests ran several iterations.
+ */
+func (m model) timeSummary() string {
+	var tests []testInfo
+	var total time.Duration
+	for _, testCase := range m.testCases {
+		if testCase.TimeElapsed() > 0 {
+			tests = append(tests, testCase)
+			total += testCase.TimeElapsed()
+		}
+	}
+	if !slices.ContainsFunc(tests, func(t testInfo) bool { return len(t.iterations) > 1 }) {
+		return ""
+	}
+	sort.SliceStable(tests, func(i, j int) bool {
+		return tests[i].TimeElapsed() > tests[j].TimeElapsed()
+	})
+
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Total time by test (%s):\n", total.Round(time.Millisecond)))
+	for _, testCase := range tests[:min(len(tests), timeSummaryTests)] {
+		str.WriteString(fmt.Sprintf("  %s  %10s  %3.0f%%  %s\n", testStyle.Render(testCase.name),
+			testCase.TimeElapsed().Round(time.Millisecond), 100*float64(testCase.TimeElapsed())/float64(total),
+			darkGrayStyle.Render("avg "+testCase.AverageTime().Round(time.Millisecond).String())))
+	}
+	if len(tests) > timeSummaryTests {
+		str.WriteString(darkGrayStyle.Render(fmt.Sprintf("  … %d more", len(tests)-timeSummaryTests)) + "\n")
+	}
+	return str.String()
+}
+
+// tests named on the slowest line of the wall time summary
+const slowestTests = 5
+
+/*
+ * Where the run's time went: the wall time from the first iteration starting to the last one ending,
+ * the qemu time summed over every iteration (more than the wall time when tests ran in parallel), and
+ * the tests with the slowest average iteration. Nil if no iteration ran.
+ */
+func (m model) wallTimeSummary() []string {
+	wall, _ := m.makespan()
+	if wall == 0 {
+		return nil
+	}
+
+	var qemuTime time.Duration
+	var iterations int
+	var tests []testInfo
+	for _, testCase := range m.testCases {
+		for _, iteration := range testCase.iterations {
+			if iteration.timeSpanned > 0 {
+				qemuTime += iteration.timeSpanned
+				iterations++
+			}
+		}
+		if testCase.AverageTime() > 0 {
+			tests = append(tests, testCase)
+		}
+	}
+	sort.SliceStable(tests, func(i, j int) bool {
+		return tests[i].AverageTime() > tests[j].AverageTime()
+	})
+
+	var slowest []string
+	for _, testCase := range tests[:min(len(tests), slowestTests)] {
+		slowest = append(slowest, fmt.Sprintf("%s %s", testCase.name, testCase.AverageTime().Round(time.Millisecond)))
+	}
+
+	return []string{
+		fmt.Sprintf("Wall time: %s", wall.Round(time.Millisecond)),
+		fmt.Sprintf("qemu time: %s over %d iteration(s), %.1f× the wall time with %d thread(s)",
+			qemuTime.Round(time.Millisecond), iterations, float64(qemuTime)/float64(wall), m.maxThreads),
+		fmt.Sprintf("Slowest:   %s (average iteration)", strings.Join(slowest, " · ")),
+	}
+}
+
+// a count in the header, colored like the icon of the rows it counts
+type stateCount struct {
+	label string
+	color lipgloss.Color
+	count int
+}
+
+/*
+ * Counts the tests by outcome for the header. Tests that haven't finished are pending while the run
+ * goes on and not run once it's over, along with the ones cut off by --max-duration.
+ */
+func (m model) stateCounts(finished bool) []stateCount {
+	counts := []stateCount{
+		{"passed", "10", 0},
+		{"unexpectedly passed", "13", 0},
+		{"failed", "9", 0},
+		{"timed out", "9", 0},
+		{"expected failure", "11", 0},
+		{"compile error", "3", 0},
+		{"setup error", "6", 0},
+		{"blocked", "3", 0},
+		{"unimplemented", "5", 0},
+		{"no .ok", "11", 0},
+		{"pending", "7", 0},
+		{"not run", "7", 0},
+	}
+	add := func(label string) {
+		for i := range counts {
+			if counts[i].label == label {
+				counts[i].count++
+			}
+		}
+	}
+
+	for _, t := range m.testCases {
+		switch {
+		case t.unexpectedPass():
+			add("unexpectedly passed")
+		case t.expectedFailure():
+			add("expected failure")
+		case t.timedOut():
+			add("timed out")
+		case t.state == TestStateSuccess:
+			add("passed")
+		case t.state == TestStateFailure:
+			add("failed")
+		case t.state == TestStateCompileFailure:
+			add("compile error")
+		case t.state == TestStateSetupError:
+			add("setup error")
+		case t.state == TestStateBlocked:
+			add("blocked")
+		case t.state == TestStateUnimplemented:
+			add("unimplemented")
+		case t.state == TestStateNoExpected:
+			add("no .ok")
+		case t.state == TestStateOverBudget || finished:
+			add("not run")
+		default:
+			add("pending")
+		}
+	}
+	return counts
+}
+
+// `12 passed · 2 failed · 1 timed out`, skipping empty counts other than passed, cut to fit in width if it's known
+func (m model) summaryView(finished bool, width int) string {
+	var parts []string
+	for _, c := range m.stateCounts(finished) {
+		if c.count == 0 && c.label != "passed" {
+			continue
+		}
+		parts = append(parts, lipgloss.NewStyle().Foreground(c.color).Render(fmt.Sprintf("%d %s", c.count, c.label)))
+	}
+	summary := strings.Join(parts, darkGrayStyle.Render(" · "))
+	if width > 0 {
+		summary = xansi.Truncate(summary, width, "…")
+	}
+	return summary
+}
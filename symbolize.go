@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// lines of kernel output that carry code addresses worth symbolizing, overridable with panic_pattern
+const defaultPanicPattern = `(?i)panic|backtrace|stack trace|page fault|\b[er]ip\b|\bpc\s*[=:]`
+
+var hexAddrRe = regexp.MustCompile(`0x[0-9a-fA-F]{4,16}`)
+
+func compilePanicPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = defaultPanicPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid panic_pattern %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+/*
+ * Annotates the addresses on panic/backtrace lines of the given output with `function (file:line)`.
+ * Returns nil if no line matches, and the raw lines if the ELF or addr2line can't be used.
+ */
+func symbolizePanic(ctx context.Context, dir string, config projectConfig, pattern *regexp.Regexp, output string) []string {
+	var panicLines []string
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(ansiRe.ReplaceAllString(line, ""), "\r")
+		if !pattern.MatchString(line) {
+			continue
+		}
+		lineAddrs := hexAddrRe.FindAllString(line, -1)
+		if len(lineAddrs) == 0 {
+			continue
+		}
+		panicLines = append(panicLines, line)
+		for _, addr := range lineAddrs {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(panicLines) == 0 {
+		return nil
+	}
+
+	symbols := addr2line(ctx, dir, config, addrs)
+	if symbols == nil {
+		return panicLines
+	}
+
+	annotated := make([]string, 0, len(panicLines))
+	for _, line := range panicLines {
+		var notes []string
+		for _, addr := range hexAddrRe.FindAllString(line, -1) {
+			if symbol, ok := symbols[addr]; ok {
+				notes = append(notes, fmt.Sprintf("%s: %s", addr, symbol))
+			}
+		}
+		if len(notes) > 0 {
+			line += "  <- " + strings.Join(notes, ", ")
+		}
+		annotated = append(annotated, line)
+	}
+	return annotated
+}
+
+// resolves addresses against the kernel ELF, returning nil if the ELF or toolchain is unavailable
+func addr2line(ctx context.Context, dir string, config projectConfig, addrs []string) map[string]string {
+	elf, err := findKernelElf(dir, config)
+	if err != nil {
+		return nil
+	}
+
+	tool := config.Addr2line
+	if tool == "" {
+		tool = "addr2line"
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	e := exec.CommandContext(ctx, tool, append([]string{"-f", "-C", "-e", elf}, addrs...)...)
+	e.Stdout = &out
+	if err := e.Run(); err != nil {
+		return nil
+	}
+
+	// addr2line -f prints the function and the file:line on separate lines for each address
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 2*len(addrs) {
+		return nil
+	}
+
+	symbols := make(map[string]string)
+	for i, addr := range addrs {
+		function, location := lines[2*i], lines[2*i+1]
+		if function == "??" {
+			continue
+		}
+		symbols[addr] = fmt.Sprintf("%s (%s)", function, location)
+	}
+	return symbols
+}
+
+/*
+ * Writes the symbolized panic to <test>.panic and attaches it to the iteration's error. The kernel ELF
+ * and pattern are those of the test's own project, which in a batch run isn't the first one's.
+ */
+func annotatePanic(ctx context.Context, m *model, testCase testInfo, runErr testRunError, output string) testRunError {
+	project := m.settings(testCase.makefileDir)
+	lines := symbolizePanic(ctx, testCase.makefileDir, project.config, project.panicPattern, output)
+	if lines == nil {
+		return runErr
+	}
+
+	panicText := strings.Join(lines, "\n")
+	_ = writeFileAtomic(panicPath(testCase), []byte(panicText+"\n"), 0644)
+
+	runErr.err = fmt.Errorf("%w\n%s", runErr.err, panicText)
+	return runErr
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// `// grunner: tags=quick,fs` comments in test sources
+var directiveRe = regexp.MustCompile(`^\s*//\s*grunner:\s*(.*)$`)
+
+// width of the tags column, zero when no test has tags
+var tagStyle = lipgloss.NewStyle()
+
+type testDirectives struct {
+	tags []string
+	// the test is known to fail, see xfail in testInfo
+	xfail bool
+	// make targets to build before the test, see prereqInfo
+	requires []string
+	// overrides -n for the test, zero if not given
+	iterations int
+	// group of tests that can't run at the same time, exclusiveAll for one that runs alone
+	exclusive string
+}
+
+// the exclusive group of `// grunner: exclusive`, a test that overlaps with no other
+const exclusiveAll = "*"
+
+// reads the grunner directives of a test file, or of every file in a .dir test
+func readDirectives(filePath string) testDirectives {
+	var directives testDirectives
+
+	files := []string{filePath}
+	if entries, err := os.ReadDir(filePath); err == nil {
+		files = nil
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(filePath, entry.Name()))
+			}
+		}
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			match := directiveRe.FindStringSubmatch(scanner.Text())
+			if match == nil {
+				continue
+			}
+			for _, field := range strings.Fields(match[1]) {
+				key, value, _ := strings.Cut(field, "=")
+				switch key {
+				case "tags":
+					directives.tags = append(directives.tags, splitList(value)...)
+				case "xfail":
+					directives.xfail = true
+				case "requires":
+					directives.requires = append(directives.requires, splitList(value)...)
+				case "iterations":
+					if n, err := strconv.Atoi(value); err == nil && n > 0 {
+						directives.iterations = n
+					}
+				case "exclusive":
+					directives.exclusive = cmp.Or(value, exclusiveAll)
+				}
+			}
+		}
+		f.Close()
+	}
+	return directives
+}
+
+// parses --iterations-for, e.g. t8=20,t9=20, into iteration counts by test name
+func parseIterationsFor(value string) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, entry := range splitList(value) {
+		name, count, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid --iterations-for entry %q, expected <test>=<iterations>", entry)
+		}
+		counts[strings.TrimSpace(name)] = n
+	}
+	return counts, nil
+}
+
+// comma-separated values with empty entries dropped
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func hasAnyTag(tags []string, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+ * Keeps the tests having any of the given tags (all tests if none are given) and none of the
+ * skipped ones. Naming a tag no test has is an error listing the known tags.
+ */
+func filterByTags(testFiles []testFile, directives map[string]testDirectives, tags []string, skipTags []string) ([]testFile, error) {
+	known := make(map[string]bool)
+	for _, d := range directives {
+		for _, tag := range d.tags {
+			known[tag] = true
+		}
+	}
+	for _, tag := range append(append([]string{}, tags...), skipTags...) {
+		if !known[tag] {
+			var names []string
+			for name := range known {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if len(names) == 0 {
+				return nil, fmt.Errorf("unknown tag %q, no tests are tagged", tag)
+			}
+			return nil, fmt.Errorf("unknown tag %q, known tags: %s", tag, strings.Join(names, ", "))
+		}
+	}
+
+	var filtered []testFile
+	for _, testFile := range testFiles {
+		testTags := directives[testFile.testName].tags
+		if len(tags) > 0 && !hasAnyTag(testTags, tags) {
+			continue
+		}
+		if hasAnyTag(testTags, skipTags) {
+			continue
+		}
+		filtered = append(filtered, testFile)
+	}
+	return filtered, nil
+}
+
+// discovers the tests, applying the --tags and --skip-tags filters
+func discoverTests(args []string, flags *argumentConfig) ([]testFile, map[string]testDirectives, error) {
+	testFiles, findErr := findTestFiles(args, flags.MaxDepth)
+	if findErr != nil && !errors.As(findErr, new(missingTestsError)) {
+		return nil, nil, findErr
+	}
+
+	directives := make(map[string]testDirectives)
+	for _, testFile := range testFiles {
+		directives[testFile.testName] = readDirectives(testFile.filePath)
+	}
+
+	testFiles, err := filterByTags(testFiles, directives, splitList(flags.Tags), splitList(flags.SkipTags))
+	if err != nil {
+		return nil, nil, err
+	}
+	// arguments that matched nothing are reported along with the tests that were found
+	return testFiles, directives, findErr
+}
+
+// whether discovery only failed because of arguments matching no test, which --ignore-missing forgives
+func missingTestsOnly(err error) bool {
+	return errors.As(err, new(missingTestsError))
+}
+
+func formatTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(tags, ",") + "]"
+}
+
+// the test name followed by its tags in gray, padded so the columns after it line up
+func (t testInfo) nameView() string {
+	name := testStyle.Render(t.name)
+	if tagStyle.GetWidth() == 0 {
+		return name
+	}
+	return name + " " + darkGrayStyle.Inherit(tagStyle).Render(formatTags(t.tags))
+}
+
+// prints the tests that would run along with their tags
+func runList(flags *argumentConfig) error {
+	testFiles, directives, err := discoverTests(flags.TestFiles, flags)
+	if missingTestsOnly(err) && flags.IgnoreMissing {
+		fmt.Println(errorStyle.Render(fmt.Sprintf("WARNING: %s.", err)))
+	} else if err != nil {
+		return err
+	}
+	if flags.Changed {
+		var note string
+		testFiles, note, err = filterChanged(testFiles, flags.ChangedWindow)
+		if err != nil {
+			return err
+		}
+		fmt.Println(grayStyle.Render(note))
+	}
+	// by Makefile directory
+	rules := make(map[string][]string)
+	for _, testFile := range testFiles {
+		line := fmt.Sprintf("%s %s", testFile.testName, grayStyle.Render(formatTags(directives[testFile.testName].tags)))
+		if makefile, err := findMakefile(filepath.Dir(testFile.filePath)); err == nil {
+			dir := filepath.Dir(makefile)
+			config, _ := loadProjectConfig(dir)
+			okFile := relativeToMakefile(dir, expectedOutputFile(testFile.filePath, testFile.baseName, testFile.isDir, config))
+			if _, embedded := embeddedExpected(testFile.filePath); !hasExpectedOutput(testInfo{okFile: okFile, makefileDir: dir}) && (testFile.isDir || !embedded) {
+				line += " " + lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("(no .ok file)")
+			}
+			if _, ok := rules[dir]; !ok {
+				rules[dir] = makeRules(dir)
+			}
+			// the setup error the build would end in
+			if target := buildTarget(testFile.baseName, testFile.isDir, config); rules[dir] != nil && !hasMakeRule(rules[dir], target) {
+				line += " " + lipgloss.NewStyle().Foreground(lipgloss.Color("6")).Render("(no make rule for "+target+")")
+			}
+		}
+		// explains why parallelism drops
+		switch exclusive := directives[testFile.testName].exclusive; exclusive {
+		case "":
+		case exclusiveAll:
+			line += " " + grayStyle.Render("(exclusive)")
+		default:
+			line += " " + grayStyle.Render("(exclusive: "+exclusive+")")
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
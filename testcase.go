@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"github.com/charmbracelet/lipgloss"
+	xansi "github.com/charmbracelet/x/ansi"
+	"github.com/getsentry/sentry-go"
 	"grunner/stopwatch"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -16,10 +25,30 @@ const (
 	TestStateRunning
 	TestStateSuccess
 	TestStateFailure
+	// a prerequisite failed to build, so the test never ran
+	TestStateBlocked
+	// the --max-duration budget ran out before the test finished
+	TestStateOverBudget
+	// the test ran but has no .ok file to compare against
+	TestStateNoExpected
+	// the test reached a "*** Missing code at" stub
+	TestStateUnimplemented
+	// the build failed over the project setup, e.g. no make rule for the test, see setupError
+	TestStateSetupError
 )
 
 type testIteration struct {
-	passed      bool
+	passed bool
+	// fraction of the expected lines the output had, 1 for a pass, see --partial
+	score float64
+	// why the iteration failed, nil for a pass
+	err error
+	// where the output of a failed iteration first went wrong, see divergence.go
+	divergence divergence
+	// when the test was handed an executor, set on the first iteration only as the others follow on
+	dispatchTime time.Time
+	// how long the build before the iteration took, first iteration only
+	buildTime   time.Duration
 	startTime   time.Time
 	timeSpanned time.Duration
 }
@@ -28,6 +57,35 @@ type testInfo struct {
 	id       int
 	name     string
 	filePath string
+	// project directory name in batch mode, empty otherwise
+	project string
+	// index of the --compare-config configuration the test runs under, 0 without any
+	config      int
+	makefileDir string
+	// where the test's .raw, .out, .diff and .panic are written
+	artifactDir string
+	// name of the test's make target, image and generated files; name is qualified when tests collide
+	baseName string
+	// a .dir test, see dirtests.go
+	isDir bool
+	// what qemu boots, known once the test is built
+	boot bootMethod
+	// last lines of the debug serial port of the last failed iteration, see --capture-debug
+	debugTail []string
+	// make target building the test and its expected output
+	target string
+	okFile string
+	tags   []string
+	// expected to fail: a failure doesn't count against the run and a pass is flagged
+	xfail bool
+	// make targets that are built once before the test, see prereqInfo
+	requires []string
+	// tests of the same group never run at the same time, see exclusiveAll
+	exclusive string
+	// the iterations the test was meant to run when --timecap cut it short, 0 otherwise
+	cappedFrom int
+	// the project's kernel has been built
+	depsReady bool
 
 	running    bool
 	resolved   bool
@@ -36,6 +94,35 @@ type testInfo struct {
 	stopwatch  stopwatch.Model
 	state      TestState
 	err        error
+	// allowlisted qemu stderr from the last passing iteration
+	warnings []string
+	// compiler warnings from building the test
+	buildWarnings []string
+	// sentry spans of the test and of its iterations, open until it's resolved, see spans.go
+	span    *sentry.Span
+	runSpan *sentry.Span
+	// expected output comparison of the last passing iteration
+	comparison comparison
+	// timeout derived from the first passing iteration with --auto-timeout, zero until then
+	autoTimeout time.Duration
+}
+
+// an xfail test that failed as expected
+func (t testInfo) expectedFailure() bool {
+	return t.xfail && (t.state == TestStateFailure || t.state == TestStateCompileFailure || t.state == TestStateBlocked || t.state == TestStateUnimplemented)
+}
+
+// an xfail test that passed, meaning its annotation is stale
+func (t testInfo) unexpectedPass() bool {
+	return t.xfail && t.state == TestStateSuccess
+}
+
+// a resolved test that turns the run red: a failure that wasn't expected, or an unexpected pass
+func (t testInfo) failsRun() bool {
+	if !t.resolved || t.expectedFailure() {
+		return false
+	}
+	return t.unexpectedPass() || statusRank(t.state) == 0
 }
 
 func (t testInfo) AverageTime() time.Duration {
@@ -62,6 +149,11 @@ func (t testInfo) TimeElapsed() time.Duration {
 	return total
 }
 
+// whether the test is waiting on the result of the 0-based iteration, i.e. it isn't late, out of range or a duplicate
+func (t testInfo) awaitsResult(iteration int) bool {
+	return !t.resolved && iteration == t.currIter && iteration < len(t.iterations) && t.iterations[iteration].timeSpanned == 0
+}
+
 func (t testInfo) CountPassed() int {
 	var count int
 	for _, iteration := range t.iterations {
@@ -72,69 +164,369 @@ func (t testInfo) CountPassed() int {
 	return count
 }
 
+// average score of the iterations that ran, see --partial
+func (t testInfo) PartialScore() float64 {
+	var total float64
+	var count int
+	for _, iteration := range t.iterations {
+		if iteration.timeSpanned > 0 {
+			total += iteration.score
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// red through yellow to green
+var scoreColors = []string{"196", "202", "208", "214", "220", "226", "190", "154", "118", "82"}
+
+// a --partial score as a percentage, colored by how close it is to full credit
+func scoreView(score float64) string {
+	color := scoreColors[min(int(score*float64(len(scoreColors))), len(scoreColors)-1)]
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Bold(true).Render(formatScore(score))
+}
+
+func formatScore(score float64) string {
+	return fmt.Sprintf("%d%%", int(score*100))
+}
+
 var (
 	darkGrayStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
 	statusStyle   = lipgloss.NewStyle().Width(10)
 )
 
+const diffPreviewLines = 6
+
+// the first few lines of the test's diff, indented to sit under its row
+func (t testInfo) DiffPreview(width int) string {
+	var diffErr diffError
+	if !errors.As(t.err, &diffErr) || len(diffErr.diff) == 0 {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimRight(string(diffErr.diff), "\n"), "\n")
+	indent := strings.Repeat(" ", lipgloss.Width(t.nameView())+3)
+	lineStyle := lipgloss.NewStyle().MaxWidth(max(width-len(indent), 10))
+
+	var preview string
+	for _, line := range lines[:min(len(lines), diffPreviewLines)] {
+		preview += indent + lineStyle.Render(line) + "\x1b[0m\n"
+	}
+	if remaining := len(lines) - diffPreviewLines; remaining > 0 {
+		preview += indent + darkGrayStyle.Render(fmt.Sprintf("… %d more lines in %s", remaining, displayPath(diffErr.diffPath))) + "\n"
+	}
+	return preview
+}
+
+const debugTailLines = 5
+
+// last lines of a debug log, nil if there is none
+func readDebugTail(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	return lines[max(len(lines)-debugTailLines, 0):]
+}
+
+func (t testInfo) debugTailView(width int) string {
+	if len(t.debugTail) == 0 {
+		return ""
+	}
+	indent := strings.Repeat(" ", lipgloss.Width(t.nameView())+3)
+	lineStyle := grayStyle.MaxWidth(max(width-len(indent), 10))
+
+	preview := indent + darkGrayStyle.Render("debug log:") + "\n"
+	for _, line := range t.debugTail {
+		preview += indent + lineStyle.Render(ansiRe.ReplaceAllString(line, "")) + "\n"
+	}
+	return preview
+}
+
+// lines of a failure's error shown under its row in verbose mode, the rest is in the test's files
+const errorPreviewLines = 4
+
+// the first line of an error, ellipsized to width
+func inlineError(text string, width int) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(ansiRe.ReplaceAllString(text, "")), "\n")
+	return xansi.Truncate(line, max(width, 10), "…")
+}
+
+/*
+ * An error wrapped to the test's column for verbose mode: the first line fits in width next to the row,
+ * the following ones are indented under it. Past errorPreviewLines it points to the test's files.
+ */
+func (t testInfo) errorView(text string, width int, windowWidth int) (string, string) {
+	indent := strings.Repeat(" ", lipgloss.Width(t.nameView())+3)
+	var lines []string
+	for i, line := range strings.Split(strings.TrimSpace(ansiRe.ReplaceAllString(text, "")), "\n") {
+		if i == 0 {
+			wrapped := strings.Split(xansi.Wrap(line, max(width, 10), ""), "\n")
+			lines = append(lines, wrapped[0])
+			line = strings.Join(wrapped[1:], " ")
+			if line == "" {
+				continue
+			}
+		}
+		lines = append(lines, strings.Split(xansi.Wrap(line, max(windowWidth-len(indent), 10), ""), "\n")...)
+	}
+
+	var more string
+	for _, line := range lines[1:min(len(lines), errorPreviewLines)] {
+		more += indent + errorStyle.Render(line) + "\n"
+	}
+	if len(lines) > errorPreviewLines {
+		files := displayPath(rawPath(t))
+		var diffErr diffError
+		if errors.As(t.err, &diffErr) {
+			files = displayPath(diffErr.diffPath) + " / " + files
+		}
+		more += indent + darkGrayStyle.Render(fmt.Sprintf("… %d more lines, see %s", len(lines)-errorPreviewLines, files)) + "\n"
+	}
+	return lines[0], more
+}
+
+// lines of compiler warnings shown under a row in verbose mode, the detail view has all of them
+const warningPreviewLines = 5
+
+var warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+
+// ⚠ and the number of compiler warnings, empty without any
+func (t testInfo) warningBadge() string {
+	if len(t.buildWarnings) == 0 {
+		return ""
+	}
+	return warningStyle.Render(fmt.Sprintf("⚠ %d", len(t.buildWarnings))) + " "
+}
+
+func (t testInfo) buildWarningsView(width int) string {
+	if len(t.buildWarnings) == 0 {
+		return ""
+	}
+	indent := strings.Repeat(" ", lipgloss.Width(t.nameView())+3)
+	lineStyle := warningStyle.MaxWidth(max(width-len(indent), 10))
+
+	var preview string
+	for _, line := range t.buildWarnings[:min(len(t.buildWarnings), warningPreviewLines)] {
+		preview += indent + lineStyle.Render(line) + "\n"
+	}
+	if remaining := len(t.buildWarnings) - warningPreviewLines; remaining > 0 {
+		preview += indent + darkGrayStyle.Render(fmt.Sprintf("… %d more warning(s)", remaining)) + "\n"
+	}
+	return preview
+}
+
 func (t testInfo) View(m model) string {
 	var (
 		icon         string
 		statusText   string
 		showMoreInfo = true
 		tError       = ""
+		tWarning     = ""
+		windowWidth  = cmp.Or(m.window.width, 80)
 	)
 
 	switch t.state {
 	case TestStateWaiting:
 		showMoreInfo = false
 		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("7")).Render("•")
-		return fmt.Sprintf("%s %s waiting...\n", icon, testStyle.Render(t.name))
+		position := m.queuePosition(t.id)
+		if position == 0 {
+			return fmt.Sprintf("%s %s waiting...\n", icon, t.nameView())
+		}
+		queued := fmt.Sprintf("queued #%d", position)
+		if wait, ok := m.estimatedStart(position); ok && wait >= time.Second {
+			queued += darkGrayStyle.Render(fmt.Sprintf(" (starts in ~%s)", wait.Round(time.Second)))
+		}
+		return fmt.Sprintf("%s %s %s\n", icon, t.nameView(), queued)
 	case TestStateBuilding:
 		showMoreInfo = false
 		icon = m.spinner.View()
-		return fmt.Sprintf("%s %s compiling...\n", icon, testStyle.Render(t.name))
+		return fmt.Sprintf("%s %s compiling...\n", icon, t.nameView())
 	case TestStateRunning:
 		icon = m.spinner.View()
 		statusText = "running..."
 	case TestStateSuccess:
 		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("✔")
 		statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Bold(true).Render("passed!")
+		var notes []string
+		if len(t.comparison.candidates) > 1 {
+			notes = append(notes, fmt.Sprintf("matched %s of %s", filepath.Base(t.comparison.matched), t.comparison.describeCandidates()))
+		}
+		if len(t.warnings) > 0 {
+			notes = append(notes, fmt.Sprintf("suppressed: %s", strings.Join(t.warnings, "; ")))
+		}
+		if !t.boot.usual && t.boot.desc != "" {
+			notes = append(notes, fmt.Sprintf("booted %s", t.boot.desc))
+		}
+		if len(notes) > 0 {
+			tWarning = fmt.Sprintf("(%s)", strings.Join(notes, ", "))
+		}
+		if t.xfail {
+			// loud so the stale xfail annotation gets removed
+			icon = lipgloss.NewStyle().Foreground(lipgloss.Color("13")).Render("!")
+			statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("13")).Bold(true).Render("UNEXPECTEDLY PASSED")
+		}
 	case TestStateFailure:
 		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render("✘")
 		statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Bold(true).Render("failed!")
+		if m.partial {
+			statusText = scoreView(t.PartialScore())
+		}
 		if t.err != nil {
 			tError = t.err.Error()
 		}
+		if t.xfail {
+			icon = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("✘")
+			statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("expected failure")
+		}
 	case TestStateCompileFailure:
 		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("3")).Render("-")
-		return fmt.Sprintf("%s \x1b[37m%s did not compile.\x1b[0m %s\n", icon, testStyle.Render(t.name), grayStyle.Render(tError))
+		if t.xfail {
+			return fmt.Sprintf("%s \x1b[37m%s did not compile.\x1b[0m %s %s\n", icon, t.nameView(), lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("(expected failure)"), grayStyle.Render(tError))
+		}
+		return fmt.Sprintf("%s \x1b[37m%s did not compile.\x1b[0m %s\n", icon, t.nameView(), grayStyle.Render(tError))
+	case TestStateSetupError:
+		// not the code's fault, so it doesn't look like a compile error
+		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("6")).Render("*")
+		prefix := fmt.Sprintf("%s \x1b[37m%s not built.\x1b[0m ", icon, t.nameView())
+		if t.err != nil {
+			tError = t.err.Error()
+		}
+		return prefix + lipgloss.NewStyle().Foreground(lipgloss.Color("6")).Render(inlineError(tError, windowWidth-lipgloss.Width(prefix))) + "\n"
+	case TestStateNoExpected:
+		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("?")
+		statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Bold(true).Render("no .ok")
+		if t.err != nil {
+			tError = t.err.Error()
+		}
+	case TestStateUnimplemented:
+		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("5")).Render("◌")
+		statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("5")).Bold(true).Render("unimplemented")
+		if t.err != nil {
+			tError = t.err.Error()
+		}
+		if t.xfail {
+			icon = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("◌")
+			statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("expected failure")
+		}
+	case TestStateOverBudget:
+		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("7")).Render("•")
+		return fmt.Sprintf("%s %s not run (budget exceeded)\n", icon, t.nameView())
+	case TestStateBlocked:
+		icon = lipgloss.NewStyle().Foreground(lipgloss.Color("3")).Render("-")
+		if t.err != nil && m.verbose {
+			tError = t.err.Error()
+		}
+		prefix := fmt.Sprintf("%s \x1b[37m%s blocked.\x1b[0m ", icon, t.nameView())
+		return prefix + grayStyle.Render(inlineError(tError, windowWidth-lipgloss.Width(prefix))) + "\n"
 	}
 
 	if !t.resolved {
 		icon = m.spinner.View()
 	}
 
-	// only show errors if verbose mode is enabled
+	// without verbose mode errors are cut to one line, and warnings are hidden
 	if !m.verbose {
-		tError = ""
+		tWarning = ""
 	}
 
 	if showMoreInfo {
 		var testCounts string
 		if numIterations := len(t.iterations); numIterations > 1 {
 			testCounts = darkGrayStyle.Render(fmt.Sprintf("(%d/%d) ", t.CountPassed(), numIterations))
+			if t.cappedFrom > 0 {
+				testCounts += darkGrayStyle.Render("(capped) ")
+			}
 		}
 		var shownTime string
-		if t.currIter == 0 {
+		if t.resolved && len(t.iterations) > 1 {
+			// stress runs care about where the time went as much as about a typical iteration
+			shownTime = fmt.Sprintf("avg %s · total %s", t.AverageTime().Round(time.Millisecond), t.TimeElapsed().Round(time.Millisecond))
+		} else if t.currIter == 0 {
 			shownTime = t.stopwatch.View()
 		} else {
 			shownTime = t.AverageTime().String()
 		}
+		if m.verbose && !t.resolved {
+			shownTime += " · timeout " + m.testTimeout(t).String()
+		}
 
 		timeText := darkGrayStyle.Render(fmt.Sprintf("[%s]", shownTime))
-		return fmt.Sprintf("%s %s %s %s%s %s\n", icon, testStyle.Render(t.name), statusStyle.Render(statusText), testCounts, timeText, errorStyle.Render(tError))
+		// xfail statuses are longer than the status column
+		status := statusStyle.Render(statusText)
+		if t.xfail && t.resolved {
+			status = statusText
+		}
+		prefix := fmt.Sprintf("%s %s %s %s%s%s ", icon, t.nameView(), status, t.warningBadge(), testCounts, timeText)
+		errorWidth := windowWidth - lipgloss.Width(prefix) - lipgloss.Width(tWarning)
+		var moreError string
+		if m.verbose {
+			tError, moreError = t.errorView(tError, errorWidth, windowWidth)
+		} else {
+			tError = inlineError(tError, errorWidth)
+		}
+		row := prefix + errorStyle.Render(tError) + grayStyle.Render(tWarning) + "\n" + moreError
+		if m.verbose && t.state == TestStateFailure {
+			row += t.DiffPreview(m.window.width)
+			row += t.debugTailView(m.window.width)
+		}
+		if m.verbose {
+			row += t.buildWarningsView(m.window.width)
+		}
+		return row
 	} else {
-		return fmt.Sprintf("%s %s %s %s\n", icon, testStyle.Render(t.name), statusStyle.Render(statusText), errorStyle.Render(tError))
+		prefix := fmt.Sprintf("%s %s %s ", icon, t.nameView(), statusStyle.Render(statusText))
+		return prefix + errorStyle.Render(inlineError(tError, windowWidth-lipgloss.Width(prefix))) + "\n"
 	}
 }
+
+const (
+	sortByName   = "name"
+	sortByStatus = "status"
+)
+
+func nextSortDisplay(sortDisplay string) string {
+	if sortDisplay == sortByStatus {
+		return sortByName
+	}
+	return sortByStatus
+}
+
+// display group of a test when sorting by status: failures, then in progress, then waiting, then passed
+func statusRank(state TestState) int {
+	switch state {
+	case TestStateFailure, TestStateCompileFailure, TestStateBlocked, TestStateNoExpected, TestStateUnimplemented, TestStateSetupError:
+		return 0
+	case TestStateBuilding, TestStateRunning:
+		return 1
+	case TestStateWaiting:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// indices into testCases in the order they are rendered; message handling always uses the underlying indices
+func (m model) displayOrder() []int {
+	order := make([]int, len(m.testCases))
+	for i := range order {
+		order[i] = i
+	}
+	if m.sortDisplay != sortByStatus {
+		return order
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := m.testCases[order[i]], m.testCases[order[j]]
+		if rankA, rankB := statusRank(a.state), statusRank(b.state); rankA != rankB {
+			return rankA < rankB
+		}
+		return a.name < b.name
+	})
+	return order
+}
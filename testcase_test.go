@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestCountPassed(t *testing.T) {
+	tests := []struct {
+		name   string
+		passed []bool
+		want   int
+	}{
+		{"no iterations", nil, 0},
+		{"none passed", []bool{false, false}, 0},
+		{"some passed", []bool{true, false, true}, 2},
+		{"all passed", []bool{true, true, true}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var test testInfo
+			for _, passed := range tt.passed {
+				test.iterations = append(test.iterations, testIteration{passed: passed})
+			}
+			if got := test.CountPassed(); got != tt.want {
+				t.Errorf("CountPassed() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIterationResultDelivery(t *testing.T) {
+	success := func(iteration int) tea.Msg { return testRunSuccess{int: 0, iteration: iteration} }
+	failure := func(iteration int) tea.Msg {
+		return testRunError{0, iteration, errMsg{err: errors.New("diff found")}}
+	}
+	tests := []struct {
+		name     string
+		msgs     []tea.Msg
+		passed   int
+		currIter int
+		resolved bool
+	}{
+		{"in order", []tea.Msg{success(0), success(1), success(2)}, 3, 2, true},
+		{"duplicate success", []tea.Msg{success(0), success(0)}, 1, 1, false},
+		{"duplicate failure", []tea.Msg{failure(0), failure(0)}, 0, 1, false},
+		{"success after failure of the same iteration", []tea.Msg{failure(0), success(0)}, 0, 1, false},
+		{"ahead of the current iteration", []tea.Msg{success(1)}, 0, 0, false},
+		{"out of order", []tea.Msg{success(1), success(0), success(2), success(1)}, 2, 2, false},
+		{"negative iteration", []tea.Msg{success(-1)}, 0, 0, false},
+		{"past the planned iterations", []tea.Msg{success(3), failure(7)}, 0, 0, false},
+		{"stale after resolution", []tea.Msg{success(0), success(1), failure(2), success(2), failure(2)}, 2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(1)
+			test := &m.testCases[0]
+			test.iterations = make([]testIteration, 3)
+			test.state = TestStateRunning
+			test.running = true
+			test.iterations[0].startTime = time.Now()
+
+			for _, msg := range tt.msgs {
+				m = update(t, m, msg)
+			}
+
+			test = &m.testCases[0]
+			if got := test.CountPassed(); got != tt.passed {
+				t.Errorf("CountPassed() = %d, want %d", got, tt.passed)
+			}
+			if test.currIter != tt.currIter {
+				t.Errorf("currIter = %d, want %d", test.currIter, tt.currIter)
+			}
+			if test.resolved != tt.resolved {
+				t.Errorf("resolved = %v, want %v", test.resolved, tt.resolved)
+			}
+			// the (passed/total) counter never goes over the planned iterations
+			if test.CountPassed() > len(test.iterations) || len(test.iterations) > 3 {
+				t.Errorf("counter (%d/%d) is off", test.CountPassed(), len(test.iterations))
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// test names, paths or directories listed one per line, skipping blank lines and # comments
+func readTestList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tests file: %w", err)
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, nil
+}
+
+// the entries of every --tests-file, in order
+func readTestLists(paths []string) ([]string, error) {
+	var entries []string
+	for _, path := range paths {
+		listed, err := readTestList(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listed...)
+	}
+	return entries, nil
+}
+
+/*
+ * Orders tests by the first tests file entry matching them, for --order file.
+ * Tests only given as arguments come after, by name.
+ */
+func listOrder(testCases []testInfo, entries []string, maxDepth int) []int {
+	ranks := make(map[string]int)
+	for i, entry := range entries {
+		testFiles, _ := findTestFiles([]string{entry}, maxDepth)
+		for _, testFile := range testFiles {
+			if _, ok := ranks[testFile.filePath]; !ok {
+				ranks[testFile.filePath] = i
+			}
+		}
+	}
+
+	order := make([]int, len(testCases))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		rankA, listedA := ranks[filepath.Clean(testCases[order[i]].filePath)]
+		rankB, listedB := ranks[filepath.Clean(testCases[order[j]].filePath)]
+		if listedA && listedB {
+			return rankA < rankB
+		}
+		return listedA && !listedB
+	})
+	return order
+}
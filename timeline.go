@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// width of the mini-Gantt printed at the end of a --timeline run, in characters
+const timelineWidth = 60
+
+// a test's build or one of its iterations, on the thread slot the test held
+type timelineSpan struct {
+	test string
+	// 1-based, 0 for the build
+	iteration int
+	start     time.Time
+	end       time.Time
+	passed    bool
+	slot      int
+}
+
+func (s timelineSpan) build() bool {
+	return s.iteration == 0
+}
+
+/*
+ * The builds and iterations of the run, with the thread slot each ran on. Slots aren't tracked while
+ * running, so they are reconstructed: a test holds one from its dispatch to the end of its last
+ * iteration, and takes the first slot free at its dispatch.
+ */
+func (m model) timeline() []timelineSpan {
+	var tests []testInfo
+	for _, testCase := range m.testCases {
+		if len(testCase.iterations) > 0 && !testCase.iterations[0].dispatchTime.IsZero() {
+			tests = append(tests, testCase)
+		}
+	}
+	sort.SliceStable(tests, func(i, j int) bool {
+		return tests[i].iterations[0].dispatchTime.Before(tests[j].iterations[0].dispatchTime)
+	})
+
+	var spans []timelineSpan
+	// when each slot frees up
+	var slots []time.Time
+	for _, testCase := range tests {
+		dispatch := testCase.iterations[0].dispatchTime
+		testSpans := []timelineSpan{{
+			test:  testCase.name,
+			start: dispatch,
+			end:   dispatch.Add(testCase.iterations[0].buildTime),
+		}}
+		for i, iteration := range testCase.iterations {
+			if iteration.startTime.IsZero() || iteration.timeSpanned == 0 {
+				continue
+			}
+			testSpans = append(testSpans, timelineSpan{
+				test:      testCase.name,
+				iteration: i + 1,
+				start:     iteration.startTime,
+				end:       iteration.startTime.Add(iteration.timeSpanned),
+				passed:    iteration.passed,
+			})
+		}
+
+		end := testSpans[len(testSpans)-1].end
+		slot := len(slots)
+		for i, free := range slots {
+			if !free.After(dispatch) {
+				slot = i
+				break
+			}
+		}
+		if slot == len(slots) {
+			slots = append(slots, end)
+		} else {
+			slots[slot] = end
+		}
+		for _, span := range testSpans {
+			span.slot = slot
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// the earliest and latest time on the timeline
+func timelineBounds(spans []timelineSpan) (time.Time, time.Time) {
+	var start, end time.Time
+	for _, span := range spans {
+		if start.IsZero() || span.start.Before(start) {
+			start = span.start
+		}
+		if span.end.After(end) {
+			end = span.end
+		}
+	}
+	return start, end
+}
+
+// an event of Chrome's Trace Event Format
+type traceEvent struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat,omitempty"`
+	// X for a complete event, M for metadata such as thread names
+	Ph string `json:"ph"`
+	// microseconds since the start of the run
+	Ts   int64          `json:"ts"`
+	Dur  int64          `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+/*
+ * Writes the timeline in Chrome's trace format, loadable in chrome://tracing or Perfetto, with one
+ * track per thread slot.
+ */
+func writeTimeline(path string, spans []timelineSpan) error {
+	origin, _ := timelineBounds(spans)
+	events := []traceEvent{{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]any{"name": "grunner"}}}
+	slots := 0
+	for _, span := range spans {
+		slots = max(slots, span.slot+1)
+		event := traceEvent{
+			Name: span.test,
+			Cat:  "build",
+			Ph:   "X",
+			Ts:   span.start.Sub(origin).Microseconds(),
+			Dur:  span.end.Sub(span.start).Microseconds(),
+			Pid:  1,
+			Tid:  span.slot + 1,
+		}
+		if !span.build() {
+			event.Name = fmt.Sprintf("%s #%d", span.test, span.iteration)
+			event.Cat = "iteration"
+			event.Args = map[string]any{"passed": span.passed}
+		}
+		events = append(events, event)
+	}
+	for slot := range slots {
+		events = append(events, traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: slot + 1, Args: map[string]any{"name": fmt.Sprintf("slot %d", slot+1)}})
+	}
+
+	data, err := json.MarshalIndent(map[string]any{"traceEvents": events, "displayTimeUnit": "ms"}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+/*
+ * A mini-Gantt of the timeline, one row per thread slot: ░ for builds, █ for passing iterations and ▚
+ * for failing ones, gaps being idle slots. A character is a second, or more for runs that wouldn't
+ * fit in timelineWidth.
+ */
+func timelineView(spans []timelineSpan) string {
+	if len(spans) == 0 {
+		return ""
+	}
+	origin, end := timelineBounds(spans)
+	scale := time.Second
+	if total := end.Sub(origin); total > timelineWidth*time.Second {
+		// whole seconds, rounded up so the run still fits
+		scale = ((total+timelineWidth-1)/timelineWidth + time.Second - 1).Truncate(time.Second)
+	}
+	width := max(int((end.Sub(origin)+scale-1)/scale), 1)
+
+	var rows [][]rune
+	for _, span := range spans {
+		for len(rows) <= span.slot {
+			rows = append(rows, []rune(strings.Repeat(" ", width)))
+		}
+		fill := '░'
+		if !span.build() {
+			fill = '█'
+			if !span.passed {
+				fill = '▚'
+			}
+		}
+		from := int(span.start.Sub(origin) / scale)
+		to := max(int((span.end.Sub(origin)+scale-1)/scale), from+1)
+		for i := from; i < min(to, width); i++ {
+			// an iteration wins over the end of its build in a shared character
+			if rows[span.slot][i] == ' ' || rows[span.slot][i] == '░' {
+				rows[span.slot][i] = fill
+			}
+		}
+	}
+
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Timeline (1 character = %s, ░ build, █ passed, ▚ failed)\n", scale))
+	for slot, row := range rows {
+		str.WriteString(fmt.Sprintf("slot %-3d │%s│\n", slot+1, string(row)))
+	}
+	return str.String()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// scales every timeout like --timeout-multiplier, for machines that are slower than the one the
+// timeouts were picked on
+const timeoutMultiplierEnv = "GRUNNER_TIMEOUT_MULTIPLIER"
+
+const (
+	// --auto-timeout: later iterations get this many times the first passing one's duration
+	autoTimeoutFactor = 3
+	// qemu's boot time varies more than that on short tests
+	minAutoTimeout = 2 * time.Second
+)
+
+// the value of --timeout-multiplier validateFlags accepted, 1 when unset
+func multiplierFlag(value string) float64 {
+	multiplier, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 1
+	}
+	return multiplier
+}
+
+// a timeout scaled by --timeout-multiplier
+func (m model) scaleTimeout(timeout time.Duration) time.Duration {
+	if m.timeoutMultiplier <= 0 {
+		return timeout
+	}
+	return time.Duration(float64(timeout) * m.timeoutMultiplier)
+}
+
+// the timeout of the test's next iteration: -t scaled by --timeout-multiplier, or what --auto-timeout derived
+func (m model) testTimeout(t testInfo) time.Duration {
+	if t.autoTimeout > 0 {
+		return t.autoTimeout
+	}
+	return m.iterationTimeout
+}
+
+// the --auto-timeout of a test whose first passing iteration took elapsed
+func autoTimeout(elapsed time.Duration) time.Duration {
+	return max(elapsed*autoTimeoutFactor, minAutoTimeout)
+}
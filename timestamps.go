@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// prefixes every line written through it with the time elapsed since start, e.g. `[   4.312] `
+type timestampWriter struct {
+	w       io.Writer
+	start   time.Time
+	midLine bool
+}
+
+func newTimestampWriter(w io.Writer, start time.Time) *timestampWriter {
+	return &timestampWriter{w: w, start: start}
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	var buf bytes.Buffer
+	for len(p) > 0 {
+		if !t.midLine {
+			fmt.Fprintf(&buf, "[%8.3f] ", time.Since(t.start).Seconds())
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		buf.Write(line)
+		t.midLine = line[len(line)-1] != '\n'
+		p = p[len(line):]
+	}
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	// the prefixes aren't counted, callers only care about their own bytes
+	return n, nil
+}
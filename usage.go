@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exit code of invalid arguments, as opposed to failing tests
+const exitUsage = 2
+
+// flags that are pulled out before parsing, see extractRepeatedFlag
+var repeatedFlags = []string{"--env", "--tests-file", "-f", "--fixture", "--compare-config", "--changed"}
+
+// every flag name the parser knows, from the clap tags of argumentConfig
+func knownFlags() []string {
+	flags := slices.Clone(repeatedFlags)
+	config := reflect.TypeOf(argumentConfig{})
+	for i := range config.NumField() {
+		for _, name := range strings.Split(config.Field(i).Tag.Get("clap"), ",") {
+			if strings.HasPrefix(name, "-") {
+				flags = append(flags, name)
+			}
+		}
+	}
+	return flags
+}
+
+// edit distance between two strings, counting insertions, deletions and substitutions
+func levenshtein(a string, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			diagonal, row[j] = row[j], min(row[j]+1, row[j-1]+1, diagonal+cost)
+		}
+	}
+	return row[len(b)]
+}
+
+// the known flag closest to an unknown one, if it's close enough to be a typo
+func suggestFlag(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "--") {
+		return "", false
+	}
+	var best string
+	bestDistance := -1
+	for _, flag := range knownFlags() {
+		if !strings.HasPrefix(flag, "--") {
+			continue
+		}
+		if distance := levenshtein(arg, flag); bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = flag, distance
+		}
+	}
+	// a third of the name, so short flags don't suggest unrelated ones
+	if bestDistance < 0 || bestDistance > max(2, len(arg)/3) {
+		return "", false
+	}
+	return best, true
+}
+
+// splits --flag=value arguments into --flag value, which the argument parser expects
+func splitFlagValues(args []string) []string {
+	split := make([]string, 0, len(args))
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(name, "--") {
+			split = append(split, name, value)
+		} else {
+			split = append(split, arg)
+		}
+	}
+	return split
+}
+
+/*
+ * Parses the value of a duration flag, plain seconds (10, 1.5) as the flags always took or a Go
+ * duration such as 1500ms or 2m.
+ */
+func parseSeconds(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// the value of a duration flag validateFlags accepted, zero when unset
+func durationFlag(value string) time.Duration {
+	d, _ := parseSeconds(value)
+	return d
+}
+
+// a numeric flag's value checked against the range it makes sense in
+type flagRange struct {
+	flag string
+	// as given, so the message shows what was typed
+	value string
+	ok    bool
+	// the allowed values, for the message
+	allowed string
+}
+
+/*
+ * Checks the numeric flags against sane ranges, returning a message for every violation so they can
+ * all be fixed at once. Zero iterations or a zero timeout would otherwise make for runs that index
+ * empty slices or time out instantly. Duration flags that don't parse are reported as such rather than
+ * as out of range.
+ */
+func validateFlags(flags *argumentConfig) []string {
+	var violations []string
+	between := func(value int, low int, high int) bool { return value >= low && value <= high }
+	// a duration flag's value, and whether it parsed; an empty flag is unset and always valid
+	duration := func(flag string, value string) (time.Duration, bool) {
+		if value == "" {
+			return 0, false
+		}
+		d, err := parseSeconds(value)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("%s %q is not a duration, expected seconds such as 1.5 or a duration such as 1500ms", flag, value))
+			return 0, false
+		}
+		return d, true
+	}
+	timeout, timeoutOk := duration("--timeout", flags.Timeout)
+	timeCap, timeCapOk := duration("--timecap", flags.TimeCap)
+	killGrace, killGraceOk := duration("--kill-grace", flags.KillGrace)
+	stagger, staggerOk := duration("--stagger", flags.Stagger)
+	maxDuration, maxDurationOk := duration("--max-duration", flags.MaxDuration)
+	multiplier, multiplierErr := strconv.ParseFloat(flags.TimeoutMultiplier, 64)
+	if flags.TimeoutMultiplier != "" && multiplierErr != nil {
+		violations = append(violations, fmt.Sprintf("--timeout-multiplier %q is not a number, expected e.g. 1.5", flags.TimeoutMultiplier))
+	}
+	if flags.MinIterations > 0 && !flags.EarlyExit {
+		violations = append(violations, "--min-iterations only applies with --earlyexit")
+	}
+	// instruction counting makes wall-clock time meaningless to the guest, so nothing may be timed by it
+	if flags.Deterministic {
+		timed := []struct {
+			flag string
+			set  bool
+		}{{"--auto-timeout", flags.AutoTimeout}, {"--stagger", flags.Stagger != ""}}
+		for _, conflict := range timed {
+			if conflict.set {
+				violations = append(violations, fmt.Sprintf("--deterministic can't be used with %s, which goes by wall-clock timings", conflict.flag))
+			}
+		}
+	}
+	if flags.EventFd >= 3 && !writableFd(flags.EventFd) {
+		violations = append(violations, fmt.Sprintf("--event-fd %d is not a file descriptor open for writing, e.g. 3>events.jsonl", flags.EventFd))
+	}
+	if flags.SortDisplay != sortByName && flags.SortDisplay != sortByStatus {
+		violations = append(violations, fmt.Sprintf("--sort-display %q is not a display order, expected %s or %s", flags.SortDisplay, sortByName, sortByStatus))
+	}
+	if flags.Order != "" && flags.Order != orderName && flags.Order != orderSlowestFirst && flags.Order != orderFile {
+		violations = append(violations, fmt.Sprintf("--order %q is not a dispatch order, expected %s, %s or %s", flags.Order, orderName, orderSlowestFirst, orderFile))
+	} else if flags.Order == orderFile && len(flags.TestLists) == 0 {
+		violations = append(violations, "--order file needs a --tests-file to take the order from")
+	}
+
+	ranges := []flagRange{
+		{"--iterations", fmt.Sprint(flags.Iterations), between(flags.Iterations, 1, 10000), "1 to 10000"},
+		{"--min-iterations", fmt.Sprint(flags.MinIterations), between(flags.MinIterations, 0, 10000), "0 to 10000"},
+		{"--threads", fmt.Sprint(flags.MaxThreads), between(flags.MaxThreads, 1, 1024), "1 to 1024"},
+		{"--timeout", flags.Timeout, !timeoutOk || (timeout >= 100*time.Millisecond && timeout <= time.Hour), "100ms to 1h"},
+		{"--timeout-multiplier", flags.TimeoutMultiplier, multiplierErr != nil || (multiplier >= 0.1 && multiplier <= 100), "0.1 to 100"},
+		{"--timecap", flags.TimeCap, !timeCapOk || timeCap == -time.Second || timeCap >= time.Second, "-1 for unlimited, or at least 1s"},
+		{"--kill-grace", flags.KillGrace, !killGraceOk || (killGrace >= 0 && killGrace <= time.Minute), "0 to 60s"},
+		{"--stagger", flags.Stagger, !staggerOk || stagger >= 0, "0s or more"},
+		{"--max-duration", flags.MaxDuration, !maxDurationOk || maxDuration > 0, "more than 0s"},
+		{"--max-load", strconv.FormatFloat(flags.MaxLoad, 'g', -1, 64), flags.MaxLoad >= 0, "0 for none, or a load average"},
+		{"--max-depth", fmt.Sprint(flags.MaxDepth), between(flags.MaxDepth, 0, 32), "0 to 32"},
+		{"--trend-runs", fmt.Sprint(flags.TrendRuns), between(flags.TrendRuns, 1, 1000), "1 to 1000"},
+		// stdout and stderr belong to the TUI
+		{"--event-fd", fmt.Sprint(flags.EventFd), flags.EventFd == 0 || flags.EventFd >= 3, "0 for none, or 3 and above"},
+		{"--host-mem-limit", fmt.Sprint(flags.HostMemLimit), flags.HostMemLimit == -1 || flags.HostMemLimit == 0 || flags.HostMemLimit >= 64, "-1 for none, or at least 64 MB"},
+	}
+
+	for _, r := range ranges {
+		if !r.ok {
+			violations = append(violations, fmt.Sprintf("%s %s is out of range, expected %s", r.flag, r.value, r.allowed))
+		}
+	}
+	return violations
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// the flags a run gets without any arguments, which validateFlags accepts
+func validFlags() argumentConfig {
+	return argumentConfig{
+		Iterations:  1,
+		MaxThreads:  1,
+		TimeCap:     "-1",
+		Timeout:     "10",
+		KillGrace:   defaultKillGrace,
+		SortDisplay: sortByName,
+		TrendRuns:   10,
+		MaxDepth:    defaultMaxDepth,
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	// past anything the test binary has open
+	const closedFd = 1000
+
+	tests := []struct {
+		name   string
+		change func(flags *argumentConfig)
+		// the flags the violations name, in order; none when the flags are valid
+		flags []string
+	}{
+		{"defaults", func(flags *argumentConfig) {}, nil},
+		{"zero iterations", func(flags *argumentConfig) { flags.Iterations = 0 }, []string{"--iterations"}},
+		{"negative iterations", func(flags *argumentConfig) { flags.Iterations = -1 }, []string{"--iterations"}},
+		{"most iterations", func(flags *argumentConfig) { flags.Iterations = 10000 }, nil},
+		{"too many iterations", func(flags *argumentConfig) { flags.Iterations = 10001 }, []string{"--iterations"}},
+		{"zero threads", func(flags *argumentConfig) { flags.MaxThreads = 0 }, []string{"--threads"}},
+		{"most threads", func(flags *argumentConfig) { flags.MaxThreads = 1024 }, nil},
+		{"too many threads", func(flags *argumentConfig) { flags.MaxThreads = 1025 }, []string{"--threads"}},
+		{"zero timeout", func(flags *argumentConfig) { flags.Timeout = "0" }, []string{"--timeout"}},
+		{"shortest timeout", func(flags *argumentConfig) { flags.Timeout = "100ms" }, nil},
+		{"too short a timeout", func(flags *argumentConfig) { flags.Timeout = "0.099" }, []string{"--timeout"}},
+		{"longest timeout", func(flags *argumentConfig) { flags.Timeout = "1h" }, nil},
+		{"too long a timeout", func(flags *argumentConfig) { flags.Timeout = "3601" }, []string{"--timeout"}},
+		{"timeout that isn't a duration", func(flags *argumentConfig) { flags.Timeout = "soon" }, []string{"--timeout"}},
+		{"unset timeout", func(flags *argumentConfig) { flags.Timeout = "" }, nil},
+		{"unlimited timecap", func(flags *argumentConfig) { flags.TimeCap = "-1" }, nil},
+		{"zero timecap", func(flags *argumentConfig) { flags.TimeCap = "0" }, []string{"--timecap"}},
+		{"shortest timecap", func(flags *argumentConfig) { flags.TimeCap = "1" }, nil},
+		{"min iterations without earlyexit", func(flags *argumentConfig) { flags.MinIterations = 2 }, []string{"--min-iterations"}},
+		{"min iterations with earlyexit", func(flags *argumentConfig) { flags.MinIterations, flags.EarlyExit = 2, true }, nil},
+		{"display by status", func(flags *argumentConfig) { flags.SortDisplay = sortByStatus }, nil},
+		{"unknown display order", func(flags *argumentConfig) { flags.SortDisplay = "size" }, []string{"--sort-display"}},
+		{"empty display order", func(flags *argumentConfig) { flags.SortDisplay = "" }, []string{"--sort-display"}},
+		{"slowest first", func(flags *argumentConfig) { flags.Order = orderSlowestFirst }, nil},
+		{"unknown dispatch order", func(flags *argumentConfig) { flags.Order = "random" }, []string{"--order"}},
+		{"file order without a tests file", func(flags *argumentConfig) { flags.Order = orderFile }, []string{"--order"}},
+		{"file order with a tests file", func(flags *argumentConfig) { flags.Order, flags.TestLists = orderFile, []string{"tests.txt"} }, nil},
+		{"deterministic", func(flags *argumentConfig) { flags.Deterministic = true }, nil},
+		{"deterministic with auto timeout", func(flags *argumentConfig) { flags.Deterministic, flags.AutoTimeout = true, true }, []string{"--deterministic"}},
+		{"deterministic with stagger", func(flags *argumentConfig) { flags.Deterministic, flags.Stagger = true, "500ms" }, []string{"--deterministic"}},
+		{"deterministic with both", func(flags *argumentConfig) {
+			flags.Deterministic, flags.AutoTimeout, flags.Stagger = true, true, "0"
+		}, []string{"--deterministic", "--deterministic"}},
+		{"auto timeout alone", func(flags *argumentConfig) { flags.AutoTimeout, flags.Stagger = true, "500ms" }, nil},
+		{"event fd open for writing", func(flags *argumentConfig) { flags.EventFd = int(writer.Fd()) }, nil},
+		{"event fd open for reading", func(flags *argumentConfig) { flags.EventFd = int(reader.Fd()) }, []string{"--event-fd"}},
+		{"closed event fd", func(flags *argumentConfig) { flags.EventFd = closedFd }, []string{"--event-fd"}},
+		{"stdout as event fd", func(flags *argumentConfig) { flags.EventFd = 1 }, []string{"--event-fd"}},
+		{"all violations at once", func(flags *argumentConfig) {
+			flags.Iterations, flags.Timeout, flags.SortDisplay, flags.Order = 0, "0", "size", "random"
+		}, []string{"--sort-display", "--order", "--iterations", "--timeout"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := validFlags()
+			tt.change(&flags)
+			violations := validateFlags(&flags)
+			var named []string
+			for _, violation := range violations {
+				flag, _, _ := strings.Cut(violation, " ")
+				named = append(named, flag)
+			}
+			if !slices.Equal(named, tt.flags) {
+				t.Errorf("validateFlags() = %q, want violations of %v", violations, tt.flags)
+			}
+		})
+	}
+}
+
+func TestSplitFlagValues(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"flag value", []string{"grunner", "--timeout=10"}, []string{"grunner", "--timeout", "10"}},
+		{"value with =", []string{"grunner", "--env=A=B"}, []string{"grunner", "--env", "A=B"}},
+		{"value ending in =", []string{"grunner", "--env=A="}, []string{"grunner", "--env", "A="}},
+		{"empty value", []string{"grunner", "--env="}, []string{"grunner", "--env", ""}},
+		{"separate value kept", []string{"grunner", "--env", "A=B"}, []string{"grunner", "--env", "A=B"}},
+		{"short flag kept", []string{"grunner", "-f=x"}, []string{"grunner", "-f=x"}},
+		{"test name with =", []string{"grunner", "t0=1"}, []string{"grunner", "t0=1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitFlagValues(tt.args); !slices.Equal(got, tt.want) {
+				t.Errorf("splitFlagValues(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -15,25 +16,79 @@ import (
 
 var testExtRe = regexp.MustCompile("\\.(cc|dir)$")
 
+// extensions of test files, and of directory tests for .dir, unless --ext or the project config say otherwise
+var defaultTestExts = []string{".cc", ".dir"}
+
+// replaces the test file extensions, e.g. [.c .S] for a course testing C and assembly files
+func setTestExtensions(exts []string) error {
+	quoted := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return fmt.Errorf("invalid test extension %q, expected something like .cc", ext)
+		}
+		quoted = append(quoted, regexp.QuoteMeta(ext[1:]))
+	}
+	testExtRe = regexp.MustCompile("\\.(" + strings.Join(quoted, "|") + ")$")
+	return nil
+}
+
 type testFile = struct {
 	filePath string
 	testName string
+	// the test's own name, testName is qualified with its directory when several tests share it
+	baseName string
+	// a .dir test, which is a directory of sources
+	isDir bool
+}
+
+// arguments that matched no test; findTestFiles still returns the tests the other arguments matched
+type missingTestsError []string
+
+func (e missingTestsError) Error() string {
+	return strings.Join(e, ", ")
+}
+
+func describeMissingTest(arg string) string {
+	if info, err := os.Stat(arg); err == nil && info.IsDir() {
+		return fmt.Sprintf("no tests found in %s/", filepath.Clean(arg))
+	}
+	name := testExtRe.ReplaceAllString(filepath.Base(arg), "")
+	if dir := filepath.Dir(arg); dir != "." {
+		return fmt.Sprintf("no test matching '%s' found in %s/", name, dir)
+	}
+	return fmt.Sprintf("no test matching '%s' found in the current directory", name)
 }
 
 /*
  * Returns a slice of unique test files given from either given 1) directories or 2) files.
  * 1) If a directory is given, searches within the directory for test files.
- * 2) If a file is given, checks if the file exists and if it has a .cc extension (and will attempt to add if not), and adds that.
+ * 2) If a file is given, checks if the file exists and if it has a test extension (and will attempt to add if not), and adds that.
  */
-func findTestFiles(args []string) ([]testFile, error) {
-	uniqueTests := make(map[string]string)
+func findTestFiles(args []string, maxDepth int) ([]testFile, error) {
+	// paths found for each test name, in order
+	uniqueTests := make(map[string][]string)
+	var matched int
+	addTest := func(name string, path string) {
+		matched++
+		// absolute, so the same test reached through different relative paths is only run once
+		if path := absPath(path); !slices.Contains(uniqueTests[name], path) {
+			uniqueTests[name] = append(uniqueTests[name], path)
+		}
+	}
 
 	trimTestExt := func(file string) string {
 		return testExtRe.ReplaceAllString(file, "")
 	}
 
-	for _, arg := range args {
+	targets := make(buildTargets)
+	var missing missingTestsError
+	for i, arg := range args {
 		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			// an empty argument would otherwise match every test in the current directory
+			return nil, fmt.Errorf("test argument %d is empty (an unset variable?)", i+1)
+		}
+		matchedBefore := matched
 
 		//curDirEntries, err := os.ReadDir(".")
 		//if err != nil {
@@ -42,48 +97,183 @@ func findTestFiles(args []string) ([]testFile, error) {
 
 		fileInfo, err := os.Stat(arg)
 		if err == nil && fileInfo.IsDir() && !testExtRe.MatchString(fileInfo.Name()) {
-			entries, err := os.ReadDir(arg)
-			if err != nil {
-				return nil, fmt.Errorf("error reading directory %s: %v", arg, err)
-			}
-			for _, entry := range entries {
-				if testExtRe.MatchString(entry.Name()) {
-					uniqueTests[trimTestExt(entry.Name())] = filepath.Join(arg, entry.Name())
-				}
+			if err := walkTestDir(arg, maxDepth, func(path string) {
+				addTest(trimTestExt(filepath.Base(path)), path)
+			}); err != nil {
+				return nil, err
 			}
 		} else {
-			if strings.Contains(arg, ".") {
-				if _, err := os.Stat(arg); err == nil {
-					uniqueTests[trimTestExt(filepath.Base(arg))] = arg
+			if strings.Contains(filepath.Base(arg), ".") {
+				if isTestPath(arg) {
+					addTest(trimTestExt(filepath.Base(arg)), arg)
 				}
 			} else {
 				entries, _ := os.ReadDir(filepath.Dir(arg))
 
 				testName := filepath.Base(arg)
 				for _, entry := range entries {
-					if strings.HasPrefix(entry.Name(), testName) && testExtRe.MatchString(entry.Name()) {
-						uniqueTests[trimTestExt(entry.Name())] = entry.Name()
+					path := filepath.Join(filepath.Dir(arg), entry.Name())
+					// a guessed match must also be something the Makefile can build, unlike a named one
+					if strings.HasPrefix(entry.Name(), testName) && isTestPath(path) && targets.buildable(path) {
+						addTest(trimTestExt(entry.Name()), path)
 					}
 				}
 			}
 		}
+
+		if matched == matchedBefore {
+			missing = append(missing, describeMissingTest(arg))
+		}
 	}
 
 	result := make([]testFile, 0, len(uniqueTests))
-	for file := range uniqueTests {
-		result = append(result, testFile{
-			filePath: uniqueTests[file],
-			testName: file,
-		})
+	for name, paths := range uniqueTests {
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			result = append(result, testFile{
+				filePath: path,
+				testName: qualifiedTestName(name, path, paths),
+				baseName: name,
+				isDir:    err == nil && info.IsDir(),
+			})
+		}
 	}
 
 	sort.Slice(result, func(i, j int) bool {
 		return strings.Compare(result[i].testName, result[j].testName) < 0
 	})
 
+	if len(missing) > 0 {
+		return result, missing
+	}
 	return result, nil
 }
 
+const defaultMaxDepth = 3
+
+// the absolute path, so tests and their files resolve the same from whichever directory grunner runs in
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// a path relative to the current directory if it is under it, for messages
+func displayPath(path string) string {
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// a test make can build: a file with a test extension, or a directory for .dir tests
+func isTestPath(path string) bool {
+	if !testExtRe.MatchString(filepath.Base(path)) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir() == (filepath.Ext(path) == ".dir")
+}
+
+// the rules of the Makefile of each directory, see buildable
+type buildTargets map[string][]string
+
+/*
+ * Whether the nearest Makefile has a rule for the test at path. A test without a Makefile, or whose
+ * Makefile's rules make can't list, is given the benefit of the doubt.
+ */
+func (b buildTargets) buildable(path string) bool {
+	makefile, err := findMakefile(filepath.Dir(path))
+	if err != nil {
+		return true
+	}
+	dir := filepath.Dir(makefile)
+	rules, ok := b[dir]
+	if !ok {
+		rules = makeRules(dir)
+		b[dir] = rules
+	}
+	if rules == nil {
+		return true
+	}
+	config, _ := loadProjectConfig(dir)
+	name := testExtRe.ReplaceAllString(filepath.Base(path), "")
+	return hasMakeRule(rules, buildTarget(name, filepath.Ext(path) == ".dir", config))
+}
+
+/*
+ * Calls add for every test in dir and its subdirectories, descending at most maxDepth levels.
+ * Build output, .git and hidden directories are skipped.
+ */
+func walkTestDir(dir string, maxDepth int, add func(path string)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if isTestPath(path) {
+			add(path)
+		} else if entry.IsDir() && maxDepth > 0 && entry.Name() != "build" && !strings.HasPrefix(entry.Name(), ".") {
+			if err := walkTestDir(path, maxDepth-1, add); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * Tests with the same name from different directories are told apart by their parent directory
+ * (tests/t1, old_tests/t1), or their whole directory if the parents share a name too.
+ */
+func qualifiedTestName(name string, path string, paths []string) string {
+	if len(paths) == 1 {
+		return name
+	}
+	parent := filepath.Base(filepath.Dir(path))
+	for _, other := range paths {
+		if other != path && filepath.Base(filepath.Dir(other)) == parent {
+			return filepath.ToSlash(filepath.Join(displayPath(filepath.Dir(path)), name))
+		}
+	}
+	return parent + "/" + name
+}
+
+// the directory of each test's nearest Makefile
+func findMakefileDirs(testFiles []testFile) ([]string, error) {
+	found := make(map[string]string)
+	dirs := make([]string, 0, len(testFiles))
+	for _, testFile := range testFiles {
+		dir := filepath.Dir(testFile.filePath)
+		if _, ok := found[dir]; !ok {
+			makefile, err := findMakefile(dir)
+			if err != nil {
+				return nil, err
+			}
+			found[dir] = filepath.Dir(makefile)
+		}
+		dirs = append(dirs, found[dir])
+	}
+	return dirs, nil
+}
+
+// tests sharing a name can only run together if they build under different Makefiles
+func checkNameCollisions(testCases []testInfo) error {
+	seen := make(map[[2]string]testInfo)
+	for _, testCase := range testCases {
+		key := [2]string{testCase.makefileDir, testCase.baseName}
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("%s and %s would both build make target %s in %s, pass only one of them", displayPath(other.filePath), displayPath(testCase.filePath), testCase.baseName, displayPath(testCase.makefileDir))
+		}
+		seen[key] = testCase
+	}
+	return nil
+}
+
 /**
  * Find the closest Makefile to the given directory
  */
@@ -188,3 +378,50 @@ func hashUser() string {
 
 	return strings.TrimSpace(string(output))
 }
+
+// a file written under a temporary name in the same directory and renamed into place by Commit
+type atomicFile struct {
+	*os.File
+	path string
+	perm os.FileMode
+}
+
+func createAtomic(path string, perm os.FileMode) (*atomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: tmp, path: path, perm: perm}, nil
+}
+
+// replaces the destination with what was written so far
+func (f *atomicFile) Commit() error {
+	defer os.Remove(f.Name())
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), f.perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), f.path)
+}
+
+// drops what was written, leaving the destination as it was
+func (f *atomicFile) Discard() {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
+
+// writes the file through a temporary file in the same directory, so readers never see it half-written
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := createAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return f.Commit()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// changes the working directory for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+}
+
+// writes files under root, a name ending in / being a directory
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindTestFilesArguments(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make isn't installed")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		// t2 has no rule, so it is only found when named
+		"Makefile":        "t1:\n\ttrue\nt3:\n\ttrue\n",
+		"tests/t1.cc":     "",
+		"tests/t2.cc":     "",
+		"tests/t3.dir/":   "",
+		"tests/t4.cc/":    "",
+		"tests/notes.txt": "",
+		"other/":          "",
+	})
+
+	tests := []struct {
+		name  string
+		cwd   string
+		arg   string
+		found []string
+		fails bool
+	}{
+		{"empty", "tests", "", nil, true},
+		{"whitespace only", "tests", "  \t", nil, true},
+		{"dot", "tests", ".", []string{"t1", "t2", "t3"}, false},
+		{"dot dot", "tests", "..", []string{"t1", "t2", "t3"}, false},
+		{"dot slash", "tests", "./", []string{"t1", "t2", "t3"}, false},
+		{"three dots", "tests", "...", nil, true},
+		{"inferred through parent", "other", "../tests/t", []string{"t1", "t3"}, false},
+		{"named through parent", "other", "../tests/t2.cc", []string{"t2"}, false},
+		{"directory named like a test", "other", "../tests/t4.cc", nil, true},
+		{"traversal out of the project", "other", "../../../../etc/passwd", nil, true},
+		{"traversal to a non-test", "other", "../tests/notes.txt", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, filepath.Join(root, tt.cwd))
+			testFiles, err := findTestFiles([]string{tt.arg}, defaultMaxDepth)
+			if (err != nil) != tt.fails {
+				t.Fatalf("findTestFiles(%q) error = %v, want error %v", tt.arg, err, tt.fails)
+			}
+			var found []string
+			for _, testFile := range testFiles {
+				found = append(found, testFile.baseName)
+			}
+			slices.Sort(found)
+			if !slices.Equal(found, tt.found) {
+				t.Errorf("findTestFiles(%q) = %v, want %v", tt.arg, found, tt.found)
+			}
+		})
+	}
+}